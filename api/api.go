@@ -8,7 +8,7 @@ import (
 	"os"
 	"strconv"
 	"time"
-	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 )
 
 var serverPort, _ = strconv.Atoi(os.Getenv("SERVER_PORT"))
@@ -16,13 +16,7 @@ var serverPort, _ = strconv.Atoi(os.Getenv("SERVER_PORT"))
 type application struct {
 	infoLog  *log.Logger
 	errorLog *log.Logger
-	Redis    RedisStore
-}
-
-type RedisStore interface {
-	StoreURL(ctx context.Context, tracker *cache.URLTracker) error
-	GetURL(ctx context.Context, id string) (*cache.URLTracker, error)
-	GetAllURLs(ctx context.Context) ([]*cache.URLTracker, error)
+	Store    storage.Store
 }
 
 func (app *application) serve() error {
@@ -44,20 +38,34 @@ func main() {
 	inforLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "redis:6379"
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if indexer, ok := store.(storage.Indexer); ok {
+		indexed, err := indexer.RebuildIndex(context.Background())
+		if err != nil {
+			errorLog.Println("Error rebuilding tracker index:", err)
+		} else if indexed > 0 {
+			inforLog.Println("Indexed", indexed, "existing trackers")
+		}
 	}
-	redisClient := cache.NewRedisClient(redisAddr)
-	defer redisClient.Close()
+
+	inforLog.Println("Using storage backend:", cfg.Describe())
 
 	app := &application{
 		infoLog:  inforLog,
 		errorLog: errorLog,
-		Redis:    redisClient,
+		Store:    store,
 	}
 
-	err := app.serve()
+	err = app.serve()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 	"urltracker/internal"
 	"urltracker/internal/cache"
+	"urltracker/internal/urlnorm"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -15,7 +19,21 @@ import (
 
 func (app *application) Search(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		URL string `json:"url"`
+		URL      string    `json:"url"`
+		Rerun    bool      `json:"rerun"`
+		Priority string    `json:"priority"`
+		RunAt    time.Time `json:"run_at"`
+		// Mode "site" audits the site of URL instead of the page alone,
+		// within MaxDepth and MaxPages. Pages lists other known URLs of
+		// the site, which are checked for orphans. Mode "sitemap" queues
+		// up to MaxURLs pages listed in the sitemaps of URL.
+		Mode     string   `json:"mode"`
+		MaxDepth int      `json:"max_depth"`
+		MaxPages int      `json:"max_pages"`
+		Pages    []string `json:"pages"`
+		MaxURLs  int      `json:"max_urls"`
+		// Analyzers selects the analyzers to run, all of them if empty.
+		Analyzers []string `json:"analyzers"`
 	}
 
 	err := app.readJSON(r, &data)
@@ -31,39 +49,190 @@ func (app *application) Search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create URL tracker
-	tracker := &cache.URLTracker{
-		ID:        uuid.New().String(),
-		URL:       data.URL,
-		Status:    internal.StatusPending,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if data.Priority == "" {
+		data.Priority = internal.PriorityNormal
+	}
+	if !internal.IsValidPriority(data.Priority) {
+		app.badRequest(w, fmt.Errorf("invalid priority %q", data.Priority))
+		return
+	}
+
+	if data.Mode == "" {
+		data.Mode = internal.ModePage
+	}
+	if !internal.IsValidMode(data.Mode) {
+		app.badRequest(w, fmt.Errorf("invalid mode %q", data.Mode))
+		return
 	}
 
-	// Store in Redis
-	err = app.Redis.StoreURL(r.Context(), tracker)
+	analyzers, err := selectAnalyzers(data.Analyzers)
 	if err != nil {
-		app.errorLog.Println("Error storing URL in Redis:", err)
 		app.badRequest(w, err)
 		return
 	}
 
+	canonical, err := urlnorm.Canonicalize(data.URL)
+	if err != nil {
+		app.errorLog.Println("Invalid URL:", data.URL, err)
+		app.badRequest(w, err)
+		return
+	}
+
+	var audit *cache.SiteAudit
+	var sitemap *cache.SitemapBatch
+	switch data.Mode {
+	case internal.ModeSite:
+		for _, p := range data.Pages {
+			if !isValidURL(p) {
+				app.badRequest(w, fmt.Errorf("invalid page URL %q", p))
+				return
+			}
+		}
+		if audit, err = cache.NewSiteAudit(data.MaxDepth, data.MaxPages, data.Pages); err != nil {
+			app.badRequest(w, err)
+			return
+		}
+		// an audit of a site and an analysis of its start page are
+		// tracked separately
+		canonical = siteCanonical(canonical)
+	case internal.ModeSitemap:
+		if sitemap, err = cache.NewSitemapBatch(data.MaxURLs); err != nil {
+			app.badRequest(w, err)
+			return
+		}
+		canonical = sitemapCanonical(canonical)
+	}
+
+	tracker, duplicate, err := app.findOrCreate(r.Context(), &cache.URLTracker{
+		URL:          data.URL,
+		CanonicalURL: canonical,
+		Priority:     data.Priority,
+		NotBefore:    data.RunAt,
+		Audit:        audit,
+		Sitemap:      sitemap,
+		Analyzers:    analyzers,
+	})
+	if err != nil {
+		app.errorLog.Println("Error storing URL:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	if duplicate && data.Rerun {
+		if audit != nil {
+			tracker.Audit = audit
+		}
+		if sitemap != nil {
+			tracker.Sitemap = sitemap
+		}
+		tracker.Analyzers = analyzers
+		if err := app.rerun(r.Context(), tracker, data.Priority, data.RunAt); err != nil {
+			app.errorLog.Println("Error requeueing URL:", err)
+			if errors.Is(err, cache.ErrConflict) {
+				app.errorJSON(w, http.StatusConflict, err)
+				return
+			}
+			app.badRequest(w, err)
+			return
+		}
+	}
+
 	var payload struct {
-		ID    string `json:"id"`
-		Error bool   `json:"error"`
+		ID        string `json:"id"`
+		Duplicate bool   `json:"duplicate"`
+		Error     bool   `json:"error"`
 	}
 
 	payload.ID = tracker.ID
+	payload.Duplicate = duplicate
 	payload.Error = false
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
+// findOrCreate returns the tracker already registered for the canonical URL
+// of submission, or stores and queues submission as a new tracker. Its
+// priority picks the lane and NotBefore holds it back; new trackers with an
+// audit are site audits and those with a sitemap batch sitemap submissions.
+// The boolean reports whether the tracker already existed.
+func (app *application) findOrCreate(ctx context.Context, submission *cache.URLTracker) (*cache.URLTracker, bool, error) {
+	existing, err := app.Store.FindByCanonical(ctx, submission.CanonicalURL)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, true, nil
+	}
+
+	tracker := submission
+	tracker.ID = uuid.New().String()
+	tracker.Status = internal.StatusPending
+	tracker.CreatedAt = time.Now()
+	tracker.UpdatedAt = time.Now()
+
+	err = app.Store.StoreURL(ctx, tracker)
+	if errors.Is(err, cache.ErrDuplicateURL) {
+		// a concurrent submission of the same URL won the race
+		existing, err = app.Store.FindByCanonical(ctx, tracker.CanonicalURL)
+		if err == nil && existing == nil {
+			err = cache.ErrDuplicateURL
+		}
+		return existing, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return tracker, false, nil
+}
+
+// rerun queues a new analysis of an existing tracker in the lane of
+// priority, to run no earlier than runAt. Trackers that are still waiting,
+// being processed or waiting for a retry are left alone.
+func (app *application) rerun(ctx context.Context, tracker *cache.URLTracker, priority string, runAt time.Time) error {
+	switch tracker.Status {
+	case internal.StatusPending, internal.StatusProcessing, internal.StatusRetrying:
+		return nil
+	}
+
+	tracker.Status = internal.StatusPending
+	tracker.Priority = priority
+	tracker.NotBefore = runAt
+	tracker.Error = ""
+	tracker.Attempts = 0
+	tracker.Progress = nil
+	tracker.UpdatedAt = time.Now()
+	if err := app.Store.UpdateURL(ctx, tracker); err != nil {
+		return err
+	}
+
+	if !runAt.IsZero() {
+		err := app.Store.ScheduleURL(ctx, tracker.ID, tracker.Priority, runAt)
+		if err != nil {
+			return err
+		}
+	} else if err := app.Store.EnqueueURL(ctx, tracker.ID, tracker.Priority); err != nil {
+		return err
+	}
+
+	return app.clearDeadLetter(ctx, tracker.ID)
+}
+
+// clearDeadLetter removes the dead-letter entry of a tracker that is queued
+// again, if it has one.
+func (app *application) clearDeadLetter(ctx context.Context, id string) error {
+	err := app.Store.RemoveDeadLetter(ctx, id)
+	if errors.Is(err, cache.ErrNoDeadLetter) {
+		return nil
+	}
+	return err
+}
+
 func (app *application) GetTrackingStatus(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	tracker, err := app.Redis.GetURL(r.Context(), id)
+	tracker, err := app.Store.GetURL(r.Context(), id)
 	if err != nil {
-		app.errorLog.Println("Error retrieving URL from Redis:", err)
+		app.errorLog.Println("Error retrieving URL:", err)
 		app.badRequest(w, err)
 		return
 	}
@@ -71,6 +240,199 @@ func (app *application) GetTrackingStatus(w http.ResponseWriter, r *http.Request
 	app.writeJSON(w, http.StatusOK, tracker)
 }
 
+func (app *application) GetTrackingRuns(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, err := app.Store.GetURL(r.Context(), id); err != nil {
+		app.errorLog.Println("Error retrieving URL:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	runs, err := app.Store.GetRuns(r.Context(), id)
+	if err != nil {
+		app.errorLog.Println("Error retrieving runs:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, runs)
+}
+
+// GetTrackingBatch summarizes the trackers a sitemap submission queued.
+func (app *application) GetTrackingBatch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	tracker, err := app.Store.GetURL(r.Context(), id)
+	if err != nil {
+		app.errorLog.Println("Error retrieving URL:", err)
+		app.badRequest(w, err)
+		return
+	}
+	if tracker.Sitemap == nil {
+		app.errorJSON(w, http.StatusNotFound, fmt.Errorf("tracker %s is not a sitemap submission", id))
+		return
+	}
+
+	trackers, err := app.Store.GetBatch(r.Context(), id)
+	if err != nil {
+		app.errorLog.Println("Error retrieving batch:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, cache.SummarizeBatch(trackers))
+}
+
+func (app *application) ListTracking(w http.ResponseWriter, r *http.Request) {
+	opts, err := cache.ParseListOptions(r.URL.Query())
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	page, err := app.Store.ListURLs(r.Context(), opts)
+	if err != nil {
+		app.errorLog.Println("Error listing URLs:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, page)
+}
+
+func (app *application) GetQueueStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.Store.QueueStats(r.Context())
+	if err != nil {
+		app.errorLog.Println("Error reading queue stats:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, stats)
+}
+
+func (app *application) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := app.Store.ListDeadLetters(r.Context())
+	if err != nil {
+		app.errorLog.Println("Error listing dead letters:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, letters)
+}
+
+// RequeueDeadLetter queues a dead-lettered tracker again with a fresh attempt
+// count and removes its dead-letter entry.
+func (app *application) RequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, err := app.Store.GetDeadLetter(r.Context(), id); err != nil {
+		if errors.Is(err, cache.ErrNoDeadLetter) {
+			app.errorJSON(w, http.StatusNotFound, err)
+			return
+		}
+		app.errorLog.Println("Error retrieving dead letter:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	tracker, err := app.Store.GetURL(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			app.errorJSON(w, http.StatusNotFound, fmt.Errorf("tracker %s no longer exists, purge its dead letter instead", id))
+			return
+		}
+		app.errorLog.Println("Error retrieving URL:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	switch tracker.Status {
+	case internal.StatusPending, internal.StatusProcessing, internal.StatusRetrying:
+		// rerun would leave it alone and the dead letter would go stale
+		app.errorJSON(w, http.StatusConflict, fmt.Errorf("tracker %s is %s, requeue it once it has finished", id, tracker.Status))
+		return
+	}
+
+	if err := app.rerun(r.Context(), tracker, cache.NormalizePriority(tracker.Priority), time.Time{}); err != nil {
+		app.errorLog.Println("Error requeueing dead letter:", err)
+		if errors.Is(err, cache.ErrConflict) {
+			app.errorJSON(w, http.StatusConflict, err)
+			return
+		}
+		app.badRequest(w, err)
+		return
+	}
+
+	var payload struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	payload.ID = tracker.ID
+	payload.Status = tracker.Status
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := app.Store.RemoveDeadLetter(r.Context(), id); err != nil {
+		if errors.Is(err, cache.ErrNoDeadLetter) {
+			app.errorJSON(w, http.StatusNotFound, err)
+			return
+		}
+		app.errorLog.Println("Error removing dead letter:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	purged, err := app.Store.PurgeDeadLetters(r.Context())
+	if err != nil {
+		app.errorLog.Println("Error purging dead letters:", err)
+		app.badRequest(w, err)
+		return
+	}
+
+	var payload struct {
+		Purged int `json:"purged"`
+	}
+	payload.Purged = purged
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// siteCanonical is the canonical key of a site audit starting at the page
+// with the given canonical URL.
+func siteCanonical(canonical string) string {
+	return "site:" + canonical
+}
+
+// sitemapCanonical is the canonical key of a sitemap submission of the given
+// canonical URL.
+func sitemapCanonical(canonical string) string {
+	return "sitemap:" + canonical
+}
+
+// selectAnalyzers checks a selection of analyzers and drops repeated names.
+// An empty selection is nil, which runs all analyzers.
+func selectAnalyzers(names []string) ([]string, error) {
+	var selected []string
+	for _, name := range names {
+		if !internal.IsValidAnalyzer(name) {
+			return nil, fmt.Errorf("unknown analyzer %q, must be one of %s", name, strings.Join(internal.Analyzers, ", "))
+		}
+		if !slices.Contains(selected, name) {
+			selected = append(selected, name)
+		}
+	}
+	return selected, nil
+}
+
 func isValidURL(u string) bool {
 	u = strings.TrimSpace(u)
 
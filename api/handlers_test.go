@@ -6,33 +6,116 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 	"urltracker/internal"
 	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 )
 
-type mockRedisStore struct {
-	storeCalled      bool
-	storedTracker    *cache.URLTracker
-	storeErr         error
-	getURLResult     *cache.URLTracker
-	getURLErr        error
-	getAllURLsResult []*cache.URLTracker
-	getAllURLsErr    error
+type mockStore struct {
+	storage.Store
+	storeCalled   bool
+	storedTracker *cache.URLTracker
+	storeErr      error
+	getURLResult  *cache.URLTracker
+	getURLErr     error
+	listResult    *cache.URLPage
+	listErr       error
+	listOpts      cache.ListOptions
+	canonical     *cache.URLTracker
+	updates       []*cache.URLTracker
+	enqueued      []string
+	priorities    []string
+	scheduled     map[string]time.Time
+	deadLetters   map[string]*cache.DeadLetter
+	removed       []string
+	runs          []*cache.AnalysisRun
+	queueStats    *cache.QueueStats
+	batch         []*cache.URLTracker
 }
 
-func (m *mockRedisStore) StoreURL(ctx context.Context, tracker *cache.URLTracker) error {
+func (m *mockStore) StoreURL(ctx context.Context, tracker *cache.URLTracker) error {
 	m.storeCalled = true
 	m.storedTracker = tracker
 	return m.storeErr
 }
 
-func (m *mockRedisStore) GetURL(ctx context.Context, id string) (*cache.URLTracker, error) {
+func (m *mockStore) GetURL(ctx context.Context, id string) (*cache.URLTracker, error) {
 	return m.getURLResult, m.getURLErr
 }
 
-func (m *mockRedisStore) GetAllURLs(ctx context.Context) ([]*cache.URLTracker, error) {
-	return m.getAllURLsResult, m.getAllURLsErr
+func (m *mockStore) UpdateURL(ctx context.Context, t *cache.URLTracker) error {
+	copy := *t
+	m.updates = append(m.updates, &copy)
+	return nil
+}
+
+func (m *mockStore) EnqueueURL(ctx context.Context, id, priority string) error {
+	m.enqueued = append(m.enqueued, id)
+	m.priorities = append(m.priorities, priority)
+	return nil
+}
+
+func (m *mockStore) ScheduleURL(ctx context.Context, id, priority string, at time.Time) error {
+	if m.scheduled == nil {
+		m.scheduled = make(map[string]time.Time)
+	}
+	m.scheduled[id] = at
+	return nil
+}
+
+func (m *mockStore) ListDeadLetters(ctx context.Context) ([]*cache.DeadLetter, error) {
+	letters := []*cache.DeadLetter{}
+	for _, d := range m.deadLetters {
+		letters = append(letters, d)
+	}
+	return letters, nil
+}
+
+func (m *mockStore) GetDeadLetter(ctx context.Context, id string) (*cache.DeadLetter, error) {
+	if d, ok := m.deadLetters[id]; ok {
+		return d, nil
+	}
+	return nil, cache.ErrNoDeadLetter
+}
+
+func (m *mockStore) RemoveDeadLetter(ctx context.Context, id string) error {
+	if _, ok := m.deadLetters[id]; !ok {
+		return cache.ErrNoDeadLetter
+	}
+	delete(m.deadLetters, id)
+	m.removed = append(m.removed, id)
+	return nil
+}
+
+func (m *mockStore) PurgeDeadLetters(ctx context.Context) (int, error) {
+	n := len(m.deadLetters)
+	m.deadLetters = nil
+	return n, nil
+}
+
+func (m *mockStore) FindByCanonical(ctx context.Context, canonical string) (*cache.URLTracker, error) {
+	return m.canonical, nil
+}
+
+func (m *mockStore) GetRuns(ctx context.Context, id string) ([]*cache.AnalysisRun, error) {
+	return m.runs, nil
+}
+
+func (m *mockStore) QueueStats(ctx context.Context) (*cache.QueueStats, error) {
+	return m.queueStats, nil
+}
+
+func (m *mockStore) GetBatch(ctx context.Context, batch string) ([]*cache.URLTracker, error) {
+	return m.batch, nil
+}
+
+func (m *mockStore) ListURLs(ctx context.Context, opts cache.ListOptions) (*cache.URLPage, error) {
+	m.listOpts = opts
+	return m.listResult, m.listErr
 }
 
 func TestIsValidURL(t *testing.T) {
@@ -118,8 +201,8 @@ func TestSearchHandlerInvalidJSON(t *testing.T) {
 
 func TestSearchHandlerSuccess_MockedRedis(t *testing.T) {
 	app := newTestApplication()
-	mockRedis := &mockRedisStore{}
-	app.Redis = mockRedis
+	store := &mockStore{}
+	app.Store = store
 
 	inputURL := "https://example.com"
 	r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(`{"url":"`+inputURL+`"}`))
@@ -131,20 +214,24 @@ func TestSearchHandlerSuccess_MockedRedis(t *testing.T) {
 		t.Errorf("Search() status = %v, want %v", w.Code, http.StatusOK)
 	}
 
-	if !mockRedis.storeCalled {
-		t.Fatal("Search() did not call Redis StoreURL")
+	if !store.storeCalled {
+		t.Fatal("Search() did not call StoreURL")
 	}
 
-	if mockRedis.storedTracker == nil {
+	if store.storedTracker == nil {
 		t.Fatal("Search() did not store tracker")
 	}
 
-	if mockRedis.storedTracker.URL != inputURL {
-		t.Errorf("Search() stored URL = %q, want %q", mockRedis.storedTracker.URL, inputURL)
+	if store.storedTracker.URL != inputURL {
+		t.Errorf("Search() stored URL = %q, want %q", store.storedTracker.URL, inputURL)
+	}
+
+	if store.storedTracker.CanonicalURL != "https://example.com/" {
+		t.Errorf("Search() stored CanonicalURL = %q, want %q", store.storedTracker.CanonicalURL, "https://example.com/")
 	}
 
-	if mockRedis.storedTracker.Status != internal.StatusPending {
-		t.Errorf("Search() stored Status = %q, want %q", mockRedis.storedTracker.Status, internal.StatusPending)
+	if store.storedTracker.Status != internal.StatusPending {
+		t.Errorf("Search() stored Status = %q, want %q", store.storedTracker.Status, internal.StatusPending)
 	}
 
 	var response struct {
@@ -165,6 +252,503 @@ func TestSearchHandlerSuccess_MockedRedis(t *testing.T) {
 	}
 }
 
+func TestSearchHandlerDuplicate(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		status       string
+		wantEnqueued int
+	}{
+		{"returns existing tracker", `{"url":"https://EXAMPLE.com/#top"}`, internal.StatusCompleted, 0},
+		{"rerun completed tracker", `{"url":"https://example.com","rerun":true}`, internal.StatusCompleted, 1},
+		{"rerun skips pending tracker", `{"url":"https://example.com","rerun":true}`, internal.StatusPending, 0},
+		{"rerun skips retrying tracker", `{"url":"https://example.com","rerun":true}`, internal.StatusRetrying, 0},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication()
+		store := &mockStore{
+			canonical: &cache.URLTracker{ID: "existing", URL: "https://example.com", Status: tt.status},
+		}
+		app.Store = store
+
+		r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		app.Search(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: Search() status = %v, want %v", tt.name, w.Code, http.StatusOK)
+		}
+		if store.storeCalled {
+			t.Errorf("%s: Search() stored a new tracker for a duplicate URL", tt.name)
+		}
+		if len(store.enqueued) != tt.wantEnqueued {
+			t.Errorf("%s: Search() enqueued = %v, want %d", tt.name, store.enqueued, tt.wantEnqueued)
+		}
+
+		var response struct {
+			ID        string `json:"id"`
+			Duplicate bool   `json:"duplicate"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: Search() response decode error = %v", tt.name, err)
+		}
+		if response.ID != "existing" || !response.Duplicate {
+			t.Errorf("%s: Search() response = %+v, want existing duplicate", tt.name, response)
+		}
+	}
+}
+
+func TestSearchHandlerPriority(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantCode     int
+		wantPriority string
+	}{
+		{"defaults to normal", `{"url":"https://example.com"}`, http.StatusOK, internal.PriorityNormal},
+		{"interactive", `{"url":"https://example.com","priority":"interactive"}`, http.StatusOK, internal.PriorityInteractive},
+		{"bulk", `{"url":"https://example.com","priority":"bulk"}`, http.StatusOK, internal.PriorityBulk},
+		{"invalid", `{"url":"https://example.com","priority":"urgent"}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication()
+		store := &mockStore{}
+		app.Store = store
+
+		r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		app.Search(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: Search() status = %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			if store.storeCalled {
+				t.Errorf("%s: Search() stored a tracker with an invalid priority", tt.name)
+			}
+			continue
+		}
+		if store.storedTracker.Priority != tt.wantPriority {
+			t.Errorf("%s: Search() stored Priority = %q, want %q", tt.name, store.storedTracker.Priority, tt.wantPriority)
+		}
+	}
+}
+
+func TestSearchHandlerAnalyzers(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     []string
+	}{
+		{"all by default", `{"url":"https://example.com"}`, http.StatusOK, nil},
+		{"selected", `{"url":"https://example.com","analyzers":["links","document","links"]}`, http.StatusOK, []string{"links", "document"}},
+		{"unknown", `{"url":"https://example.com","analyzers":["spelling"]}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication()
+		store := &mockStore{}
+		app.Store = store
+
+		r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		app.Search(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: Search() status = %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			if store.storeCalled {
+				t.Errorf("%s: Search() stored a tracker with an invalid selection", tt.name)
+			}
+			continue
+		}
+		if got := store.storedTracker.Analyzers; !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Search() stored Analyzers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a rerun takes the new selection
+	app := newTestApplication()
+	store := &mockStore{canonical: &cache.URLTracker{ID: "existing", Status: internal.StatusCompleted, Analyzers: []string{"login"}}}
+	app.Store = store
+	r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(`{"url":"https://example.com","rerun":true,"analyzers":["document"]}`))
+	app.Search(httptest.NewRecorder(), r)
+	if len(store.updates) != 1 || !slices.Equal(store.updates[0].Analyzers, []string{"document"}) {
+		t.Errorf("rerun updates = %+v, want the document analyzer selected", store.updates)
+	}
+}
+
+func TestSearchHandlerSiteAudit(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		wantAudit *cache.SiteAudit
+	}{
+		{"page by default", `{"url":"https://example.com"}`, http.StatusOK, nil},
+		{"site with defaults", `{"url":"https://example.com","mode":"site"}`, http.StatusOK, &cache.SiteAudit{MaxDepth: cache.DefaultAuditDepth, MaxPages: cache.DefaultAuditPages}},
+		{"site with limits", `{"url":"https://example.com","mode":"site","max_depth":4,"max_pages":200,"pages":["https://example.com/old"]}`, http.StatusOK, &cache.SiteAudit{MaxDepth: 4, MaxPages: 200, Pages: []string{"https://example.com/old"}}},
+		{"invalid mode", `{"url":"https://example.com","mode":"domain"}`, http.StatusBadRequest, nil},
+		{"too many pages", `{"url":"https://example.com","mode":"site","max_pages":100000}`, http.StatusBadRequest, nil},
+		{"too deep", `{"url":"https://example.com","mode":"site","max_depth":-1}`, http.StatusBadRequest, nil},
+		{"invalid page", `{"url":"https://example.com","mode":"site","pages":["ftp://example.com/"]}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication()
+		store := &mockStore{}
+		app.Store = store
+
+		r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		app.Search(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: Search() status = %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			if store.storeCalled {
+				t.Errorf("%s: Search() stored a tracker for an invalid request", tt.name)
+			}
+			continue
+		}
+
+		got := store.storedTracker
+		if tt.wantAudit == nil {
+			if got.Audit != nil || got.CanonicalURL != "https://example.com/" {
+				t.Errorf("%s: Search() stored %+v, want a page tracker", tt.name, got)
+			}
+			continue
+		}
+		if got.Audit == nil || got.Audit.MaxDepth != tt.wantAudit.MaxDepth || got.Audit.MaxPages != tt.wantAudit.MaxPages || len(got.Audit.Pages) != len(tt.wantAudit.Pages) {
+			t.Errorf("%s: Search() stored Audit = %+v, want %+v", tt.name, got.Audit, tt.wantAudit)
+		}
+		if got.CanonicalURL != "site:https://example.com/" {
+			t.Errorf("%s: Search() stored CanonicalURL = %q, want it apart from the page", tt.name, got.CanonicalURL)
+		}
+	}
+}
+
+func TestSearchHandlerSitemap(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantMaxURLs int
+	}{
+		{"defaults", `{"url":"https://example.com","mode":"sitemap"}`, http.StatusOK, cache.DefaultSitemapURLs},
+		{"with limit", `{"url":"https://example.com/sitemap.xml","mode":"sitemap","max_urls":20}`, http.StatusOK, 20},
+		{"too many URLs", `{"url":"https://example.com","mode":"sitemap","max_urls":100000}`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication()
+		store := &mockStore{}
+		app.Store = store
+
+		r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+
+		app.Search(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: Search() status = %v, want %v", tt.name, w.Code, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			if store.storeCalled {
+				t.Errorf("%s: Search() stored a tracker for an invalid request", tt.name)
+			}
+			continue
+		}
+
+		got := store.storedTracker
+		if got.Sitemap == nil || got.Sitemap.MaxURLs != tt.wantMaxURLs || got.Audit != nil {
+			t.Errorf("%s: Search() stored Sitemap = %+v, want %d URLs", tt.name, got.Sitemap, tt.wantMaxURLs)
+		}
+		if !strings.HasPrefix(got.CanonicalURL, "sitemap:https://example.com/") {
+			t.Errorf("%s: Search() stored CanonicalURL = %q, want it apart from the page", tt.name, got.CanonicalURL)
+		}
+	}
+}
+
+func TestGetTrackingBatchHandler(t *testing.T) {
+	app := newTestApplication()
+	store := &mockStore{
+		getURLResult: &cache.URLTracker{ID: "abc", Sitemap: &cache.SitemapBatch{MaxURLs: 10}},
+		batch: []*cache.URLTracker{
+			{ID: "1", URL: "https://example.com/a", Status: internal.StatusCompleted},
+			{ID: "2", URL: "https://example.com/b", Status: internal.StatusFailed, Error: "404 Not Found"},
+			{ID: "3", URL: "https://example.com/c", Status: internal.StatusPending},
+		},
+	}
+	app.Store = store
+	router := app.routes()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tracking/abc/batch", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetTrackingBatch() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var summary cache.BatchSummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("GetTrackingBatch() response decode error = %v", err)
+	}
+	if summary.Total != 3 || summary.Counts[internal.StatusCompleted] != 1 || len(summary.Failed) != 1 || summary.Failed[0].Error != "404 Not Found" {
+		t.Errorf("GetTrackingBatch() response = %+v", summary)
+	}
+
+	// page trackers have no batch
+	store.getURLResult = &cache.URLTracker{ID: "abc"}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tracking/abc/batch", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GetTrackingBatch() of a page status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchHandlerRerunSiteAudit(t *testing.T) {
+	app := newTestApplication()
+	store := &mockStore{
+		canonical: &cache.URLTracker{
+			ID:       "existing",
+			URL:      "https://example.com",
+			Status:   internal.StatusCompleted,
+			Audit:    &cache.SiteAudit{MaxDepth: 1, MaxPages: 10},
+			Progress: &cache.Progress{Done: 10, Limit: 10},
+		},
+	}
+	app.Store = store
+
+	body := `{"url":"https://example.com","rerun":true,"mode":"site","max_pages":100}`
+	r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	app.Search(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Search() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if len(store.updates) != 1 {
+		t.Fatalf("Search() updates = %d, want 1", len(store.updates))
+	}
+	if u := store.updates[0]; u.Audit.MaxPages != 100 || u.Progress != nil {
+		t.Errorf("Search() updated Audit = %+v, Progress = %+v, want the new limits and no progress", u.Audit, u.Progress)
+	}
+}
+
+func TestSearchHandlerRerunPriority(t *testing.T) {
+	app := newTestApplication()
+	store := &mockStore{
+		canonical: &cache.URLTracker{ID: "existing", URL: "https://example.com", Status: internal.StatusCompleted, Priority: internal.PriorityBulk},
+	}
+	app.Store = store
+
+	body := `{"url":"https://example.com","rerun":true,"priority":"interactive"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	app.Search(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Search() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if len(store.priorities) != 1 || store.priorities[0] != internal.PriorityInteractive {
+		t.Errorf("Search() enqueued priorities = %v, want [%s]", store.priorities, internal.PriorityInteractive)
+	}
+	if len(store.updates) != 1 || store.updates[0].Priority != internal.PriorityInteractive {
+		t.Errorf("Search() updated tracker = %+v, want interactive priority", store.updates)
+	}
+}
+
+func TestSearchHandlerRunAt(t *testing.T) {
+	runAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	body := `{"url":"https://example.com","run_at":"2030-01-02T03:04:05Z"}`
+
+	app := newTestApplication()
+	store := &mockStore{}
+	app.Store = store
+
+	r := httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	app.Search(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Search() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if !store.storedTracker.NotBefore.Equal(runAt) {
+		t.Errorf("Search() stored NotBefore = %v, want %v", store.storedTracker.NotBefore, runAt)
+	}
+
+	app = newTestApplication()
+	store = &mockStore{
+		canonical: &cache.URLTracker{ID: "existing", URL: "https://example.com", Status: internal.StatusCompleted},
+	}
+	app.Store = store
+
+	r = httptest.NewRequest(http.MethodPost, "/api/search", bytes.NewBufferString(`{"url":"https://example.com","rerun":true,"run_at":"2030-01-02T03:04:05Z"}`))
+	w = httptest.NewRecorder()
+	app.Search(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Search() rerun status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if len(store.enqueued) != 0 {
+		t.Errorf("Search() rerun enqueued %v, want it scheduled", store.enqueued)
+	}
+	if at, ok := store.scheduled["existing"]; !ok || !at.Equal(runAt) {
+		t.Errorf("Search() rerun scheduled = %v, want existing at %v", store.scheduled, runAt)
+	}
+}
+
+func TestGetTrackingRunsHandler(t *testing.T) {
+	app := newTestApplication()
+	app.Store = &mockStore{
+		getURLResult: &cache.URLTracker{ID: "abc"},
+		runs: []*cache.AnalysisRun{
+			{Status: internal.StatusCompleted, Result: `{"title":"new"}`},
+			{Status: internal.StatusFailed, Error: "timeout"},
+		},
+	}
+
+	router := app.routes()
+	r := httptest.NewRequest(http.MethodGet, "/api/tracking/abc/runs", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetTrackingRuns() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	var runs []cache.AnalysisRun
+	if err := json.NewDecoder(w.Body).Decode(&runs); err != nil {
+		t.Fatalf("GetTrackingRuns() response decode error = %v", err)
+	}
+	if len(runs) != 2 || runs[1].Error != "timeout" {
+		t.Errorf("GetTrackingRuns() response = %+v", runs)
+	}
+}
+
+func TestGetQueueStatsHandler(t *testing.T) {
+	app := newTestApplication()
+	app.Store = &mockStore{
+		queueStats: &cache.QueueStats{
+			Mode:      cache.QueueStream,
+			Queued:    3,
+			InFlight:  1,
+			Consumers: []cache.ConsumerStats{{Name: "worker-1", Pending: 1}},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+	w := httptest.NewRecorder()
+
+	app.GetQueueStats(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetQueueStats() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	var stats cache.QueueStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("GetQueueStats() response decode error = %v", err)
+	}
+	if stats.Queued != 3 || len(stats.Consumers) != 1 || stats.Consumers[0].Name != "worker-1" {
+		t.Errorf("GetQueueStats() response = %+v", stats)
+	}
+}
+
+func TestDeadLetterHandlers(t *testing.T) {
+	app := newTestApplication()
+	store := &mockStore{
+		getURLResult: &cache.URLTracker{ID: "dead-1", URL: "https://example.com", Status: internal.StatusFailed, Attempts: 3},
+		deadLetters: map[string]*cache.DeadLetter{
+			"dead-1": {ID: "dead-1", Reason: cache.ReasonCrawlFailed, Attempts: 3, LastError: "timeout"},
+			"dead-2": {ID: "dead-2", Reason: cache.ReasonMissingTracker},
+		},
+	}
+	app.Store = store
+	router := app.routes()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/deadletters", nil))
+	var letters []cache.DeadLetter
+	if err := json.NewDecoder(w.Body).Decode(&letters); err != nil || len(letters) != 2 {
+		t.Fatalf("ListDeadLetters() = %+v, %v, want 2 entries", letters, err)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/deadletters/dead-1/requeue", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("RequeueDeadLetter() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if len(store.enqueued) != 1 || store.enqueued[0] != "dead-1" {
+		t.Errorf("RequeueDeadLetter() enqueued = %v, want [dead-1]", store.enqueued)
+	}
+	if len(store.updates) != 1 || store.updates[0].Status != internal.StatusPending || store.updates[0].Attempts != 0 {
+		t.Errorf("RequeueDeadLetter() updates = %+v, want pending with attempts reset", store.updates)
+	}
+	if _, ok := store.deadLetters["dead-1"]; ok {
+		t.Error("RequeueDeadLetter() kept the dead letter")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/deadletters/dead-1/requeue", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("RequeueDeadLetter() of a removed entry status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	// the tracker is pending again, so a stale dead letter cannot requeue it
+	store.deadLetters["dead-1"] = &cache.DeadLetter{ID: "dead-1", Reason: cache.ReasonCrawlFailed}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/deadletters/dead-1/requeue", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("RequeueDeadLetter() of a pending tracker status = %v, want %v", w.Code, http.StatusConflict)
+	}
+	if len(store.enqueued) != 1 {
+		t.Errorf("RequeueDeadLetter() enqueued = %v, want the pending tracker left alone", store.enqueued)
+	}
+
+	store.getURLErr = cache.ErrNotFound
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/deadletters/dead-2/requeue", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("RequeueDeadLetter() of a missing tracker status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/deadletters/dead-2", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DeleteDeadLetter() status = %v, want %v", w.Code, http.StatusNoContent)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/deadletters/dead-2", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DeleteDeadLetter() twice status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	store.deadLetters = map[string]*cache.DeadLetter{"dead-3": {ID: "dead-3"}}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/deadletters", nil))
+	var purged struct {
+		Purged int `json:"purged"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&purged); err != nil || purged.Purged != 1 {
+		t.Errorf("PurgeDeadLetters() = %+v, %v, want 1 purged", purged, err)
+	}
+}
+
 func TestRoutes(t *testing.T) {
 	app := newTestApplication()
 	handler := app.routes()
@@ -202,3 +786,50 @@ func TestRoutes(t *testing.T) {
 		}
 	}
 }
+
+func TestListTrackingHandler(t *testing.T) {
+	app := newTestApplication()
+	store := &mockStore{
+		listResult: &cache.URLPage{
+			Trackers:   []*cache.URLTracker{{ID: "1", URL: "https://example.com"}},
+			NextCursor: "next",
+		},
+	}
+	app.Store = store
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tracking?status=completed&order=asc&limit=10", nil)
+	w := httptest.NewRecorder()
+
+	app.ListTracking(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("ListTracking() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	want := cache.ListOptions{Status: "completed", SortBy: cache.SortCreated, Order: cache.OrderAsc, Limit: 10}
+	if store.listOpts != want {
+		t.Errorf("ListTracking() options = %+v, want %+v", store.listOpts, want)
+	}
+
+	var page cache.URLPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("ListTracking() response decode error = %v", err)
+	}
+	if len(page.Trackers) != 1 || page.NextCursor != "next" {
+		t.Errorf("ListTracking() response = %+v", page)
+	}
+}
+
+func TestListTrackingHandlerInvalidSort(t *testing.T) {
+	app := newTestApplication()
+	app.Store = &mockStore{}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tracking?sort=url", nil)
+	w := httptest.NewRecorder()
+
+	app.ListTracking(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("ListTracking() status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
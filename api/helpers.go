@@ -29,6 +29,10 @@ func (app *application) readJSON(r *http.Request, data any) error {
 }
 
 func (app *application) badRequest(w http.ResponseWriter, err error) error {
+	return app.errorJSON(w, http.StatusBadRequest, err)
+}
+
+func (app *application) errorJSON(w http.ResponseWriter, status int, err error) error {
 	var payload struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -37,7 +41,7 @@ func (app *application) badRequest(w http.ResponseWriter, err error) error {
 	payload.Error = true
 	payload.Message = err.Error()
 
-	err = app.writeJSON(w, http.StatusBadRequest, payload)
+	err = app.writeJSON(w, status, payload)
 	if err != nil {
 		return err
 	}
@@ -15,7 +15,7 @@ func newTestApplication() *application {
 	return &application{
 		infoLog:  log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime),
 		errorLog: log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile),
-		Redis:    nil,
+		Store:    nil,
 	}
 }
 
@@ -12,12 +12,20 @@ func (app *application) routes() http.Handler {
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"https://*", "http://*"},
-		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
 	}))
 
 	r.Route("/api", func(r chi.Router) {
 		r.Post("/search", app.Search)
+		r.Get("/tracking", app.ListTracking)
 		r.Get("/tracking/{id}", app.GetTrackingStatus)
+		r.Get("/tracking/{id}/runs", app.GetTrackingRuns)
+		r.Get("/tracking/{id}/batch", app.GetTrackingBatch)
+		r.Get("/queue", app.GetQueueStats)
+		r.Get("/deadletters", app.ListDeadLetters)
+		r.Delete("/deadletters", app.PurgeDeadLetters)
+		r.Post("/deadletters/{id}/requeue", app.RequeueDeadLetter)
+		r.Delete("/deadletters/{id}", app.DeleteDeadLetter)
 	})
 
 	return r
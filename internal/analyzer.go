@@ -0,0 +1,21 @@
+package internal
+
+import "slices"
+
+// Analyzers the worker runs on every crawled page. A submission may select
+// some of them by name; without a selection all of them run.
+const (
+	AnalyzerDocument      = "document"
+	AnalyzerLinks         = "links"
+	AnalyzerLogin         = "login"
+	AnalyzerSEO           = "seo"
+	AnalyzerAccessibility = "accessibility"
+)
+
+// Analyzers lists the analyzers in the order they run. The worker registers
+// one analyzer for each name, so adding a name here is all the API needs.
+var Analyzers = []string{AnalyzerDocument, AnalyzerLinks, AnalyzerLogin, AnalyzerSEO, AnalyzerAccessibility}
+
+func IsValidAnalyzer(name string) bool {
+	return slices.Contains(Analyzers, name)
+}
@@ -0,0 +1,52 @@
+package cache
+
+import "fmt"
+
+// Bounds of a site audit. Submissions that leave them out get the defaults
+// and may not ask for more than the maximums.
+const (
+	DefaultAuditDepth = 2
+	DefaultAuditPages = 50
+	MaxAuditDepth     = 10
+	MaxAuditPages     = 500
+)
+
+// SiteAudit marks a tracker as a site audit: starting from its URL, internal
+// links are followed up to MaxDepth clicks away for at most MaxPages pages.
+// Pages lists further URLs of the site known to exist, e.g. from a sitemap,
+// which are crawled as well and checked for inbound links.
+type SiteAudit struct {
+	MaxDepth int      `json:"max_depth"`
+	MaxPages int      `json:"max_pages"`
+	Pages    []string `json:"pages,omitempty"`
+}
+
+// NewSiteAudit returns the audit options for a submission, filling in the
+// defaults for zero values.
+func NewSiteAudit(maxDepth, maxPages int, pages []string) (*SiteAudit, error) {
+	if maxDepth == 0 {
+		maxDepth = DefaultAuditDepth
+	}
+	if maxPages == 0 {
+		maxPages = DefaultAuditPages
+	}
+	if maxDepth < 0 || maxDepth > MaxAuditDepth {
+		return nil, fmt.Errorf("max_depth must be between 1 and %d", MaxAuditDepth)
+	}
+	if maxPages < 0 || maxPages > MaxAuditPages {
+		return nil, fmt.Errorf("max_pages must be between 1 and %d", MaxAuditPages)
+	}
+	if len(pages) > maxPages {
+		return nil, fmt.Errorf("at most max_pages (%d) pages can be listed", maxPages)
+	}
+	return &SiteAudit{MaxDepth: maxDepth, MaxPages: maxPages, Pages: pages}, nil
+}
+
+// Progress is how far a running site audit got.
+type Progress struct {
+	// Done counts the pages analysed so far and Queued those found but not
+	// analysed yet, out of at most Limit.
+	Done   int `json:"done"`
+	Queued int `json:"queued"`
+	Limit  int `json:"limit"`
+}
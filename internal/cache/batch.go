@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"urltracker/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var batchKey = "batch:%s"
+
+// Bounds of a sitemap submission. Submissions that leave the URL limit out
+// get the default and may not ask for more than the maximum.
+const (
+	DefaultSitemapURLs = 500
+	MaxSitemapURLs     = 5000
+)
+
+// SitemapBatch marks a tracker as a sitemap submission: the worker discovers
+// the sitemaps of its URL and queues a tracker for each of at most MaxURLs
+// listed pages, grouped under the submission's batch.
+type SitemapBatch struct {
+	MaxURLs int `json:"max_urls"`
+}
+
+// NewSitemapBatch returns the options of a sitemap submission, filling in the
+// default for zero.
+func NewSitemapBatch(maxURLs int) (*SitemapBatch, error) {
+	if maxURLs == 0 {
+		maxURLs = DefaultSitemapURLs
+	}
+	if maxURLs < 0 || maxURLs > MaxSitemapURLs {
+		return nil, fmt.Errorf("max_urls must be between 1 and %d", MaxSitemapURLs)
+	}
+	return &SitemapBatch{MaxURLs: maxURLs}, nil
+}
+
+// BatchFailure is a tracker of a batch that could not be analysed.
+type BatchFailure struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Error string `json:"error,omitempty"`
+}
+
+// BatchSummary counts the trackers of a batch by status and lists the ones
+// that failed.
+type BatchSummary struct {
+	Total  int            `json:"total"`
+	Counts map[string]int `json:"counts"`
+	Failed []BatchFailure `json:"failed"`
+}
+
+// SummarizeBatch summarizes the trackers of a batch.
+func SummarizeBatch(trackers []*URLTracker) *BatchSummary {
+	s := &BatchSummary{Total: len(trackers), Counts: make(map[string]int), Failed: []BatchFailure{}}
+	for _, t := range trackers {
+		s.Counts[t.Status]++
+		if t.Status == internal.StatusFailed {
+			s.Failed = append(s.Failed, BatchFailure{ID: t.ID, URL: t.URL, Error: t.Error})
+		}
+	}
+	sort.Slice(s.Failed, func(i, j int) bool { return s.Failed[i].URL < s.Failed[j].URL })
+	return s
+}
+
+// AddToBatch groups the trackers ids under batch. Adding a tracker twice has
+// no effect.
+func (r *RedisClient) AddToBatch(ctx context.Context, batch string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	key := r.key(fmt.Sprintf(batchKey, batch))
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, key, members...)
+	if r.expiration > 0 {
+		pipe.Expire(ctx, key, r.expiration)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetBatch returns the trackers grouped under batch, ordered by ID. Trackers
+// that no longer exist are left out.
+func (r *RedisClient) GetBatch(ctx context.Context, batch string) ([]*URLTracker, error) {
+	ids, err := r.client.SMembers(ctx, r.key(fmt.Sprintf(batchKey, batch))).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Get(ctx, r.key(fmt.Sprintf(urlKey, id)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	trackers := make([]*URLTracker, 0, len(ids))
+	for _, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err != nil {
+			continue
+		}
+		var t URLTracker
+		if err := json.Unmarshal(data, &t); err != nil {
+			continue
+		}
+		trackers = append(trackers, &t)
+	}
+	return trackers, nil
+}
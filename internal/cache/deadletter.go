@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var deadLettersKey = "urls:dead"
+var deadLettersIndexKey = "urls:dead:index"
+
+var ErrNoDeadLetter = errors.New("dead letter not found")
+
+// Reasons a job ends up in the dead-letter list.
+const (
+	ReasonMissingTracker = "missing_tracker"
+	ReasonCorruptTracker = "corrupt_tracker"
+	ReasonCrawlFailed    = "crawl_failed"
+)
+
+// DeadLetter records a job that was taken off the queue without being
+// processed successfully. There is at most one entry per tracker; dead
+// lettering a tracker again replaces its entry.
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url,omitempty"`
+	Reason    string    `json:"reason"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	DeadAt    time.Time `json:"dead_at"`
+}
+
+// deadLetterReason classifies why a dequeued tracker could not be loaded.
+// Transient errors, such as a lost connection, return "" and must not dead
+// letter the job.
+func deadLetterReason(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return ReasonMissingTracker
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ReasonCorruptTracker
+	}
+	return ""
+}
+
+func (r *RedisClient) AddDeadLetter(ctx context.Context, d *DeadLetter) error {
+	if d.DeadAt.IsZero() {
+		d.DeadAt = time.Now()
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.key(deadLettersKey), d.ID, data)
+		pipe.ZAdd(ctx, r.key(deadLettersIndexKey), redis.Z{Score: float64(d.DeadAt.UnixMilli()), Member: d.ID})
+		return nil
+	})
+	return err
+}
+
+// ListDeadLetters returns the dead-lettered jobs, most recent first.
+func (r *RedisClient) ListDeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	ids, err := r.client.ZRevRange(ctx, r.key(deadLettersIndexKey), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, len(ids))
+	if len(ids) == 0 {
+		return letters, nil
+	}
+
+	values, err := r.client.HMGet(ctx, r.key(deadLettersKey), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var d DeadLetter
+		if err := json.Unmarshal([]byte(s), &d); err != nil {
+			continue
+		}
+		letters = append(letters, &d)
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter returns the dead-letter entry of a tracker, or
+// ErrNoDeadLetter.
+func (r *RedisClient) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	data, err := r.client.HGet(ctx, r.key(deadLettersKey), id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrNoDeadLetter
+		}
+		return nil, err
+	}
+
+	var d DeadLetter
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RemoveDeadLetter deletes one entry, returning ErrNoDeadLetter if there was
+// none.
+func (r *RedisClient) RemoveDeadLetter(ctx context.Context, id string) error {
+	var removed *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.HDel(ctx, r.key(deadLettersKey), id)
+		pipe.ZRem(ctx, r.key(deadLettersIndexKey), id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if removed.Val() == 0 {
+		return ErrNoDeadLetter
+	}
+	return nil
+}
+
+// PurgeDeadLetters deletes every entry and reports how many there were.
+func (r *RedisClient) PurgeDeadLetters(ctx context.Context) (int, error) {
+	var count *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.HLen(ctx, r.key(deadLettersKey))
+		pipe.Del(ctx, r.key(deadLettersKey), r.key(deadLettersIndexKey))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var delayedKey = "urls:delayed"
+var delayedLanesKey = "urls:delayed:lanes"
+
+// promoteBatch caps how many due jobs a single PromoteDue call moves.
+const promoteBatch = 100
+
+// promoteScript moves up to ARGV[2] jobs that became due at ARGV[1] from the
+// delay queue into the lane recorded for them, a list or a stream depending
+// on ARGV[3].
+var promoteScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, id in ipairs(ids) do
+	local lane = redis.call('HGET', KEYS[2], id) or KEYS[3]
+	if ARGV[3] == 'stream' then
+		redis.call('XADD', lane, '*', 'id', id)
+	else
+		redis.call('LPUSH', lane, id)
+	end
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('HDEL', KEYS[2], id)
+end
+return #ids
+`)
+
+// ScheduleURL queues an existing tracker to run no earlier than at. Jobs
+// that are already due are queued right away.
+func (r *RedisClient) ScheduleURL(ctx context.Context, id, priority string, at time.Time) error {
+	if !at.After(time.Now()) {
+		return r.push(ctx, r.client, id, priority)
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return r.delay(ctx, pipe, id, priority, at)
+	})
+	return err
+}
+
+func (r *RedisClient) delay(ctx context.Context, c redis.Cmdable, id, priority string, at time.Time) error {
+	lane := r.laneKey(priority)
+	if r.mode == QueueStream {
+		lane = r.laneStreamKey(priority)
+	}
+
+	c.HSet(ctx, r.key(delayedLanesKey), id, lane)
+	return c.ZAdd(ctx, r.key(delayedKey), redis.Z{Score: float64(at.UnixMilli()), Member: id}).Err()
+}
+
+// PromoteDue moves scheduled jobs whose time has come onto their work queue
+// lane and reports how many were moved. Workers call it on every poll.
+func (r *RedisClient) PromoteDue(ctx context.Context) (int, error) {
+	fallback := r.key(queueKey)
+	if r.mode == QueueStream {
+		fallback = r.key(streamKey)
+	}
+
+	keys := []string{r.key(delayedKey), r.key(delayedLanesKey), fallback}
+	n, err := promoteScript.Run(ctx, r.client, keys, time.Now().UnixMilli(), promoteBatch, r.mode).Int()
+	if err != nil {
+		return n, err
+	}
+	return n, r.notify(ctx, r.client, n)
+}
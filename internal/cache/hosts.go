@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var hostTokensKey = "hosts:%s:tokens"
+var hostSlotsKey = "hosts:%s:slots"
+
+// ErrHostBusy is returned by AcquireHost when the host already has as many
+// jobs in flight as its limit allows.
+var ErrHostBusy = errors.New("host has too many jobs in flight")
+
+// HostLimit is how hard the workers may hit a single host, shared by all of
+// them. Rate is in requests per second and refills a bucket of Burst tokens;
+// Concurrency caps the jobs in flight. Zero disables either limit.
+type HostLimit struct {
+	Rate        float64
+	Burst       int
+	Concurrency int
+}
+
+// Unlimited reports whether l restricts nothing.
+func (l HostLimit) Unlimited() bool {
+	return l.Rate <= 0 && l.Concurrency <= 0
+}
+
+// DefaultHostLimit allows one request per second with bursts of five and two
+// jobs in flight per host.
+var DefaultHostLimit = HostLimit{Rate: 1, Burst: 5, Concurrency: 2}
+
+// HostLimits holds the default limit and per-domain overrides. An override
+// for example.com also covers its subdomains unless they have their own.
+type HostLimits struct {
+	Default   HostLimit
+	Overrides map[string]HostLimit
+}
+
+// For returns the limit that applies to host.
+func (h HostLimits) For(host string) HostLimit {
+	host = strings.ToLower(host)
+	for {
+		if l, ok := h.Overrides[host]; ok {
+			return l
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return h.Default
+		}
+		host = parent
+	}
+}
+
+// ParseHostLimits reads overrides such as
+//
+//	example.com:rate=5,burst=10,concurrency=4;slow.example.org:rate=0.2
+//
+// on top of def. Fields an override leaves out are taken from def.
+func ParseHostLimits(s string, def HostLimit) (HostLimits, error) {
+	limits := HostLimits{Default: def, Overrides: make(map[string]HostLimit)}
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, fields, ok := strings.Cut(entry, ":")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !ok || host == "" {
+			return limits, fmt.Errorf("invalid host limit %q", entry)
+		}
+
+		l := def
+		for _, field := range strings.Split(fields, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			var err error
+			switch name {
+			case "rate":
+				l.Rate, err = strconv.ParseFloat(value, 64)
+				if l.Rate < 0 {
+					err = errors.New("negative rate")
+				}
+			case "burst":
+				l.Burst, err = strconv.Atoi(value)
+				if l.Burst < 0 {
+					err = errors.New("negative burst")
+				}
+			case "concurrency":
+				l.Concurrency, err = strconv.Atoi(value)
+				if l.Concurrency < 0 {
+					err = errors.New("negative concurrency")
+				}
+			default:
+				err = errors.New("unknown field")
+			}
+			if err != nil {
+				return limits, fmt.Errorf("invalid host limit %q: %s", entry, field)
+			}
+		}
+		limits.Overrides[host] = l
+	}
+
+	return limits, nil
+}
+
+// acquireHostScript takes a concurrency slot in the sorted set KEYS[2] and a
+// token from the bucket in KEYS[1] for the job ARGV[4]. It returns 0 when the
+// job may start, -1 when all slots are taken and otherwise how many
+// milliseconds to wait for the next token. It reads the clock of the Redis
+// server, so workers with skewed clocks refill buckets at the same rate.
+//
+// ARGV: rate (per second), burst, concurrency, job, slot hold (ms)
+var acquireHostScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+if limit > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+	if not redis.call('ZSCORE', KEYS[2], ARGV[4]) and redis.call('ZCARD', KEYS[2]) >= limit then
+		return -1
+	end
+end
+
+if rate > 0 then
+	local tokens = burst
+	local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+	if bucket[1] then
+		tokens = math.min(burst, tonumber(bucket[1]) + (now - tonumber(bucket[2])) * rate / 1000)
+	end
+	if tokens < 1 then
+		return math.ceil((1 - tokens) * 1000 / rate)
+	end
+	redis.call('HSET', KEYS[1], 'tokens', tostring(tokens - 1), 'at', tostring(now))
+	redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+end
+
+if limit > 0 then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[5]), ARGV[4])
+	local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+	redis.call('PEXPIREAT', KEYS[2], last[2])
+end
+return 0
+`)
+
+// AcquireHost asks to start job id against host under limit. It returns 0 if
+// the job may start, in which case it holds one of the host's concurrency
+// slots until ReleaseHost or for at most hold. Otherwise it returns how long
+// to wait for the rate limit, or ErrHostBusy if all slots are taken.
+func (r *RedisClient) AcquireHost(ctx context.Context, host, id string, limit HostLimit, hold time.Duration) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+
+	burst := max(limit.Burst, 1)
+	keys := []string{
+		r.key(fmt.Sprintf(hostTokensKey, host)),
+		r.key(fmt.Sprintf(hostSlotsKey, host)),
+	}
+	wait, err := acquireHostScript.Run(ctx, r.client, keys, limit.Rate, burst, limit.Concurrency, id, hold.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if wait < 0 {
+		return 0, ErrHostBusy
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// ReleaseHost frees the concurrency slot job id holds on host.
+func (r *RedisClient) ReleaseHost(ctx context.Context, host, id string) error {
+	return r.client.ZRem(ctx, r.key(fmt.Sprintf(hostSlotsKey, host)), id).Err()
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"urltracker/internal"
+)
+
+var laneTurnKey = "urls:queue:turn"
+var laneLeasesKey = "urls:leases:lanes"
+
+// DefaultLaneWeights serves six interactive and three normal jobs for every
+// bulk job while all lanes have work.
+var DefaultLaneWeights = map[string]int{
+	internal.PriorityInteractive: 6,
+	internal.PriorityNormal:      3,
+	internal.PriorityBulk:        1,
+}
+
+// NormalizePriority maps an empty priority, as found on trackers stored
+// before lanes existed, to PriorityNormal.
+func NormalizePriority(p string) string {
+	if p == "" {
+		return internal.PriorityNormal
+	}
+	return p
+}
+
+// laneKey returns the list holding jobs of priority p. Normal jobs keep
+// using urls:queue.
+func (r *RedisClient) laneKey(p string) string {
+	if p = NormalizePriority(p); p == internal.PriorityNormal {
+		return r.key(queueKey)
+	}
+	return r.key(queueKey + ":" + p)
+}
+
+// laneStreamKey is the stream mode counterpart of laneKey.
+func (r *RedisClient) laneStreamKey(p string) string {
+	if p = NormalizePriority(p); p == internal.PriorityNormal {
+		return r.key(streamKey)
+	}
+	return r.key(streamKey + ":" + p)
+}
+
+// LaneOrder returns the order in which the lanes are tried on the given
+// dequeue turn. Turns are handed to the lanes in proportion to their weight;
+// the lane owning the turn goes first and the others follow by priority, so
+// an idle lane never holds up the rest.
+func LaneOrder(turn int64, weights map[string]int) []string {
+	total := 0
+	for _, p := range internal.Priorities {
+		total += weights[p]
+	}
+
+	owner := internal.PriorityNormal
+	if total > 0 {
+		slot := int(turn % int64(total))
+		if slot < 0 {
+			slot += total
+		}
+		for _, p := range internal.Priorities {
+			if slot < weights[p] {
+				owner = p
+				break
+			}
+			slot -= weights[p]
+		}
+	}
+
+	order := []string{owner}
+	for _, p := range internal.Priorities {
+		if p != owner {
+			order = append(order, p)
+		}
+	}
+	return order
+}
+
+// ParseLaneWeights reads weights such as "interactive=6,normal=3,bulk=1".
+// Lanes that are not mentioned keep their default weight.
+func ParseLaneWeights(s string) (map[string]int, error) {
+	weights := make(map[string]int, len(DefaultLaneWeights))
+	for p, w := range DefaultLaneWeights {
+		weights[p] = w
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || !internal.IsValidPriority(name) {
+			return nil, fmt.Errorf("invalid lane weight %q", part)
+		}
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid lane weight %q", part)
+		}
+		weights[name] = w
+	}
+
+	return weights, nil
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var linkKey = "links:%s"
+
+var ErrNoLink = errors.New("link status not cached")
+
+// LinkStatus is the outcome of probing a link. StatusCode is 0 if the request
+// failed before a response came back, in which case Error says why.
+type LinkStatus struct {
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// GetLink returns the cached status of the absolute URL link, or ErrNoLink if
+// it was not probed recently.
+func (r *RedisClient) GetLink(ctx context.Context, link string) (*LinkStatus, error) {
+	data, err := r.client.Get(ctx, r.key(fmt.Sprintf(linkKey, link))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNoLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s LinkStatus
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// PutLink caches the status of link for ttl.
+func (r *RedisClient) PutLink(ctx context.Context, link string, s *LinkStatus, ttl time.Duration) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(fmt.Sprintf(linkKey, link)), data, ttl).Err()
+}
@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"urltracker/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var createdIndexKey = "urls:index:created"
+var updatedIndexKey = "urls:index:updated"
+var statusIndexKey = "urls:index:status:%s:%s"
+
+const (
+	SortCreated = "created"
+	SortUpdated = "updated"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListOptions struct {
+	Status string
+	SortBy string
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+type URLPage struct {
+	Trackers   []*URLTracker `json:"trackers"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ParseListOptions reads status, sort, order, cursor and limit from a query
+// string and validates them.
+func ParseListOptions(q url.Values) (ListOptions, error) {
+	opts := ListOptions{
+		Status: q.Get("status"),
+		SortBy: q.Get("sort"),
+		Order:  q.Get("order"),
+		Cursor: q.Get("cursor"),
+	}
+
+	if opts.SortBy == "" {
+		opts.SortBy = SortCreated
+	}
+	if opts.SortBy != SortCreated && opts.SortBy != SortUpdated {
+		return opts, fmt.Errorf("invalid sort %q", opts.SortBy)
+	}
+
+	if opts.Order == "" {
+		opts.Order = OrderDesc
+	}
+	if opts.Order != OrderAsc && opts.Order != OrderDesc {
+		return opts, fmt.Errorf("invalid order %q", opts.Order)
+	}
+
+	if opts.Status != "" && !internal.IsValidStatus(opts.Status) {
+		return opts, fmt.Errorf("invalid status %q", opts.Status)
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+		opts.Limit = limit
+	}
+
+	return opts, nil
+}
+
+// ListURLs returns one page of trackers from the sorted set indexes. The
+// returned NextCursor is empty once the last page has been reached.
+func (r *RedisClient) ListURLs(ctx context.Context, opts ListOptions) (*URLPage, error) {
+	key := r.indexKey(opts.Status, opts.SortBy)
+	desc := opts.Order != OrderAsc
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var after *Cursor
+	if opts.Cursor != "" {
+		c, err := DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &c
+	}
+
+	min, max := "-inf", "+inf"
+	if after != nil {
+		bound := strconv.FormatInt(after.Score, 10)
+		if desc {
+			max = bound
+		} else {
+			min = bound
+		}
+	}
+
+	// Members sharing the cursor's score are returned again by the inclusive
+	// range, so keep reading until they have been skipped and we have one
+	// extra entry to know whether another page exists.
+	var entries []redis.Z
+	batch := int64(limit + 1)
+	for offset := int64(0); len(entries) <= limit; offset += batch {
+		zs, err := r.client.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+			Key:     key,
+			Start:   min,
+			Stop:    max,
+			ByScore: true,
+			Rev:     desc,
+			Offset:  offset,
+			Count:   batch,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, z := range zs {
+			if after != nil && after.Skips(int64(z.Score), z.Member.(string), desc) {
+				continue
+			}
+			entries = append(entries, z)
+			if len(entries) > limit {
+				break
+			}
+		}
+
+		if int64(len(zs)) < batch {
+			break
+		}
+	}
+
+	page := &URLPage{Trackers: []*URLTracker{}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[len(entries)-1]
+		page.NextCursor = EncodeCursor(Cursor{Score: int64(last.Score), ID: last.Member.(string)})
+	}
+	if len(entries) == 0 {
+		return page, nil
+	}
+
+	keys := make([]string, len(entries))
+	for i, z := range entries {
+		keys[i] = r.key(fmt.Sprintf(urlKey, z.Member.(string)))
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			// expired or deleted since it was indexed
+			continue
+		}
+
+		var tracker URLTracker
+		if err := json.Unmarshal([]byte(data), &tracker); err != nil {
+			continue
+		}
+		page.Trackers = append(page.Trackers, &tracker)
+	}
+
+	return page, nil
+}
+
+// RebuildIndex backfills the listing indexes from the stored trackers when
+// they are empty, e.g. for data written before the indexes existed.
+func (r *RedisClient) RebuildIndex(ctx context.Context) (int, error) {
+	n, err := r.client.ZCard(ctx, r.key(createdIndexKey)).Result()
+	if err != nil || n > 0 {
+		return 0, err
+	}
+
+	var indexed atomic.Int64
+	prefix := r.key(fmt.Sprintf(urlKey, ""))
+	err = r.scanKeys(ctx, prefix+"*", func(key string) error {
+		tracker, err := r.GetURL(ctx, strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil
+		}
+
+		pipe := r.client.TxPipeline()
+		r.addToIndex(ctx, pipe, tracker)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		indexed.Add(1)
+		return nil
+	})
+
+	return int(indexed.Load()), err
+}
+
+// scanKeys calls fn for every key matching pattern. In cluster mode every
+// master is scanned, concurrently.
+func (r *RedisClient) scanKeys(ctx context.Context, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, r.client)
+}
+
+func (r *RedisClient) indexKey(status, sortBy string) string {
+	if sortBy == "" {
+		sortBy = SortCreated
+	}
+	if status != "" {
+		return r.key(fmt.Sprintf(statusIndexKey, status, sortBy))
+	}
+	if sortBy == SortUpdated {
+		return r.key(updatedIndexKey)
+	}
+	return r.key(createdIndexKey)
+}
+
+func indexScore(t time.Time) float64 {
+	return float64(IndexScore(t))
+}
+
+// IndexScore is the sort key of a timestamp in the listing indexes and
+// cursors.
+func IndexScore(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+func (r *RedisClient) addToIndex(ctx context.Context, pipe redis.Pipeliner, t *URLTracker) {
+	created := redis.Z{Score: indexScore(t.CreatedAt), Member: t.ID}
+	updated := redis.Z{Score: indexScore(t.UpdatedAt), Member: t.ID}
+
+	pipe.ZAdd(ctx, r.key(createdIndexKey), created)
+	pipe.ZAdd(ctx, r.key(updatedIndexKey), updated)
+	pipe.ZAdd(ctx, r.indexKey(t.Status, SortCreated), created)
+	pipe.ZAdd(ctx, r.indexKey(t.Status, SortUpdated), updated)
+}
+
+func (r *RedisClient) removeFromStatusIndex(ctx context.Context, pipe redis.Pipeliner, status, id string) {
+	pipe.ZRem(ctx, r.indexKey(status, SortCreated), id)
+	pipe.ZRem(ctx, r.indexKey(status, SortUpdated), id)
+}
+
+// Cursor marks the last entry of a page: its index score and ID.
+type Cursor struct {
+	Score int64
+	ID    string
+}
+
+// Skips reports whether the entry with score and id was already returned on
+// a previous page. Equal scores are ordered by ID, reversed for descending
+// listings, which matches how Redis orders sorted set members.
+func (c Cursor) Skips(score int64, id string, desc bool) bool {
+	if score != c.Score {
+		return false
+	}
+	if desc {
+		return id >= c.ID
+	}
+	return id <= c.ID
+}
+
+func EncodeCursor(c Cursor) string {
+	raw := strconv.FormatInt(c.Score, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	score, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(score, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{Score: n, ID: id}, nil
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queueNotifyKey is a list of wake-up tokens. Every push adds one and idle
+// workers wait for one with BRPOP instead of polling the lanes. The tokens
+// are only hints: the job itself is still taken by the dequeue script, so
+// the weighted lane order and the leases work the same in blocking mode.
+var queueNotifyKey = "urls:queue:notify"
+
+// notifyCap bounds the tokens kept while no worker is waiting.
+const notifyCap = 100
+
+// blockSlice is the longest single BRPOP. Cancelling the context of a
+// blocking DequeueURL takes effect between two of them.
+const blockSlice = time.Second
+
+// SetBlockTimeout makes DequeueURL wait up to d for a job when the queue is
+// empty. Zero, the default, returns right away.
+func (r *RedisClient) SetBlockTimeout(d time.Duration) {
+	r.blockTimeout = d
+}
+
+// notify wakes up to n workers waiting in DequeueURL.
+func (r *RedisClient) notify(ctx context.Context, c redis.Cmdable, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	tokens := make([]any, min(n, notifyCap))
+	for i := range tokens {
+		tokens[i] = "1"
+	}
+	key := r.key(queueNotifyKey)
+	c.LPush(ctx, key, tokens...)
+	return c.LTrim(ctx, key, 0, notifyCap-1).Err()
+}
+
+// popBlocking waits for wake-up tokens until the block timeout is over and
+// tries to take a job after each. It returns redis.Nil if none came.
+func (r *RedisClient) popBlocking(ctx context.Context) (string, error) {
+	deadline := time.Now().Add(r.blockTimeout)
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		err := r.client.BRPop(ctx, blockSlice, r.key(queueNotifyKey)).Err()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// another worker may have been faster
+		id, err := r.popID(ctx)
+		if !errors.Is(err, redis.Nil) {
+			return id, err
+		}
+	}
+	return "", redis.Nil
+}
@@ -3,60 +3,255 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"urltracker/internal"
+
 	"github.com/redis/go-redis/v9"
 )
 
 var queueKey = "urls:queue"
 var urlKey = "url:%s"
+var leasesKey = "urls:leases"
+var leaseOwnersKey = "urls:leases:owners"
+var canonicalKey = "urls:canonical"
+
+var ErrDuplicateURL = errors.New("a tracker already exists for this URL")
+var ErrNotFound = errors.New("tracker not found")
+
+// ErrLeaseLost is returned by ExtendLease when the job's lease expired and
+// the job was requeued, or it was never leased to this consumer.
+var ErrLeaseLost = errors.New("lease no longer held")
+
+// ErrConflict is returned by UpdateURL when the tracker was changed since the
+// caller read it. Callers should reload the tracker and decide again.
+var ErrConflict = errors.New("tracker was modified concurrently")
+
+// dequeueScript pops the oldest ID from the first non-empty lane in KEYS[4:],
+// whose priorities are in ARGV[3:]. With a consumer in ARGV[2] the ID is
+// leased to it in the same step, so a crash between the pop and the lease
+// bookkeeping can never lose the job: the sorted set KEYS[1] holds the lease
+// deadline, the hash KEYS[2] the owner and the hash KEYS[3] the lane.
+var dequeueScript = redis.NewScript(`
+for i = 4, #KEYS do
+	local id = redis.call('RPOP', KEYS[i])
+	if id then
+		if ARGV[2] ~= '' then
+			redis.call('ZADD', KEYS[1], ARGV[1], id)
+			redis.call('HSET', KEYS[2], id, ARGV[2])
+			redis.call('HSET', KEYS[3], id, ARGV[i - 1])
+		end
+		return id
+	end
+end
+return false
+`)
+
+// reapScript returns every job whose lease expired before ARGV[1] to the
+// front of the lane it came from. The lanes are passed in KEYS[4:] with their
+// priorities in ARGV[2:]; leases of an unknown lane go to KEYS[4].
+var reapScript = redis.NewScript(`
+local lanes = {}
+for i = 4, #KEYS do
+	lanes[ARGV[i - 2]] = KEYS[i]
+	-- leases taken before lanes were recorded by priority hold the key
+	lanes[KEYS[i]] = KEYS[i]
+end
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+	local lane = lanes[redis.call('HGET', KEYS[3], id) or ''] or KEYS[4]
+	redis.call('RPUSH', lane, id)
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+end
+return #ids
+`)
+
+// extendScript moves the lease deadline of ARGV[1] to ARGV[3] if the
+// consumer ARGV[2] still holds it.
+var extendScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', ARGV[3], ARGV[1])
+return 1
+`)
 
 type URLTracker struct {
-	ID        string    `json:"id"`
-	URL       string    `json:"url"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Result    string    `json:"result,omitempty"`
-	Error     string    `json:"error,omitempty"`
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	CanonicalURL string    `json:"canonical_url,omitempty"`
+	Status       string    `json:"status"`
+	Priority     string    `json:"priority,omitempty"`
+	NotBefore    time.Time `json:"not_before,omitzero"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Result       string    `json:"result,omitempty"`
+	Error        string    `json:"error,omitempty"`
+
+	// Revision is bumped on every UpdateURL and guards it against lost
+	// updates.
+	Revision int64 `json:"revision"`
+	// Attempts counts how often a worker started processing the tracker
+	// since it was last submitted.
+	Attempts int `json:"attempts,omitempty"`
+
+	// Audit is set on site audits, which report their Progress while they
+	// run.
+	Audit    *SiteAudit `json:"audit,omitempty"`
+	Progress *Progress  `json:"progress,omitempty"`
+	// Sitemap is set on sitemap submissions, whose trackers are grouped
+	// under the submission's ID, see GetBatch.
+	Sitemap *SitemapBatch `json:"sitemap,omitempty"`
+	// Analyzers names the analyzers to run on the tracker's pages, all of
+	// them if empty.
+	Analyzers []string `json:"analyzers,omitempty"`
 }
 
 type RedisClient struct {
-	client     *redis.Client
-	expiration time.Duration
+	client       redis.UniversalClient
+	prefix       string
+	expiration   time.Duration
+	consumer     string
+	lease        time.Duration
+	runRetention int
+	blockTimeout time.Duration
+	mode         string
+	weights      map[string]int
+	groupReady   atomic.Bool
 }
 
+// NewRedisClient connects to a standalone Redis server at addr with default
+// options.
 func NewRedisClient(addr string) *RedisClient {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
-	return &RedisClient{client: client}
+	return newRedisClient(redis.NewClient(&redis.Options{Addr: addr}), "")
+}
+
+// NewRedisClientFromConfig connects to a standalone, Sentinel or Cluster
+// deployment as described by cfg.
+func NewRedisClientFromConfig(cfg RedisConfig) (*RedisClient, error) {
+	opts, err := cfg.universalOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" && cfg.isCluster() {
+		prefix = ClusterKeyPrefix
+	}
+	return newRedisClient(redis.NewUniversalClient(opts), prefix), nil
+}
+
+func newRedisClient(client redis.UniversalClient, prefix string) *RedisClient {
+	return &RedisClient{
+		client:       client,
+		prefix:       prefix,
+		runRetention: DefaultRunRetention,
+		mode:         QueueList,
+		weights:      DefaultLaneWeights,
+	}
+}
+
+// key returns the full name of a key, with the configured prefix.
+func (r *RedisClient) key(k string) string {
+	return r.prefix + k
 }
 
+// UseReliableQueue switches DequeueURL from a bare RPOP to a lease based
+// reliable queue. Each dequeued ID gets a deadline one lease from now in the
+// urls:leases sorted set, and the urls:leases:owners and urls:leases:lanes
+// hashes record the consumer holding it and the lane it came from. AckURL
+// drops the lease and ExtendLease pushes the deadline back while the job
+// runs. Once the deadline has passed, RequeueExpired puts the ID back on its
+// lane.
+func (r *RedisClient) UseReliableQueue(consumer string, lease time.Duration) {
+	r.consumer = consumer
+	r.lease = lease
+}
+
+// SetLaneWeights changes how many dequeue turns each priority lane gets, see
+// LaneOrder.
+func (r *RedisClient) SetLaneWeights(weights map[string]int) {
+	r.weights = weights
+}
+
+// StoreURL saves a new tracker and queues it in the lane of its priority, or
+// in the delay queue if its NotBefore lies in the future. Trackers with a
+// CanonicalURL claim it in the canonical index first; if another tracker
+// already owns it ErrDuplicateURL is returned and nothing is stored.
 func (r *RedisClient) StoreURL(ctx context.Context, tracker *URLTracker) error {
+	tracker.Revision = 1
 	data, err := json.Marshal(tracker)
 	if err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf(urlKey, tracker.ID)
-	err = r.client.Set(ctx, key, data, r.expiration).Err()
+	if tracker.CanonicalURL != "" {
+		claimed, err := r.client.HSetNX(ctx, r.key(canonicalKey), tracker.CanonicalURL, tracker.ID).Result()
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return ErrDuplicateURL
+		}
+	}
+
+	key := r.key(fmt.Sprintf(urlKey, tracker.ID))
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, r.expiration)
+		r.addToIndex(ctx, pipe, tracker)
+		if tracker.NotBefore.After(time.Now()) {
+			return r.delay(ctx, pipe, tracker.ID, tracker.Priority, tracker.NotBefore)
+		}
+		return r.push(ctx, pipe, tracker.ID, tracker.Priority)
+	})
+	if err != nil && tracker.CanonicalURL != "" {
+		r.client.HDel(ctx, r.key(canonicalKey), tracker.CanonicalURL)
+	}
+	return err
+}
+
+// FindByCanonical returns the tracker registered for a canonical URL, or nil
+// if there is none.
+func (r *RedisClient) FindByCanonical(ctx context.Context, canonical string) (*URLTracker, error) {
+	id, err := r.client.HGet(ctx, r.key(canonicalKey), canonical).Result()
 	if err != nil {
-		return err
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	if err := r.client.LPush(ctx, queueKey, tracker.ID).Err(); err != nil {
-		return err
+	tracker, err := r.GetURL(ctx, id)
+	if err != nil {
+		if err == ErrNotFound {
+			// the tracker expired, free the URL for a new submission
+			r.client.HDel(ctx, r.key(canonicalKey), canonical)
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	return nil
+	return tracker, nil
+}
+
+// EnqueueURL puts an existing tracker back on the work queue, in the lane of
+// the given priority.
+func (r *RedisClient) EnqueueURL(ctx context.Context, id, priority string) error {
+	return r.push(ctx, r.client, id, priority)
 }
 
 func (r *RedisClient) GetURL(ctx context.Context, id string) (*URLTracker, error) {
-	key := fmt.Sprintf(urlKey, id)
+	key := r.key(fmt.Sprintf(urlKey, id))
 	data, err := r.client.Get(ctx, key).Result()
 	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -69,56 +264,79 @@ func (r *RedisClient) GetURL(ctx context.Context, id string) (*URLTracker, error
 	return &tracker, nil
 }
 
+// UpdateURL writes the mutable fields of t back to its tracker. The write
+// only succeeds if the stored tracker still has t's Revision; otherwise
+// ErrConflict is returned and nothing is changed. On success t.Revision and
+// t.UpdatedAt are set to the stored values.
 func (r *RedisClient) UpdateURL(ctx context.Context, t *URLTracker) error {
-	tracker, err := r.GetURL(ctx, t.ID)
-	if err != nil {
-		return err
-	}
-
-	tracker.Status = t.Status
-	tracker.UpdatedAt = time.Now()
-	tracker.Result = t.Result
-	tracker.Error = t.Error
+	key := r.key(fmt.Sprintf(urlKey, t.ID))
 
-	data, err := json.Marshal(tracker)
-	if err != nil {
-		return err
-	}
-
-	key := fmt.Sprintf(urlKey, t.ID)
-	return r.client.Set(ctx, key, data, r.expiration).Err()
-}
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return ErrNotFound
+			}
+			return err
+		}
 
-func (r *RedisClient) GetAllURLs(ctx context.Context) ([]*URLTracker, error) {
-	var results []*URLTracker
+		var tracker URLTracker
+		if err := json.Unmarshal([]byte(data), &tracker); err != nil {
+			return err
+		}
+		if tracker.Revision != t.Revision {
+			return fmt.Errorf("%w: %s is at revision %d, not %d", ErrConflict, t.ID, tracker.Revision, t.Revision)
+		}
 
-	keys, err := r.client.Keys(ctx, "url:*").Result()
-	if err != nil {
-		return nil, err
-	}
+		previousStatus := tracker.Status
+		tracker.Status = t.Status
+		tracker.Priority = t.Priority
+		tracker.NotBefore = t.NotBefore
+		tracker.UpdatedAt = time.Now()
+		tracker.Result = t.Result
+		tracker.Error = t.Error
+		tracker.Attempts = t.Attempts
+		tracker.Audit = t.Audit
+		tracker.Progress = t.Progress
+		tracker.Sitemap = t.Sitemap
+		tracker.Analyzers = t.Analyzers
+		tracker.Revision++
 
-	for _, key := range keys {
-		data, err := r.client.Get(ctx, key).Result()
+		updated, err := json.Marshal(&tracker)
 		if err != nil {
-			continue
+			return err
 		}
 
-		var tracker URLTracker
-		if err := json.Unmarshal([]byte(data), &tracker); err != nil {
-			continue
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, updated, r.expiration)
+			if previousStatus != tracker.Status {
+				r.removeFromStatusIndex(ctx, pipe, previousStatus, tracker.ID)
+			}
+			r.addToIndex(ctx, pipe, &tracker)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		results = append(results, &tracker)
+		t.Revision = tracker.Revision
+		t.UpdatedAt = tracker.UpdatedAt
+		return nil
+	}, key)
+	if err == redis.TxFailedErr {
+		// the key changed between WATCH and EXEC
+		return fmt.Errorf("%w: %s was written concurrently", ErrConflict, t.ID)
 	}
-
-	return results, nil
+	return err
 }
 
 func (r *RedisClient) DequeueURL(ctx context.Context) (*URLTracker, error) {
-	id, err := r.client.RPop(ctx, "urls:queue").Result()
+	id, err := r.popID(ctx)
+	if err == redis.Nil && r.blockTimeout > 0 {
+		id, err = r.popBlocking(ctx)
+	}
 	if err != nil {
 		if err == redis.Nil {
-			//
 			return nil, nil
 		}
 		return nil, err
@@ -126,12 +344,121 @@ func (r *RedisClient) DequeueURL(ctx context.Context) (*URLTracker, error) {
 
 	tracker, err := r.GetURL(ctx, id)
 	if err != nil {
-		return nil, err
+		reason := deadLetterReason(err)
+		if reason == "" {
+			// probably transient, the lease expires and the job comes back
+			return nil, fmt.Errorf("load tracker %s: %w", id, err)
+		}
+
+		// The job can never be processed, so park it in the dead-letter
+		// list instead of letting the reaper hand it out again.
+		if dErr := r.AddDeadLetter(ctx, &DeadLetter{ID: id, Reason: reason, LastError: err.Error()}); dErr != nil {
+			return nil, dErr
+		}
+		if ackErr := r.AckURL(ctx, id); ackErr != nil {
+			return nil, ackErr
+		}
+		return nil, fmt.Errorf("load tracker %s: %w", id, err)
 	}
 
 	return tracker, nil
 }
 
+func (r *RedisClient) popID(ctx context.Context) (string, error) {
+	if r.mode == QueueStream {
+		return r.streamPop(ctx)
+	}
+
+	lanes, err := r.nextLanes(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	keys := []string{r.key(leasesKey), r.key(leaseOwnersKey), r.key(laneLeasesKey)}
+	args := []any{time.Now().Add(r.lease).UnixMilli(), r.consumer}
+	for _, p := range lanes {
+		keys = append(keys, r.laneKey(p))
+		args = append(args, p)
+	}
+	return dequeueScript.Run(ctx, r.client, keys, args...).Text()
+}
+
+// nextLanes takes the next dequeue turn and returns the lanes in the order
+// they should be tried.
+func (r *RedisClient) nextLanes(ctx context.Context) ([]string, error) {
+	turn, err := r.client.Incr(ctx, r.key(laneTurnKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return LaneOrder(turn, r.weights), nil
+}
+
+// AckURL marks a dequeued job as done and releases its lease. It is a no-op
+// for the plain list queue.
+func (r *RedisClient) AckURL(ctx context.Context, id string) error {
+	if r.mode == QueueStream {
+		return r.streamAck(ctx, id)
+	}
+	if r.consumer == "" {
+		return nil
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, r.key(leasesKey), id)
+		pipe.HDel(ctx, r.key(leaseOwnersKey), id)
+		pipe.HDel(ctx, r.key(laneLeasesKey), id)
+		return nil
+	})
+	return err
+}
+
+// RequeueExpired moves jobs whose lease has expired back onto the queue and
+// reports how many were recovered.
+func (r *RedisClient) RequeueExpired(ctx context.Context) (int, error) {
+	if r.mode == QueueStream {
+		return r.streamReclaim(ctx)
+	}
+
+	// the normal lane comes first as the fallback for unknown lanes
+	keys := []string{r.key(leasesKey), r.key(leaseOwnersKey), r.key(laneLeasesKey), r.laneKey(internal.PriorityNormal)}
+	args := []any{time.Now().UnixMilli(), internal.PriorityNormal}
+	for _, p := range internal.Priorities {
+		if p != internal.PriorityNormal {
+			keys = append(keys, r.laneKey(p))
+			args = append(args, p)
+		}
+	}
+	n, err := reapScript.Run(ctx, r.client, keys, args...).Int()
+	if err != nil {
+		return n, err
+	}
+	return n, r.notify(ctx, r.client, n)
+}
+
+// ExtendLease pushes the lease deadline of a dequeued job one lease timeout
+// into the future, so long jobs are not handed to another worker while they
+// run. It returns ErrLeaseLost if the job is no longer leased to this
+// consumer, and is a no-op for the plain list queue.
+func (r *RedisClient) ExtendLease(ctx context.Context, id string) error {
+	if r.mode == QueueStream {
+		return r.streamExtend(ctx, id)
+	}
+	if r.consumer == "" {
+		return nil
+	}
+
+	keys := []string{r.key(leasesKey), r.key(leaseOwnersKey)}
+	deadline := time.Now().Add(r.lease).UnixMilli()
+	held, err := extendScript.Run(ctx, r.client, keys, id, r.consumer, deadline).Int()
+	if err != nil {
+		return err
+	}
+	if held == 0 {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, id)
+	}
+	return nil
+}
+
 func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
@@ -2,11 +2,14 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 	"urltracker/internal"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 func newTestRedis(t *testing.T) (*RedisClient, func()) {
@@ -81,3 +84,666 @@ func TestDequeueURL(t *testing.T) {
 		t.Errorf("DequeueURL() ID = %q, want %q", got.ID, tracker.ID)
 	}
 }
+
+func TestReliableDequeueAck(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	client.UseReliableQueue("worker-1", time.Minute)
+
+	ctx := context.Background()
+	tracker := &URLTracker{ID: "ack-1", URL: "https://example.com", Status: internal.StatusPending}
+	if err := client.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+
+	got, err := client.DequeueURL(ctx)
+	if err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+	if got == nil || got.ID != tracker.ID {
+		t.Fatalf("DequeueURL() = %v, want %q", got, tracker.ID)
+	}
+
+	leased, _ := client.client.ZRange(ctx, "urls:leases", 0, -1).Result()
+	owner, _ := client.client.HGet(ctx, "urls:leases:owners", tracker.ID).Result()
+	if len(leased) != 1 || leased[0] != tracker.ID || owner != "worker-1" {
+		t.Fatalf("leases = %v owned by %q, want [%s] owned by worker-1", leased, owner, tracker.ID)
+	}
+
+	if err := client.AckURL(ctx, tracker.ID); err != nil {
+		t.Fatalf("AckURL() error = %v", err)
+	}
+
+	leased, _ = client.client.ZRange(ctx, "urls:leases", 0, -1).Result()
+	if len(leased) != 0 {
+		t.Errorf("leases after ack = %v, want empty", leased)
+	}
+
+	requeued, err := client.RequeueExpired(ctx)
+	if err != nil {
+		t.Fatalf("RequeueExpired() error = %v", err)
+	}
+	if requeued != 0 {
+		t.Errorf("RequeueExpired() = %d, want 0", requeued)
+	}
+}
+
+func TestRequeueExpiredLease(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	client.UseReliableQueue("worker-1", time.Millisecond)
+
+	ctx := context.Background()
+	tracker := &URLTracker{ID: "lease-1", URL: "https://example.com", Status: internal.StatusPending}
+	if err := client.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	if _, err := client.DequeueURL(ctx); err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	requeued, err := client.RequeueExpired(ctx)
+	if err != nil {
+		t.Fatalf("RequeueExpired() error = %v", err)
+	}
+	if requeued != 1 {
+		t.Fatalf("RequeueExpired() = %d, want 1", requeued)
+	}
+
+	got, err := client.DequeueURL(ctx)
+	if err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+	if got == nil || got.ID != tracker.ID {
+		t.Fatalf("DequeueURL() after requeue = %v, want %q", got, tracker.ID)
+	}
+}
+
+func TestRequeueExpiredLane(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	client.UseReliableQueue("worker-1", time.Millisecond)
+
+	ctx := context.Background()
+	tracker := &URLTracker{ID: "bulk-1", URL: "https://example.com", Status: internal.StatusPending, Priority: internal.PriorityBulk}
+	if err := client.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	if _, err := client.DequeueURL(ctx); err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+	// a lease taken before the lanes were recorded by priority
+	client.client.ZAdd(ctx, "urls:leases", redis.Z{Score: 0, Member: "old-1"})
+	client.client.HSet(ctx, "urls:leases:lanes", "old-1", "urls:queue:interactive")
+
+	time.Sleep(5 * time.Millisecond)
+
+	if n, err := client.RequeueExpired(ctx); err != nil || n != 2 {
+		t.Fatalf("RequeueExpired() = %d, %v, want 2", n, err)
+	}
+	if ids, _ := client.client.LRange(ctx, "urls:queue:bulk", 0, -1).Result(); len(ids) != 1 || ids[0] != "bulk-1" {
+		t.Errorf("bulk lane = %v, want [bulk-1]", ids)
+	}
+	if ids, _ := client.client.LRange(ctx, "urls:queue:interactive", 0, -1).Result(); len(ids) != 1 || ids[0] != "old-1" {
+		t.Errorf("interactive lane = %v, want [old-1]", ids)
+	}
+}
+
+func TestListURLsPagination(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	base := time.Now()
+	// ids 0-4 share one timestamp to exercise tie handling across pages
+	for i := 0; i < 8; i++ {
+		created := base
+		if i >= 5 {
+			created = base.Add(time.Duration(i) * time.Second)
+		}
+		tracker := &URLTracker{
+			ID:        fmt.Sprintf("id-%d", i),
+			URL:       "https://example.com",
+			Status:    internal.StatusPending,
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+		if err := client.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+
+	for _, order := range []string{OrderAsc, OrderDesc} {
+		seen := map[string]bool{}
+		cursor := ""
+		pages := 0
+		for {
+			page, err := client.ListURLs(ctx, ListOptions{SortBy: SortCreated, Order: order, Cursor: cursor, Limit: 3})
+			if err != nil {
+				t.Fatalf("ListURLs(%s) error = %v", order, err)
+			}
+			pages++
+			for _, tr := range page.Trackers {
+				if seen[tr.ID] {
+					t.Fatalf("ListURLs(%s) returned %s twice", order, tr.ID)
+				}
+				seen[tr.ID] = true
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if len(seen) != 8 {
+			t.Errorf("ListURLs(%s) returned %d trackers, want 8", order, len(seen))
+		}
+		if pages != 3 {
+			t.Errorf("ListURLs(%s) pages = %d, want 3", order, pages)
+		}
+	}
+
+	page, err := client.ListURLs(ctx, ListOptions{SortBy: SortCreated, Order: OrderDesc, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListURLs() error = %v", err)
+	}
+	if page.Trackers[0].ID != "id-7" {
+		t.Errorf("ListURLs() newest = %q, want %q", page.Trackers[0].ID, "id-7")
+	}
+}
+
+func TestListURLsStatusFilter(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b"} {
+		tracker := &URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending, CreatedAt: time.Now()}
+		if err := client.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+
+	if err := client.UpdateURL(ctx, &URLTracker{ID: "a", Status: internal.StatusCompleted, Revision: 1}); err != nil {
+		t.Fatalf("UpdateURL() error = %v", err)
+	}
+
+	page, err := client.ListURLs(ctx, ListOptions{Status: internal.StatusPending})
+	if err != nil {
+		t.Fatalf("ListURLs() error = %v", err)
+	}
+	if len(page.Trackers) != 1 || page.Trackers[0].ID != "b" {
+		t.Errorf("ListURLs(pending) = %v, want [b]", page.Trackers)
+	}
+
+	page, err = client.ListURLs(ctx, ListOptions{Status: internal.StatusCompleted, SortBy: SortUpdated})
+	if err != nil {
+		t.Fatalf("ListURLs() error = %v", err)
+	}
+	if len(page.Trackers) != 1 || page.Trackers[0].ID != "a" {
+		t.Errorf("ListURLs(completed) = %v, want [a]", page.Trackers)
+	}
+}
+
+func TestListURLsInvalidCursor(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	_, err := client.ListURLs(context.Background(), ListOptions{Cursor: "!!"})
+	if err != ErrInvalidCursor {
+		t.Errorf("ListURLs() error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestStoreURLCanonicalDuplicate(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	first := &URLTracker{ID: "first", URL: "https://example.com", CanonicalURL: "https://example.com/", Status: internal.StatusPending}
+	if err := client.StoreURL(ctx, first); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+
+	second := &URLTracker{ID: "second", URL: "https://example.com/", CanonicalURL: "https://example.com/", Status: internal.StatusPending}
+	if err := client.StoreURL(ctx, second); err != ErrDuplicateURL {
+		t.Fatalf("StoreURL() duplicate error = %v, want %v", err, ErrDuplicateURL)
+	}
+
+	if _, err := client.GetURL(ctx, second.ID); err == nil {
+		t.Error("StoreURL() stored the duplicate tracker")
+	}
+
+	got, err := client.FindByCanonical(ctx, "https://example.com/")
+	if err != nil {
+		t.Fatalf("FindByCanonical() error = %v", err)
+	}
+	if got == nil || got.ID != first.ID {
+		t.Errorf("FindByCanonical() = %v, want %q", got, first.ID)
+	}
+
+	got, err = client.FindByCanonical(ctx, "https://other.example/")
+	if err != nil || got != nil {
+		t.Errorf("FindByCanonical(unknown) = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestRunsRetention(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	client.SetRunRetention(2)
+
+	ctx := context.Background()
+	for _, status := range []string{internal.StatusFailed, internal.StatusCompleted, internal.StatusCompleted} {
+		run := &AnalysisRun{StartedAt: time.Now(), Status: status, Result: status}
+		if err := client.AddRun(ctx, "runs-1", run); err != nil {
+			t.Fatalf("AddRun() error = %v", err)
+		}
+	}
+
+	runs, err := client.GetRuns(ctx, "runs-1")
+	if err != nil {
+		t.Fatalf("GetRuns() error = %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("GetRuns() returned %d runs, want 2", len(runs))
+	}
+	for _, run := range runs {
+		if run.Status != internal.StatusCompleted {
+			t.Errorf("GetRuns() kept %q run, want only the newest completed runs", run.Status)
+		}
+	}
+}
+
+func TestStreamQueue(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	if err := client.SetQueueMode(QueueStream); err != nil {
+		t.Fatalf("SetQueueMode() error = %v", err)
+	}
+	client.UseReliableQueue("worker-1", time.Millisecond)
+
+	ctx := context.Background()
+	for _, id := range []string{"s-1", "s-2"} {
+		tracker := &URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending}
+		if err := client.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+
+	first, err := client.DequeueURL(ctx)
+	if err != nil || first == nil || first.ID != "s-1" {
+		t.Fatalf("DequeueURL() = %v, %v, want s-1", first, err)
+	}
+	if err := client.AckURL(ctx, first.ID); err != nil {
+		t.Fatalf("AckURL() error = %v", err)
+	}
+
+	second, err := client.DequeueURL(ctx)
+	if err != nil || second == nil || second.ID != "s-2" {
+		t.Fatalf("DequeueURL() = %v, %v, want s-2", second, err)
+	}
+
+	stats, err := client.QueueStats(ctx)
+	if err != nil {
+		t.Fatalf("QueueStats() error = %v", err)
+	}
+	if stats.Mode != QueueStream || stats.Queued != 0 || stats.InFlight != 1 {
+		t.Errorf("QueueStats() = %+v, want 0 queued and 1 in flight", stats)
+	}
+	if len(stats.Consumers) != 1 || stats.Consumers[0].Name != "worker-1" || stats.Consumers[0].Pending != 1 {
+		t.Errorf("QueueStats() consumers = %+v, want worker-1 with 1 pending", stats.Consumers)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	requeued, err := client.RequeueExpired(ctx)
+	if err != nil {
+		t.Fatalf("RequeueExpired() error = %v", err)
+	}
+	if requeued != 1 {
+		t.Fatalf("RequeueExpired() = %d, want 1", requeued)
+	}
+
+	again, err := client.DequeueURL(ctx)
+	if err != nil || again == nil || again.ID != "s-2" {
+		t.Fatalf("DequeueURL() after reclaim = %v, %v, want s-2", again, err)
+	}
+	if err := client.AckURL(ctx, again.ID); err != nil {
+		t.Fatalf("AckURL() error = %v", err)
+	}
+
+	empty, err := client.DequeueURL(ctx)
+	if err != nil || empty != nil {
+		t.Errorf("DequeueURL() on empty stream = %v, %v, want nil, nil", empty, err)
+	}
+}
+
+func TestStreamQueueEntries(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	if err := client.SetQueueMode(QueueStream); err != nil {
+		t.Fatalf("SetQueueMode() error = %v", err)
+	}
+	client.UseReliableQueue("worker-1", 50*time.Millisecond)
+
+	ctx := context.Background()
+	tracker := &URLTracker{ID: "dup-1", URL: "https://example.com", Status: internal.StatusPending}
+	if err := client.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	// queued twice, e.g. by a rerun while the first entry is in flight
+	if err := client.EnqueueURL(ctx, tracker.ID, ""); err != nil {
+		t.Fatalf("EnqueueURL() error = %v", err)
+	}
+	for range 2 {
+		if got, err := client.DequeueURL(ctx); err != nil || got == nil || got.ID != tracker.ID {
+			t.Fatalf("DequeueURL() = %v, %v, want %s", got, err, tracker.ID)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := client.ExtendLease(ctx, tracker.ID); err != nil {
+		t.Fatalf("ExtendLease() error = %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n, err := client.RequeueExpired(ctx); err != nil || n != 0 {
+		t.Errorf("RequeueExpired() = %d, %v, want the extended entries kept", n, err)
+	}
+
+	if err := client.AckURL(ctx, tracker.ID); err != nil {
+		t.Fatalf("AckURL() error = %v", err)
+	}
+	if n, _ := client.client.XLen(ctx, "urls:stream").Result(); n != 0 {
+		t.Errorf("stream length after ack = %d, want both entries deleted", n)
+	}
+	if err := client.ExtendLease(ctx, tracker.ID); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("ExtendLease() after ack error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestStreamReclaimFollowsCursor(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	if err := client.SetQueueMode(QueueStream); err != nil {
+		t.Fatalf("SetQueueMode() error = %v", err)
+	}
+	client.UseReliableQueue("worker-1", time.Millisecond)
+
+	ctx := context.Background()
+	jobs := reclaimBatch + 20
+	for i := range jobs {
+		if err := client.EnqueueURL(ctx, fmt.Sprintf("r-%d", i), ""); err != nil {
+			t.Fatalf("EnqueueURL() error = %v", err)
+		}
+	}
+	for range jobs {
+		// the trackers do not exist, only the pops matter here
+		if _, err := client.popID(ctx); err != nil {
+			t.Fatalf("popID() error = %v", err)
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if n, err := client.RequeueExpired(ctx); err != nil || n != jobs {
+		t.Errorf("RequeueExpired() = %d, %v, want %d", n, err, jobs)
+	}
+}
+
+func TestQueueStatsList(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	client.UseReliableQueue("worker-1", time.Minute)
+
+	ctx := context.Background()
+	for _, id := range []string{"l-1", "l-2"} {
+		tracker := &URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending}
+		if err := client.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+	if _, err := client.DequeueURL(ctx); err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+
+	stats, err := client.QueueStats(ctx)
+	if err != nil {
+		t.Fatalf("QueueStats() error = %v", err)
+	}
+	if stats.Queued != 1 || stats.InFlight != 1 || len(stats.Consumers) != 1 {
+		t.Errorf("QueueStats() = %+v, want 1 queued and 1 in flight", stats)
+	}
+}
+
+func TestLaneOrder(t *testing.T) {
+	weights := map[string]int{internal.PriorityInteractive: 2, internal.PriorityNormal: 1, internal.PriorityBulk: 1}
+
+	var owners []string
+	for turn := int64(0); turn < 8; turn++ {
+		order := LaneOrder(turn, weights)
+		if len(order) != 3 {
+			t.Fatalf("LaneOrder(%d) = %v, want all three lanes", turn, order)
+		}
+		owners = append(owners, order[0])
+	}
+
+	want := []string{"interactive", "interactive", "normal", "bulk", "interactive", "interactive", "normal", "bulk"}
+	if fmt.Sprint(owners) != fmt.Sprint(want) {
+		t.Errorf("LaneOrder() owners = %v, want %v", owners, want)
+	}
+
+	if order := LaneOrder(3, weights); fmt.Sprint(order) != "[bulk interactive normal]" {
+		t.Errorf("LaneOrder(3) = %v, want bulk first, then by priority", order)
+	}
+}
+
+func TestParseLaneWeights(t *testing.T) {
+	weights, err := ParseLaneWeights("interactive=10, bulk=0")
+	if err != nil {
+		t.Fatalf("ParseLaneWeights() error = %v", err)
+	}
+	if weights[internal.PriorityInteractive] != 10 || weights[internal.PriorityNormal] != 3 || weights[internal.PriorityBulk] != 0 {
+		t.Errorf("ParseLaneWeights() = %v", weights)
+	}
+
+	for _, s := range []string{"urgent=1", "bulk", "bulk=-1", "normal=x"} {
+		if _, err := ParseLaneWeights(s); err == nil {
+			t.Errorf("ParseLaneWeights(%q) expected error", s)
+		}
+	}
+}
+
+func TestPriorityLanes(t *testing.T) {
+	for _, mode := range []string{QueueList, QueueStream} {
+		client, cleanup := newTestRedis(t)
+
+		if err := client.SetQueueMode(mode); err != nil {
+			t.Fatalf("SetQueueMode() error = %v", err)
+		}
+		client.UseReliableQueue("worker-1", time.Millisecond)
+		client.SetLaneWeights(map[string]int{internal.PriorityInteractive: 2, internal.PriorityNormal: 1, internal.PriorityBulk: 1})
+
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			for _, p := range []string{internal.PriorityBulk, internal.PriorityNormal, internal.PriorityInteractive} {
+				tracker := &URLTracker{ID: fmt.Sprintf("%s-%d", p, i), URL: "https://example.com", Status: internal.StatusPending, Priority: p}
+				if err := client.StoreURL(ctx, tracker); err != nil {
+					t.Fatalf("%s: StoreURL() error = %v", mode, err)
+				}
+			}
+		}
+
+		stats, err := client.QueueStats(ctx)
+		if err != nil {
+			t.Fatalf("%s: QueueStats() error = %v", mode, err)
+		}
+		if stats.Queued != 9 || stats.Lanes[internal.PriorityBulk] != 3 {
+			t.Errorf("%s: QueueStats() = %+v, want 9 queued with 3 bulk", mode, stats)
+		}
+
+		var got []string
+		for i := 0; i < 4; i++ {
+			tracker, err := client.DequeueURL(ctx)
+			if err != nil || tracker == nil {
+				t.Fatalf("%s: DequeueURL() = %v, %v", mode, tracker, err)
+			}
+			got = append(got, tracker.ID)
+			if err := client.AckURL(ctx, tracker.ID); err != nil {
+				t.Fatalf("%s: AckURL() error = %v", mode, err)
+			}
+		}
+
+		// turns start at 1, the second of interactive's two slots
+		want := "[interactive-0 normal-0 bulk-0 interactive-1]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("%s: dequeue order = %v, want %s", mode, got, want)
+		}
+
+		// an expired lease goes back to the lane it came from
+		leased, err := client.DequeueURL(ctx)
+		if err != nil || leased == nil || leased.ID != "interactive-2" {
+			t.Fatalf("%s: DequeueURL() = %v, %v, want interactive-2", mode, leased, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, err := client.RequeueExpired(ctx); err != nil {
+			t.Fatalf("%s: RequeueExpired() error = %v", mode, err)
+		}
+		stats, err = client.QueueStats(ctx)
+		if err != nil {
+			t.Fatalf("%s: QueueStats() error = %v", mode, err)
+		}
+		if stats.Lanes[internal.PriorityInteractive] != 1 || stats.Lanes[internal.PriorityNormal] != 2 {
+			t.Errorf("%s: QueueStats() lanes = %v, want 1 interactive and 2 normal after requeue", mode, stats.Lanes)
+		}
+
+		cleanup()
+	}
+}
+
+func TestPromoteDueStream(t *testing.T) {
+	client, cleanup := newTestRedis(t)
+	defer cleanup()
+
+	if err := client.SetQueueMode(QueueStream); err != nil {
+		t.Fatalf("SetQueueMode() error = %v", err)
+	}
+	client.UseReliableQueue("worker-1", time.Minute)
+
+	ctx := context.Background()
+	tracker := &URLTracker{
+		ID:        "d-1",
+		URL:       "https://example.com",
+		Status:    internal.StatusPending,
+		Priority:  internal.PriorityInteractive,
+		NotBefore: time.Now().Add(5 * time.Millisecond),
+	}
+	if err := client.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+
+	if got, err := client.DequeueURL(ctx); err != nil || got != nil {
+		t.Fatalf("DequeueURL() before due = %v, %v, want nil, nil", got, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if n, err := client.PromoteDue(ctx); err != nil || n != 1 {
+		t.Fatalf("PromoteDue() = %d, %v, want 1", n, err)
+	}
+
+	got, err := client.DequeueURL(ctx)
+	if err != nil || got == nil || got.ID != "d-1" {
+		t.Fatalf("DequeueURL() after promotion = %v, %v, want d-1", got, err)
+	}
+	if !got.NotBefore.Equal(tracker.NotBefore) {
+		t.Errorf("DequeueURL() NotBefore = %v, want %v", got.NotBefore, tracker.NotBefore)
+	}
+}
+
+func TestDequeueDeadLettersUnloadableTrackers(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run() error = %v", err)
+	}
+	defer mr.Close()
+
+	client := NewRedisClient(mr.Addr())
+	defer client.Close()
+	client.UseReliableQueue("worker-1", time.Minute)
+
+	ctx := context.Background()
+	for _, id := range []string{"gone", "corrupt"} {
+		if err := client.StoreURL(ctx, &URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending}); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+	mr.Del("url:gone")
+	mr.Set("url:corrupt", "{not json")
+
+	for i := 0; i < 2; i++ {
+		if got, err := client.DequeueURL(ctx); err == nil || got != nil {
+			t.Errorf("DequeueURL() = %v, %v, want an error", got, err)
+		}
+	}
+
+	letters, err := client.ListDeadLetters(ctx)
+	if err != nil {
+		t.Fatalf("ListDeadLetters() error = %v", err)
+	}
+	reasons := make(map[string]string)
+	for _, d := range letters {
+		reasons[d.ID] = d.Reason
+	}
+	if reasons["gone"] != ReasonMissingTracker || reasons["corrupt"] != ReasonCorruptTracker {
+		t.Errorf("dead letter reasons = %v", reasons)
+	}
+
+	stats, err := client.QueueStats(ctx)
+	if err != nil {
+		t.Fatalf("QueueStats() error = %v", err)
+	}
+	if stats.InFlight != 0 || stats.DeadLetters != 2 {
+		t.Errorf("QueueStats() = %+v, want nothing in flight and 2 dead letters", stats)
+	}
+}
+
+func TestParseHostLimits(t *testing.T) {
+	def := HostLimit{Rate: 1, Burst: 5, Concurrency: 2}
+
+	limits, err := ParseHostLimits("Example.com:rate=5,concurrency=4; slow.example.org:rate=0.2", def)
+	if err != nil {
+		t.Fatalf("ParseHostLimits() error = %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want HostLimit
+	}{
+		{"example.com", HostLimit{Rate: 5, Burst: 5, Concurrency: 4}},
+		{"www.example.com", HostLimit{Rate: 5, Burst: 5, Concurrency: 4}},
+		{"slow.example.org", HostLimit{Rate: 0.2, Burst: 5, Concurrency: 2}},
+		{"example.org", def},
+		{"localhost", def},
+	}
+	for _, tt := range tests {
+		if got := limits.For(tt.host); got != tt.want {
+			t.Errorf("For(%q) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"example.com", "example.com:rate=fast", "example.com:burst=-1", "example.com:delay=1", ":rate=1"} {
+		if _, err := ParseHostLimits(s, def); err == nil {
+			t.Errorf("ParseHostLimits(%q) expected error", s)
+		}
+	}
+}
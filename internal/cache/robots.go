@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var robotsKey = "robots:%s"
+
+var ErrNoRobots = errors.New("robots.txt not cached")
+
+// RobotsFile is a fetched robots.txt. The response status matters as much
+// as the body: a missing file allows everything, a failing server nothing.
+type RobotsFile struct {
+	StatusCode int       `json:"status_code"`
+	Body       string    `json:"body,omitempty"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+// GetRobots returns the cached robots.txt of origin (scheme and host, e.g.
+// https://example.com), or ErrNoRobots if there is none.
+func (r *RedisClient) GetRobots(ctx context.Context, origin string) (*RobotsFile, error) {
+	data, err := r.client.Get(ctx, r.key(fmt.Sprintf(robotsKey, origin))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNoRobots
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f RobotsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// PutRobots caches the robots.txt of origin for ttl.
+func (r *RedisClient) PutRobots(ctx context.Context, origin string, f *RobotsFile, ttl time.Duration) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(fmt.Sprintf(robotsKey, origin)), data, ttl).Err()
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+var runsKey = "runs:%s"
+
+const DefaultRunRetention = 50
+
+// AnalysisRun is one attempt at analysing a tracker's URL. The tracker's
+// Result always mirrors the latest run; older runs are only kept here.
+type AnalysisRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Result     string    `json:"result,omitempty"`
+
+	// Attempt is the tracker's attempt counter at the time of the run and
+	// RetryAt when the next attempt is due if the run is retried.
+	Attempt int       `json:"attempt,omitempty"`
+	RetryAt time.Time `json:"retry_at,omitzero"`
+}
+
+// SetRunRetention limits how many runs are kept per tracker. Older runs are
+// trimmed when a new one is added.
+func (r *RedisClient) SetRunRetention(n int) {
+	r.runRetention = n
+}
+
+func (r *RedisClient) AddRun(ctx context.Context, id string, run *AnalysisRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	retention := r.runRetention
+	if retention <= 0 {
+		retention = DefaultRunRetention
+	}
+
+	key := r.key(fmt.Sprintf(runsKey, id))
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(retention-1))
+	if r.expiration > 0 {
+		pipe.Expire(ctx, key, r.expiration)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetRuns returns the runs kept for a tracker, newest first.
+func (r *RedisClient) GetRuns(ctx context.Context, id string) ([]*AnalysisRun, error) {
+	values, err := r.client.LRange(ctx, r.key(fmt.Sprintf(runsKey, id)), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*AnalysisRun, 0, len(values))
+	for _, v := range values {
+		var run AnalysisRun
+		if err := json.Unmarshal([]byte(v), &run); err != nil {
+			continue
+		}
+		runs = append(runs, &run)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,388 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"urltracker/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var streamKey = "urls:stream"
+
+const streamGroup = "workers"
+
+// reclaimBatch is how many entries one XAUTOCLAIM call takes over.
+const reclaimBatch = 100
+
+// pendingScan caps how many of a consumer's pending entries per lane are
+// searched for a job's entries. A consumer holds one per job in flight.
+const pendingScan = 1000
+
+// settleScript finds the entries of job ARGV[3] that are pending for consumer
+// ARGV[2] of group ARGV[1] in the lane streams KEYS. With ARGV[4] = "ack" it
+// acknowledges and deletes them, otherwise it claims them again, which resets
+// their idle time. It returns how many entries it found.
+var settleScript = redis.NewScript(`
+local found = 0
+for _, stream in ipairs(KEYS) do
+	local pending = redis.call('XPENDING', stream, ARGV[1], '-', '+', ARGV[5], ARGV[2])
+	for _, p in ipairs(pending) do
+		local entry = redis.call('XRANGE', stream, p[1], p[1])[1]
+		local fields = entry and entry[2] or {}
+		for i = 1, #fields, 2 do
+			if fields[i] == 'id' and fields[i + 1] == ARGV[3] then
+				if ARGV[4] == 'ack' then
+					redis.call('XACK', stream, ARGV[1], p[1])
+					redis.call('XDEL', stream, p[1])
+				else
+					redis.call('XCLAIM', stream, ARGV[1], ARGV[2], 0, p[1], 'JUSTID')
+				end
+				found = found + 1
+				break
+			end
+		end
+	end
+end
+return found
+`)
+
+const (
+	QueueList   = "list"
+	QueueStream = "stream"
+)
+
+type QueueStats struct {
+	Mode        string           `json:"mode"`
+	Queued      int64            `json:"queued"`
+	Lanes       map[string]int64 `json:"lanes"`
+	Scheduled   int64            `json:"scheduled"`
+	DeadLetters int64            `json:"dead_letters"`
+	InFlight    int64            `json:"in_flight"`
+	Consumers   []ConsumerStats  `json:"consumers"`
+}
+
+type ConsumerStats struct {
+	Name    string `json:"name"`
+	Pending int64  `json:"pending"`
+	IdleMS  int64  `json:"idle_ms,omitempty"`
+}
+
+// SetQueueMode selects the queue implementation. QueueList (the default)
+// uses urls:queue; QueueStream uses a Redis Stream read through the
+// "workers" consumer group, with the reliable queue consumer as the group
+// consumer name and the lease as the idle time before XAUTOCLAIM steals an
+// entry. Every priority lane gets its own stream. Producers and workers must
+// use the same mode.
+func (r *RedisClient) SetQueueMode(mode string) error {
+	if mode != QueueList && mode != QueueStream {
+		return fmt.Errorf("unknown queue mode %q", mode)
+	}
+	r.mode = mode
+	return nil
+}
+
+func (r *RedisClient) push(ctx context.Context, c redis.Cmdable, id, priority string) error {
+	var err error
+	if r.mode == QueueStream {
+		err = c.XAdd(ctx, &redis.XAddArgs{Stream: r.laneStreamKey(priority), Values: map[string]any{"id": id}}).Err()
+	} else {
+		err = c.LPush(ctx, r.laneKey(priority), id).Err()
+	}
+	if err != nil {
+		return err
+	}
+	return r.notify(ctx, c, 1)
+}
+
+func (r *RedisClient) ensureGroup(ctx context.Context) error {
+	if r.groupReady.Load() {
+		return nil
+	}
+
+	for _, p := range internal.Priorities {
+		err := r.client.XGroupCreateMkStream(ctx, r.laneStreamKey(p), streamGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+
+	r.groupReady.Store(true)
+	return nil
+}
+
+func (r *RedisClient) streamPop(ctx context.Context) (string, error) {
+	if r.consumer == "" {
+		return "", fmt.Errorf("stream queue needs a consumer name")
+	}
+	if err := r.ensureGroup(ctx); err != nil {
+		return "", err
+	}
+
+	lanes, err := r.nextLanes(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range lanes {
+		stream := r.laneStreamKey(p)
+		streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    streamGroup,
+			Consumer: r.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    1,
+			Block:    -1,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			continue
+		}
+
+		// the entry stays in the consumer's pending list until it is
+		// acknowledged, which is all the bookkeeping a lease needs
+		msg := streams[0].Messages[0]
+		id, _ := msg.Values["id"].(string)
+		if id == "" {
+			r.dropEntry(ctx, stream, msg.ID)
+			return "", fmt.Errorf("stream entry %s has no tracker id", msg.ID)
+		}
+		return id, nil
+	}
+
+	return "", redis.Nil
+}
+
+// settle runs settleScript for job id on every lane.
+func (r *RedisClient) settle(ctx context.Context, id, action string) (int, error) {
+	keys := make([]string, 0, len(internal.Priorities))
+	for _, p := range internal.Priorities {
+		keys = append(keys, r.laneStreamKey(p))
+	}
+	return settleScript.Run(ctx, r.client, keys, streamGroup, r.consumer, id, action, pendingScan).Int()
+}
+
+func (r *RedisClient) streamAck(ctx context.Context, id string) error {
+	_, err := r.settle(ctx, id, "ack")
+	return err
+}
+
+// streamExtend resets the idle time of the job's pending entries, which is
+// what XAUTOCLAIM measures the lease against.
+func (r *RedisClient) streamExtend(ctx context.Context, id string) error {
+	n, err := r.settle(ctx, id, "extend")
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+// dropEntry acknowledges and deletes a stream entry so the streams only ever
+// hold undelivered and in-flight jobs.
+func (r *RedisClient) dropEntry(ctx context.Context, stream, entryID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, streamGroup, entryID)
+		pipe.XDel(ctx, stream, entryID)
+		return nil
+	})
+	return err
+}
+
+// streamReclaim uses XAUTOCLAIM to take over entries that have been pending
+// for longer than the lease and re-adds them to the stream so any consumer
+// can pick them up again.
+func (r *RedisClient) streamReclaim(ctx context.Context) (int, error) {
+	if r.consumer == "" {
+		return 0, nil
+	}
+	if err := r.ensureGroup(ctx); err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, p := range internal.Priorities {
+		n, err := r.reclaimLane(ctx, p)
+		requeued += n
+		if err != nil {
+			return requeued, err
+		}
+	}
+
+	return requeued, nil
+}
+
+// reclaimLane follows the XAUTOCLAIM cursor through the whole pending list
+// of the lane's stream.
+func (r *RedisClient) reclaimLane(ctx context.Context, priority string) (int, error) {
+	stream := r.laneStreamKey(priority)
+	requeued := 0
+	start := "0-0"
+	for {
+		msgs, next, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    streamGroup,
+			MinIdle:  r.lease,
+			Start:    start,
+			Count:    reclaimBatch,
+			Consumer: r.consumer,
+		}).Result()
+		if err != nil {
+			return requeued, err
+		}
+
+		for _, msg := range msgs {
+			id, _ := msg.Values["id"].(string)
+			_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.XAck(ctx, stream, streamGroup, msg.ID)
+				pipe.XDel(ctx, stream, msg.ID)
+				if id != "" {
+					r.push(ctx, pipe, id, priority)
+				}
+				return nil
+			})
+			if err != nil {
+				return requeued, err
+			}
+			if id != "" {
+				requeued++
+			}
+		}
+
+		if next == "" || next == "0-0" {
+			return requeued, nil
+		}
+		start = next
+	}
+}
+
+// QueueStats reports how many jobs are waiting in each lane or for their
+// scheduled time, and which consumers hold in-flight jobs.
+func (r *RedisClient) QueueStats(ctx context.Context) (*QueueStats, error) {
+	var stats *QueueStats
+	var err error
+	if r.mode == QueueStream {
+		stats, err = r.streamStats(ctx)
+	} else {
+		stats, err = r.listStats(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Scheduled, err = r.client.ZCard(ctx, r.key(delayedKey)).Result(); err != nil {
+		return nil, err
+	}
+	if stats.DeadLetters, err = r.client.HLen(ctx, r.key(deadLettersKey)).Result(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (r *RedisClient) listStats(ctx context.Context) (*QueueStats, error) {
+	stats := &QueueStats{Mode: QueueList, Lanes: make(map[string]int64), Consumers: []ConsumerStats{}}
+
+	for _, p := range internal.Priorities {
+		queued, err := r.client.LLen(ctx, r.laneKey(p)).Result()
+		if err != nil {
+			return nil, err
+		}
+		stats.Lanes[p] = queued
+		stats.Queued += queued
+	}
+
+	owners, err := r.client.HVals(ctx, r.key(leaseOwnersKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.InFlight = int64(len(owners))
+	stats.Consumers = countConsumers(owners)
+
+	return stats, nil
+}
+
+func (r *RedisClient) streamStats(ctx context.Context) (*QueueStats, error) {
+	if err := r.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := &QueueStats{Mode: QueueStream, Lanes: make(map[string]int64), Consumers: []ConsumerStats{}}
+
+	pending := make(map[string]int64)
+	idle := make(map[string]int64)
+	var names []string
+	for _, p := range internal.Priorities {
+		stream := r.laneStreamKey(p)
+
+		length, err := r.client.XLen(ctx, stream).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		groups, err := r.client.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			return nil, err
+		}
+		var inFlight int64
+		for _, g := range groups {
+			if g.Name == streamGroup {
+				inFlight = g.Pending
+			}
+		}
+		// acked entries are deleted, so everything else is still waiting
+		stats.Lanes[p] = length - inFlight
+		stats.Queued += length - inFlight
+		stats.InFlight += inFlight
+
+		consumers, err := r.client.XInfoConsumers(ctx, stream, streamGroup).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range consumers {
+			if _, ok := pending[c.Name]; !ok {
+				names = append(names, c.Name)
+				idle[c.Name] = c.Idle.Milliseconds()
+			}
+			pending[c.Name] += c.Pending
+			// a consumer is only as idle as its most recent read on any lane
+			if ms := c.Idle.Milliseconds(); ms < idle[c.Name] {
+				idle[c.Name] = ms
+			}
+		}
+	}
+
+	for _, name := range names {
+		stats.Consumers = append(stats.Consumers, ConsumerStats{
+			Name:    name,
+			Pending: pending[name],
+			IdleMS:  idle[name],
+		})
+	}
+
+	return stats, nil
+}
+
+func countConsumers(owners []string) []ConsumerStats {
+	counts := make(map[string]int64)
+	var names []string
+	for _, o := range owners {
+		if counts[o] == 0 {
+			names = append(names, o)
+		}
+		counts[o]++
+	}
+
+	consumers := make([]ConsumerStats, 0, len(names))
+	for _, name := range names {
+		consumers = append(consumers, ConsumerStats{Name: name, Pending: counts[name]})
+	}
+	return consumers
+}
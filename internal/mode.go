@@ -0,0 +1,18 @@
+package internal
+
+// Submission modes. A page submission analyses the submitted URL only, a site
+// submission audits every page of its site that can be reached from it and a
+// sitemap submission queues an analysis of every page its sitemaps list.
+const (
+	ModePage    = "page"
+	ModeSite    = "site"
+	ModeSitemap = "sitemap"
+)
+
+func IsValidMode(m string) bool {
+	switch m {
+	case ModePage, ModeSite, ModeSitemap:
+		return true
+	}
+	return false
+}
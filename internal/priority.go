@@ -0,0 +1,18 @@
+package internal
+
+const (
+	PriorityInteractive = "interactive"
+	PriorityNormal      = "normal"
+	PriorityBulk        = "bulk"
+)
+
+// Priorities lists the priority levels from most to least urgent.
+var Priorities = []string{PriorityInteractive, PriorityNormal, PriorityBulk}
+
+func IsValidPriority(p string) bool {
+	switch p {
+	case PriorityInteractive, PriorityNormal, PriorityBulk:
+		return true
+	}
+	return false
+}
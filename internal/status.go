@@ -5,4 +5,13 @@ const (
 	StatusProcessing = "processing"
 	StatusCompleted  = "completed"
 	StatusFailed     = "failed"
+	StatusRetrying   = "retrying"
 )
+
+func IsValidStatus(s string) bool {
+	switch s {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusFailed, StatusRetrying:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"urltracker/internal/cache"
+)
+
+// ConfigFromEnv reads STORAGE_BACKEND, STORAGE_PATH, QUEUE_MODE,
+// QUEUE_WEIGHTS and the Redis connection settings.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Backend:     os.Getenv("STORAGE_BACKEND"),
+		FilePath:    os.Getenv("STORAGE_PATH"),
+		QueueMode:   os.Getenv("QUEUE_MODE"),
+		LaneWeights: os.Getenv("QUEUE_WEIGHTS"),
+	}
+
+	if cfg.Backend == "" {
+		cfg.Backend = BackendRedis
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "urltracker.json"
+	}
+	if cfg.QueueMode == "" {
+		cfg.QueueMode = cache.QueueList
+	}
+
+	if cfg.Backend == BackendRedis {
+		redisCfg, err := RedisConfigFromEnv()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Redis = redisCfg
+	}
+
+	return cfg, nil
+}
+
+// RedisConfigFromEnv starts from REDIS_URL, or else from the comma separated
+// REDIS_ADDR (default redis:6379), and applies the individual REDIS_*
+// settings on top.
+func RedisConfigFromEnv() (cache.RedisConfig, error) {
+	var cfg cache.RedisConfig
+
+	if raw := os.Getenv("REDIS_URL"); raw != "" {
+		var err error
+		if cfg, err = cache.ParseRedisURL(raw); err != nil {
+			return cfg, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+	} else {
+		addrs := os.Getenv("REDIS_ADDR")
+		if addrs == "" {
+			addrs = "redis:6379"
+		}
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				cfg.Addrs = append(cfg.Addrs, addr)
+			}
+		}
+	}
+
+	strs := map[string]*string{
+		"REDIS_USERNAME":          &cfg.Username,
+		"REDIS_PASSWORD":          &cfg.Password,
+		"REDIS_SENTINEL_MASTER":   &cfg.MasterName,
+		"REDIS_SENTINEL_USERNAME": &cfg.SentinelUsername,
+		"REDIS_SENTINEL_PASSWORD": &cfg.SentinelPassword,
+		"REDIS_TLS_CA_FILE":       &cfg.TLSCAFile,
+		"REDIS_TLS_CERT_FILE":     &cfg.TLSCertFile,
+		"REDIS_TLS_KEY_FILE":      &cfg.TLSKeyFile,
+		"REDIS_TLS_SERVER_NAME":   &cfg.TLSServerName,
+		"REDIS_KEY_PREFIX":        &cfg.KeyPrefix,
+	}
+	for name, dst := range strs {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+
+	bools := map[string]*bool{
+		"REDIS_TLS":                      &cfg.TLS,
+		"REDIS_TLS_INSECURE_SKIP_VERIFY": &cfg.TLSInsecureSkipVerify,
+		"REDIS_CLUSTER":                  &cfg.Cluster,
+	}
+	for name, dst := range bools {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = b
+		}
+	}
+
+	ints := map[string]*int{
+		"REDIS_DB":             &cfg.DB,
+		"REDIS_POOL_SIZE":      &cfg.PoolSize,
+		"REDIS_MIN_IDLE_CONNS": &cfg.MinIdleConns,
+	}
+	for name, dst := range ints {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"REDIS_DIAL_TIMEOUT":  &cfg.DialTimeout,
+		"REDIS_READ_TIMEOUT":  &cfg.ReadTimeout,
+		"REDIS_WRITE_TIMEOUT": &cfg.WriteTimeout,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = d
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,886 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"urltracker/internal"
+	"urltracker/internal/cache"
+)
+
+// LocalStore keeps all data in process memory. When created with
+// NewFileStore every change to trackers, queues, runs, dead letters and
+// batches is also written to a single JSON file, guarded by a lock file, so
+// several processes on the same machine can share them without a Redis
+// server. The host limits and the robots.txt and link caches stay in the
+// memory of each process, see localCaches.
+type LocalStore struct {
+	mu   sync.Mutex
+	path string
+	// loaded is the data file as of the last load or save. Every save
+	// renames a new file into place, so another process wrote to it if the
+	// file is no longer the same.
+	loaded       os.FileInfo
+	state        *localState
+	caches       *localCaches
+	consumer     string
+	lease        time.Duration
+	runRetention int
+	weights      map[string]int
+	// turn counts the dequeue turns of this process, see cache.LaneOrder.
+	turn         int64
+	blockTimeout time.Duration
+	// wake is closed when a job is queued, see DequeueURL.
+	wake chan struct{}
+}
+
+// localPollInterval is how often a blocked DequeueURL of a file store looks
+// for jobs queued by other processes.
+const localPollInterval = 250 * time.Millisecond
+
+type localLease struct {
+	Consumer string    `json:"consumer"`
+	Lane     string    `json:"lane"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// localCaches holds what a LocalStore never writes to its file: they change
+// with nearly every request a worker makes and are cheap to rebuild, so
+// rewriting the whole file for them is not worth it. Each process sharing a
+// file store therefore keeps its own host limits.
+type localCaches struct {
+	mu     sync.Mutex
+	hosts  map[string]*localHost
+	robots map[string]localRobots
+	links  map[string]localLink
+}
+
+func newLocalCaches() *localCaches {
+	return &localCaches{
+		hosts:  make(map[string]*localHost),
+		robots: make(map[string]localRobots),
+		links:  make(map[string]localLink),
+	}
+}
+
+// localHost is the token bucket and the concurrency slots (job ID to slot
+// deadline) of one host.
+type localHost struct {
+	tokens float64
+	at     time.Time
+	slots  map[string]time.Time
+}
+
+type localRobots struct {
+	file    cache.RobotsFile
+	expires time.Time
+}
+
+type localLink struct {
+	status  cache.LinkStatus
+	expires time.Time
+}
+
+type localDelayed struct {
+	Priority string    `json:"priority"`
+	At       time.Time `json:"at"`
+}
+
+type localState struct {
+	Trackers map[string]*cache.URLTracker `json:"trackers"`
+	// Queues holds one FIFO lane per priority.
+	Queues    map[string][]string             `json:"queues"`
+	Delayed   map[string]localDelayed         `json:"delayed"`
+	Leases    map[string]localLease           `json:"leases"`
+	Canonical map[string]string               `json:"canonical"`
+	Runs      map[string][]*cache.AnalysisRun `json:"runs"`
+	Dead      map[string]*cache.DeadLetter    `json:"dead"`
+	Batches   map[string][]string             `json:"batches"`
+
+	// pushed is set when a job was queued and waiting dequeuers should
+	// look again.
+	pushed bool
+}
+
+func newLocalState() *localState {
+	return &localState{
+		Trackers:  make(map[string]*cache.URLTracker),
+		Queues:    make(map[string][]string),
+		Delayed:   make(map[string]localDelayed),
+		Leases:    make(map[string]localLease),
+		Canonical: make(map[string]string),
+		Runs:      make(map[string][]*cache.AnalysisRun),
+		Dead:      make(map[string]*cache.DeadLetter),
+		Batches:   make(map[string][]string),
+	}
+}
+
+func NewMemoryStore() *LocalStore {
+	return &LocalStore{state: newLocalState(), caches: newLocalCaches(), runRetention: cache.DefaultRunRetention, weights: cache.DefaultLaneWeights}
+}
+
+func NewFileStore(path string) (*LocalStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &LocalStore{
+		path:         path,
+		state:        newLocalState(),
+		caches:       newLocalCaches(),
+		runRetention: cache.DefaultRunRetention,
+		weights:      cache.DefaultLaneWeights,
+	}
+	if err := s.update(func(*localState) error { return nil }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LocalStore) UseReliableQueue(consumer string, lease time.Duration) {
+	s.consumer = consumer
+	s.lease = lease
+}
+
+func (s *LocalStore) SetRunRetention(n int) {
+	s.runRetention = n
+}
+
+func (s *LocalStore) SetLaneWeights(weights map[string]int) {
+	s.weights = weights
+}
+
+func (s *LocalStore) StoreURL(_ context.Context, tracker *cache.URLTracker) error {
+	return s.update(func(st *localState) error {
+		if tracker.CanonicalURL != "" {
+			if _, ok := st.Canonical[tracker.CanonicalURL]; ok {
+				return cache.ErrDuplicateURL
+			}
+			st.Canonical[tracker.CanonicalURL] = tracker.ID
+		}
+
+		tracker.Revision = 1
+		st.Trackers[tracker.ID] = copyTracker(tracker)
+		st.schedule(tracker.ID, tracker.Priority, tracker.NotBefore)
+		return nil
+	})
+}
+
+func (s *LocalStore) GetURL(_ context.Context, id string) (*cache.URLTracker, error) {
+	var tracker *cache.URLTracker
+	err := s.view(func(st *localState) error {
+		t, ok := st.Trackers[id]
+		if !ok {
+			return cache.ErrNotFound
+		}
+		tracker = copyTracker(t)
+		return nil
+	})
+	return tracker, err
+}
+
+func (s *LocalStore) UpdateURL(_ context.Context, t *cache.URLTracker) error {
+	return s.update(func(st *localState) error {
+		tracker, ok := st.Trackers[t.ID]
+		if !ok {
+			return cache.ErrNotFound
+		}
+		if tracker.Revision != t.Revision {
+			return fmt.Errorf("%w: %s is at revision %d, not %d", cache.ErrConflict, t.ID, tracker.Revision, t.Revision)
+		}
+
+		tracker.Status = t.Status
+		tracker.Priority = t.Priority
+		tracker.NotBefore = t.NotBefore
+		tracker.UpdatedAt = time.Now()
+		tracker.Result = t.Result
+		tracker.Error = t.Error
+		tracker.Attempts = t.Attempts
+		tracker.Audit = t.Audit
+		tracker.Progress = t.Progress
+		tracker.Sitemap = t.Sitemap
+		tracker.Analyzers = t.Analyzers
+		tracker.Revision++
+
+		t.Revision = tracker.Revision
+		t.UpdatedAt = tracker.UpdatedAt
+		return nil
+	})
+}
+
+func (s *LocalStore) FindByCanonical(_ context.Context, canonical string) (*cache.URLTracker, error) {
+	var tracker *cache.URLTracker
+	err := s.view(func(st *localState) error {
+		if t, ok := st.Trackers[st.Canonical[canonical]]; ok {
+			tracker = copyTracker(t)
+		}
+		return nil
+	})
+	return tracker, err
+}
+
+func (s *LocalStore) ListURLs(_ context.Context, opts cache.ListOptions) (*cache.URLPage, error) {
+	desc := opts.Order != cache.OrderAsc
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = cache.DefaultPageSize
+	}
+	if limit > cache.MaxPageSize {
+		limit = cache.MaxPageSize
+	}
+
+	var after *cache.Cursor
+	if opts.Cursor != "" {
+		c, err := cache.DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &c
+	}
+
+	score := func(t *cache.URLTracker) int64 {
+		if opts.SortBy == cache.SortUpdated {
+			return cache.IndexScore(t.UpdatedAt)
+		}
+		return cache.IndexScore(t.CreatedAt)
+	}
+
+	var matches []*cache.URLTracker
+	err := s.view(func(st *localState) error {
+		for _, t := range st.Trackers {
+			if opts.Status != "" && t.Status != opts.Status {
+				continue
+			}
+			matches = append(matches, copyTracker(t))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// same ordering as the Redis sorted sets: by score, then by ID
+	sort.Slice(matches, func(i, j int) bool {
+		si, sj := score(matches[i]), score(matches[j])
+		if si != sj {
+			return (si < sj) != desc
+		}
+		return (matches[i].ID < matches[j].ID) != desc
+	})
+
+	page := &cache.URLPage{Trackers: []*cache.URLTracker{}}
+	for _, t := range matches {
+		if after != nil {
+			sc := score(t)
+			if desc && sc > after.Score || !desc && sc < after.Score || after.Skips(sc, t.ID, desc) {
+				continue
+			}
+		}
+
+		if len(page.Trackers) == limit {
+			last := page.Trackers[limit-1]
+			page.NextCursor = cache.EncodeCursor(cache.Cursor{Score: score(last), ID: last.ID})
+			break
+		}
+		page.Trackers = append(page.Trackers, t)
+	}
+
+	return page, nil
+}
+
+func (s *LocalStore) EnqueueURL(_ context.Context, id, priority string) error {
+	return s.update(func(st *localState) error {
+		st.push(id, priority)
+		return nil
+	})
+}
+
+func (s *LocalStore) ScheduleURL(_ context.Context, id, priority string, at time.Time) error {
+	return s.update(func(st *localState) error {
+		st.schedule(id, priority, at)
+		return nil
+	})
+}
+
+func (s *LocalStore) PromoteDue(_ context.Context) (int, error) {
+	promoted := 0
+	err := s.update(func(st *localState) error {
+		now := time.Now()
+		var due []string
+		for id, d := range st.Delayed {
+			if !d.At.After(now) {
+				due = append(due, id)
+			}
+		}
+		// oldest first, like the Redis sorted set
+		sort.Slice(due, func(i, j int) bool {
+			return st.Delayed[due[i]].At.Before(st.Delayed[due[j]].At)
+		})
+
+		for _, id := range due {
+			st.push(id, st.Delayed[id].Priority)
+			delete(st.Delayed, id)
+			promoted++
+		}
+		return nil
+	})
+	return promoted, err
+}
+
+// SetBlockTimeout makes DequeueURL wait up to d for a job when the queue is
+// empty. Zero, the default, returns right away.
+func (s *LocalStore) SetBlockTimeout(d time.Duration) {
+	s.blockTimeout = d
+}
+
+func (s *LocalStore) DequeueURL(ctx context.Context) (*cache.URLTracker, error) {
+	deadline := time.Now().Add(s.blockTimeout)
+	for {
+		// take the channel first so a job queued in between is not missed
+		wake := s.wakeChan()
+		tracker, err := s.dequeue()
+		if tracker != nil || err != nil || !time.Now().Before(deadline) {
+			return tracker, err
+		}
+
+		// Other processes sharing the data file cannot close wake, so look
+		// again every localPollInterval as well.
+		t := time.NewTimer(min(time.Until(deadline), localPollInterval))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+func (s *LocalStore) wakeChan() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wake == nil {
+		s.wake = make(chan struct{})
+	}
+	return s.wake
+}
+
+func (s *LocalStore) dequeue() (*cache.URLTracker, error) {
+	var tracker *cache.URLTracker
+	err := s.update(func(st *localState) error {
+		dropped := false
+		s.turn++
+		for _, lane := range cache.LaneOrder(s.turn, s.weights) {
+			for len(st.Queues[lane]) > 0 {
+				id := st.Queues[lane][0]
+				st.Queues[lane] = st.Queues[lane][1:]
+
+				t, ok := st.Trackers[id]
+				if !ok {
+					st.Dead[id] = &cache.DeadLetter{
+						ID:        id,
+						Reason:    cache.ReasonMissingTracker,
+						LastError: cache.ErrNotFound.Error(),
+						DeadAt:    time.Now(),
+					}
+					dropped = true
+					continue
+				}
+				if s.consumer != "" {
+					st.Leases[id] = localLease{Consumer: s.consumer, Lane: lane, Deadline: time.Now().Add(s.lease)}
+				}
+				tracker = copyTracker(t)
+				return nil
+			}
+		}
+		if !dropped {
+			// idle workers poll an empty queue, leave the file alone
+			return errUnchanged
+		}
+		return nil
+	})
+	return tracker, err
+}
+
+func (s *LocalStore) AckURL(_ context.Context, id string) error {
+	if s.consumer == "" {
+		return nil
+	}
+
+	return s.update(func(st *localState) error {
+		delete(st.Leases, id)
+		return nil
+	})
+}
+
+func (s *LocalStore) ExtendLease(_ context.Context, id string) error {
+	if s.consumer == "" {
+		return nil
+	}
+
+	return s.update(func(st *localState) error {
+		l, ok := st.Leases[id]
+		if !ok || l.Consumer != s.consumer {
+			return fmt.Errorf("%w: %s", cache.ErrLeaseLost, id)
+		}
+		l.Deadline = time.Now().Add(s.lease)
+		st.Leases[id] = l
+		return nil
+	})
+}
+
+func (s *LocalStore) RequeueExpired(_ context.Context) (int, error) {
+	requeued := 0
+	err := s.update(func(st *localState) error {
+		now := time.Now()
+		for id, l := range st.Leases {
+			if l.Deadline.After(now) {
+				continue
+			}
+			delete(st.Leases, id)
+			// expired jobs go to the front of their lane
+			lane := cache.NormalizePriority(l.Lane)
+			st.Queues[lane] = append([]string{id}, st.Queues[lane]...)
+			st.pushed = true
+			requeued++
+		}
+		return nil
+	})
+	return requeued, err
+}
+
+func (s *LocalStore) QueueStats(_ context.Context) (*cache.QueueStats, error) {
+	stats := &cache.QueueStats{Mode: cache.QueueList, Lanes: make(map[string]int64), Consumers: []cache.ConsumerStats{}}
+	err := s.view(func(st *localState) error {
+		for _, p := range internal.Priorities {
+			stats.Lanes[p] = int64(len(st.Queues[p]))
+			stats.Queued += stats.Lanes[p]
+		}
+		stats.InFlight = int64(len(st.Leases))
+		stats.Scheduled = int64(len(st.Delayed))
+		stats.DeadLetters = int64(len(st.Dead))
+
+		pending := make(map[string]int64)
+		for _, l := range st.Leases {
+			pending[l.Consumer]++
+		}
+		for name, n := range pending {
+			stats.Consumers = append(stats.Consumers, cache.ConsumerStats{Name: name, Pending: n})
+		}
+		sort.Slice(stats.Consumers, func(i, j int) bool {
+			return stats.Consumers[i].Name < stats.Consumers[j].Name
+		})
+		return nil
+	})
+	return stats, err
+}
+
+func (s *LocalStore) AddRun(_ context.Context, id string, run *cache.AnalysisRun) error {
+	return s.update(func(st *localState) error {
+		c := *run
+		runs := append([]*cache.AnalysisRun{&c}, st.Runs[id]...)
+		if len(runs) > s.runRetention {
+			runs = runs[:s.runRetention]
+		}
+		st.Runs[id] = runs
+		return nil
+	})
+}
+
+func (s *LocalStore) GetRuns(_ context.Context, id string) ([]*cache.AnalysisRun, error) {
+	var runs []*cache.AnalysisRun
+	err := s.view(func(st *localState) error {
+		for _, r := range st.Runs[id] {
+			c := *r
+			runs = append(runs, &c)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+func (s *LocalStore) AddDeadLetter(_ context.Context, d *cache.DeadLetter) error {
+	if d.DeadAt.IsZero() {
+		d.DeadAt = time.Now()
+	}
+	return s.update(func(st *localState) error {
+		c := *d
+		st.Dead[d.ID] = &c
+		return nil
+	})
+}
+
+func (s *LocalStore) ListDeadLetters(_ context.Context) ([]*cache.DeadLetter, error) {
+	letters := []*cache.DeadLetter{}
+	err := s.view(func(st *localState) error {
+		for _, d := range st.Dead {
+			c := *d
+			letters = append(letters, &c)
+		}
+		return nil
+	})
+
+	// most recent first, like the Redis index
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].DeadAt.After(letters[j].DeadAt)
+	})
+	return letters, err
+}
+
+func (s *LocalStore) GetDeadLetter(_ context.Context, id string) (*cache.DeadLetter, error) {
+	var letter *cache.DeadLetter
+	err := s.view(func(st *localState) error {
+		d, ok := st.Dead[id]
+		if !ok {
+			return cache.ErrNoDeadLetter
+		}
+		c := *d
+		letter = &c
+		return nil
+	})
+	return letter, err
+}
+
+func (s *LocalStore) RemoveDeadLetter(_ context.Context, id string) error {
+	return s.update(func(st *localState) error {
+		if _, ok := st.Dead[id]; !ok {
+			return cache.ErrNoDeadLetter
+		}
+		delete(st.Dead, id)
+		return nil
+	})
+}
+
+func (s *LocalStore) PurgeDeadLetters(_ context.Context) (int, error) {
+	purged := 0
+	err := s.update(func(st *localState) error {
+		purged = len(st.Dead)
+		st.Dead = make(map[string]*cache.DeadLetter)
+		return nil
+	})
+	return purged, err
+}
+
+func (s *LocalStore) AcquireHost(_ context.Context, host, id string, limit cache.HostLimit, hold time.Duration) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &localHost{tokens: float64(max(limit.Burst, 1)), at: now, slots: make(map[string]time.Time)}
+		c.hosts[host] = h
+	}
+
+	if limit.Concurrency > 0 {
+		for job, deadline := range h.slots {
+			if !deadline.After(now) {
+				delete(h.slots, job)
+			}
+		}
+		if _, held := h.slots[id]; !held && len(h.slots) >= limit.Concurrency {
+			return 0, cache.ErrHostBusy
+		}
+	}
+
+	if limit.Rate > 0 {
+		burst := float64(max(limit.Burst, 1))
+		h.tokens = min(burst, h.tokens+now.Sub(h.at).Seconds()*limit.Rate)
+		h.at = now
+		if h.tokens < 1 {
+			return time.Duration(math.Ceil((1-h.tokens)*1000/limit.Rate)) * time.Millisecond, nil
+		}
+		h.tokens--
+	}
+
+	if limit.Concurrency > 0 {
+		h.slots[id] = now.Add(hold)
+	}
+	return 0, nil
+}
+
+func (s *LocalStore) ReleaseHost(_ context.Context, host, id string) error {
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.hosts[host]; ok {
+		delete(h.slots, id)
+	}
+	return nil
+}
+
+func (s *LocalStore) GetRobots(_ context.Context, origin string) (*cache.RobotsFile, error) {
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.robots[origin]
+	if !ok || !r.expires.After(time.Now()) {
+		return nil, cache.ErrNoRobots
+	}
+	f := r.file
+	return &f, nil
+}
+
+func (s *LocalStore) PutRobots(_ context.Context, origin string, f *cache.RobotsFile, ttl time.Duration) error {
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for o, r := range c.robots {
+		if !r.expires.After(now) {
+			delete(c.robots, o)
+		}
+	}
+	c.robots[origin] = localRobots{file: *f, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *LocalStore) GetLink(_ context.Context, link string) (*cache.LinkStatus, error) {
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.links[link]
+	if !ok || !l.expires.After(time.Now()) {
+		return nil, cache.ErrNoLink
+	}
+	ls := l.status
+	return &ls, nil
+}
+
+func (s *LocalStore) PutLink(_ context.Context, link string, ls *cache.LinkStatus, ttl time.Duration) error {
+	c := s.caches
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for u, l := range c.links {
+		if !l.expires.After(now) {
+			delete(c.links, u)
+		}
+	}
+	c.links[link] = localLink{status: *ls, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *LocalStore) AddToBatch(_ context.Context, batch string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.update(func(st *localState) error {
+		members := st.Batches[batch]
+		for _, id := range ids {
+			if !slices.Contains(members, id) {
+				members = append(members, id)
+			}
+		}
+		sort.Strings(members)
+		st.Batches[batch] = members
+		return nil
+	})
+}
+
+func (s *LocalStore) GetBatch(_ context.Context, batch string) ([]*cache.URLTracker, error) {
+	var trackers []*cache.URLTracker
+	err := s.view(func(st *localState) error {
+		trackers = make([]*cache.URLTracker, 0, len(st.Batches[batch]))
+		for _, id := range st.Batches[batch] {
+			if t, ok := st.Trackers[id]; ok {
+				trackers = append(trackers, copyTracker(t))
+			}
+		}
+		return nil
+	})
+	return trackers, err
+}
+
+func (s *LocalStore) Close() error {
+	return nil
+}
+
+// view runs fn against the current state without persisting it.
+func (s *LocalStore) view(fn func(st *localState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return fn(s.state)
+	}
+
+	unlock, err := lockFile(s.path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	return fn(s.state)
+}
+
+// errUnchanged is returned by the function passed to update when it did not
+// change the state, which then need not be saved.
+var errUnchanged = errors.New("state unchanged")
+
+// update runs fn against the current state and persists the result if fn
+// succeeds.
+func (s *LocalStore) update(fn func(st *localState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		if err := fn(s.state); err != nil && !errors.Is(err, errUnchanged) {
+			return err
+		}
+		s.wakeWaiters()
+		return nil
+	}
+
+	unlock, err := lockFile(s.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := fn(s.state); errors.Is(err, errUnchanged) {
+		return nil
+	} else if err != nil {
+		// drop partial changes, the next call reloads from disk
+		s.loaded = nil
+		return err
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	s.wakeWaiters()
+	return nil
+}
+
+// wakeWaiters lets blocked DequeueURL calls look again if a job was queued.
+// The caller must hold s.mu.
+func (s *LocalStore) wakeWaiters() {
+	if !s.state.pushed {
+		return
+	}
+	s.state.pushed = false
+	if s.wake != nil {
+		close(s.wake)
+		s.wake = nil
+	}
+}
+
+// load reads the data file if another process changed it since it was last
+// read. The caller must hold the file lock.
+func (s *LocalStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.state = newLocalState()
+		s.loaded = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.loaded != nil && os.SameFile(info, s.loaded) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	st := newLocalState()
+	if err := json.Unmarshal(data, st); err != nil {
+		return err
+	}
+	s.state = st
+	s.loaded = info
+	return nil
+}
+
+// save writes the state to a temporary file and renames it over the data
+// file, syncing both so a crash leaves either the old or the new state.
+func (s *LocalStore) save() error {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.loaded = info
+	return nil
+}
+
+func (st *localState) push(id, priority string) {
+	lane := cache.NormalizePriority(priority)
+	st.Queues[lane] = append(st.Queues[lane], id)
+	st.pushed = true
+}
+
+// schedule queues id right away, or in the delay queue if at lies in the
+// future.
+func (st *localState) schedule(id, priority string, at time.Time) {
+	if !at.After(time.Now()) {
+		st.push(id, priority)
+		return
+	}
+	st.Delayed[id] = localDelayed{Priority: priority, At: at}
+}
+
+func copyTracker(t *cache.URLTracker) *cache.URLTracker {
+	c := *t
+	return &c
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package storage
+
+// lockFile is a no-op where flock is unavailable; the file backend is then
+// only safe for a single process.
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
+
+// syncDir is a no-op where directories cannot be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"urltracker/internal/cache"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendFile   = "file"
+	BackendMemory = "memory"
+)
+
+type Trackers interface {
+	StoreURL(ctx context.Context, tracker *cache.URLTracker) error
+	GetURL(ctx context.Context, id string) (*cache.URLTracker, error)
+	UpdateURL(ctx context.Context, t *cache.URLTracker) error
+	FindByCanonical(ctx context.Context, canonical string) (*cache.URLTracker, error)
+	ListURLs(ctx context.Context, opts cache.ListOptions) (*cache.URLPage, error)
+}
+
+type Queue interface {
+	EnqueueURL(ctx context.Context, id, priority string) error
+	ScheduleURL(ctx context.Context, id, priority string, at time.Time) error
+	PromoteDue(ctx context.Context) (int, error)
+	DequeueURL(ctx context.Context) (*cache.URLTracker, error)
+	AckURL(ctx context.Context, id string) error
+	ExtendLease(ctx context.Context, id string) error
+	RequeueExpired(ctx context.Context) (int, error)
+	QueueStats(ctx context.Context) (*cache.QueueStats, error)
+}
+
+type Runs interface {
+	AddRun(ctx context.Context, id string, run *cache.AnalysisRun) error
+	GetRuns(ctx context.Context, id string) ([]*cache.AnalysisRun, error)
+}
+
+type DeadLetters interface {
+	AddDeadLetter(ctx context.Context, d *cache.DeadLetter) error
+	ListDeadLetters(ctx context.Context) ([]*cache.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id string) (*cache.DeadLetter, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+	PurgeDeadLetters(ctx context.Context) (int, error)
+}
+
+// Hosts shares per-host rate limits and concurrency caps between workers.
+type Hosts interface {
+	AcquireHost(ctx context.Context, host, id string, limit cache.HostLimit, hold time.Duration) (time.Duration, error)
+	ReleaseHost(ctx context.Context, host, id string) error
+}
+
+// Robots caches robots.txt files per origin.
+type Robots interface {
+	GetRobots(ctx context.Context, origin string) (*cache.RobotsFile, error)
+	PutRobots(ctx context.Context, origin string, f *cache.RobotsFile, ttl time.Duration) error
+}
+
+// Links caches the status of probed links.
+type Links interface {
+	GetLink(ctx context.Context, link string) (*cache.LinkStatus, error)
+	PutLink(ctx context.Context, link string, s *cache.LinkStatus, ttl time.Duration) error
+}
+
+// Batches groups the trackers created for a submission, such as the pages
+// listed in a sitemap.
+type Batches interface {
+	AddToBatch(ctx context.Context, batch string, ids ...string) error
+	GetBatch(ctx context.Context, batch string) ([]*cache.URLTracker, error)
+}
+
+// Store is everything the api, web and worker services need from a storage
+// backend.
+type Store interface {
+	Trackers
+	Queue
+	Runs
+	DeadLetters
+	Hosts
+	Robots
+	Links
+	Batches
+	Close() error
+}
+
+// Indexer is implemented by backends whose listing indexes may need to be
+// backfilled on startup.
+type Indexer interface {
+	RebuildIndex(ctx context.Context) (int, error)
+}
+
+var (
+	_ Store   = (*cache.RedisClient)(nil)
+	_ Indexer = (*cache.RedisClient)(nil)
+	_ Store   = (*LocalStore)(nil)
+)
+
+type Config struct {
+	Backend   string
+	Redis     cache.RedisConfig
+	FilePath  string
+	QueueMode string
+
+	// LaneWeights overrides the default priority lane weights, e.g.
+	// "interactive=6,normal=3,bulk=1".
+	LaneWeights string
+
+	// Consumer enables the reliable queue: dequeued jobs are leased to this
+	// consumer until acked.
+	Consumer     string
+	Lease        time.Duration
+	RunRetention int
+
+	// BlockTimeout makes DequeueURL wait this long for a job when the queue
+	// is empty instead of returning right away.
+	BlockTimeout time.Duration
+}
+
+// Describe returns a short human readable description of the backend for
+// startup logs.
+func (cfg Config) Describe() string {
+	switch cfg.Backend {
+	case BackendRedis:
+		return "redis " + cfg.Redis.Describe()
+	case BackendFile:
+		return "file " + cfg.FilePath
+	}
+	return cfg.Backend
+}
+
+func Open(cfg Config) (Store, error) {
+	weights, err := cache.ParseLaneWeights(cfg.LaneWeights)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Backend {
+	case BackendRedis:
+		r, err := cache.NewRedisClientFromConfig(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		r.SetLaneWeights(weights)
+		if cfg.QueueMode != "" {
+			if err := r.SetQueueMode(cfg.QueueMode); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.Consumer != "" {
+			r.UseReliableQueue(cfg.Consumer, cfg.Lease)
+		}
+		if cfg.RunRetention > 0 {
+			r.SetRunRetention(cfg.RunRetention)
+		}
+		r.SetBlockTimeout(cfg.BlockTimeout)
+		return r, nil
+	case BackendFile, BackendMemory:
+		if cfg.QueueMode != "" && cfg.QueueMode != cache.QueueList {
+			return nil, fmt.Errorf("queue mode %q needs the redis backend", cfg.QueueMode)
+		}
+
+		var s *LocalStore
+		if cfg.Backend == BackendFile {
+			if s, err = NewFileStore(cfg.FilePath); err != nil {
+				return nil, err
+			}
+		} else {
+			s = NewMemoryStore()
+		}
+		s.SetLaneWeights(weights)
+		if cfg.Consumer != "" {
+			s.UseReliableQueue(cfg.Consumer, cfg.Lease)
+		}
+		if cfg.RunRetention > 0 {
+			s.SetRunRetention(cfg.RunRetention)
+		}
+		s.SetBlockTimeout(cfg.BlockTimeout)
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
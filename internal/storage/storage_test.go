@@ -0,0 +1,770 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"urltracker/internal"
+	"urltracker/internal/cache"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// openBackends opens every backend with the same configuration so the tests
+// below check they all behave alike.
+func openBackends(t *testing.T, cfg Config) map[string]Store {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run() error = %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	stores := make(map[string]Store)
+	for _, backend := range []string{BackendRedis, BackendFile, BackendMemory} {
+		c := cfg
+		c.Backend = backend
+		c.Redis = cache.RedisConfig{Addrs: []string{mr.Addr()}}
+		c.FilePath = filepath.Join(t.TempDir(), "data.json")
+
+		s, err := Open(c)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", backend, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[backend] = s
+	}
+
+	return stores
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := Open(Config{Backend: "etcd"}); err == nil {
+		t.Error("Open() expected error for unknown backend")
+	}
+}
+
+func TestOpenStreamModeNeedsRedis(t *testing.T) {
+	if _, err := Open(Config{Backend: BackendMemory, QueueMode: cache.QueueStream}); err == nil {
+		t.Error("Open() expected error for stream mode on the memory backend")
+	}
+}
+
+func TestStoreTrackers(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+		tracker := &cache.URLTracker{
+			ID:           "t-1",
+			URL:          "https://example.com",
+			CanonicalURL: "https://example.com/",
+			Status:       internal.StatusPending,
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		}
+		if err := s.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+
+		dup := *tracker
+		dup.ID = "t-2"
+		if err := s.StoreURL(ctx, &dup); err != cache.ErrDuplicateURL {
+			t.Errorf("%s: StoreURL() duplicate error = %v, want %v", name, err, cache.ErrDuplicateURL)
+		}
+
+		if _, err := s.GetURL(ctx, "missing"); err != cache.ErrNotFound {
+			t.Errorf("%s: GetURL(missing) error = %v, want %v", name, err, cache.ErrNotFound)
+		}
+
+		tracker.Status = internal.StatusCompleted
+		tracker.Result = "ok"
+		tracker.Audit = &cache.SiteAudit{MaxDepth: 2, MaxPages: 10}
+		tracker.Progress = &cache.Progress{Done: 3, Queued: 1, Limit: 10}
+		if err := s.UpdateURL(ctx, tracker); err != nil {
+			t.Fatalf("%s: UpdateURL() error = %v", name, err)
+		}
+
+		got, err := s.FindByCanonical(ctx, "https://example.com/")
+		if err != nil {
+			t.Fatalf("%s: FindByCanonical() error = %v", name, err)
+		}
+		if got == nil || got.Status != internal.StatusCompleted || got.Result != "ok" {
+			t.Errorf("%s: FindByCanonical() = %+v, want updated tracker", name, got)
+		}
+		if got != nil && (got.Audit == nil || got.Audit.MaxPages != 10 || got.Progress == nil || got.Progress.Done != 3) {
+			t.Errorf("%s: FindByCanonical() audit = %+v, progress = %+v, want them updated", name, got.Audit, got.Progress)
+		}
+
+		page, err := s.ListURLs(ctx, cache.ListOptions{Status: internal.StatusCompleted})
+		if err != nil {
+			t.Fatalf("%s: ListURLs() error = %v", name, err)
+		}
+		if len(page.Trackers) != 1 || page.Trackers[0].ID != tracker.ID {
+			t.Errorf("%s: ListURLs(completed) = %v, want [%s]", name, page.Trackers, tracker.ID)
+		}
+
+		page, err = s.ListURLs(ctx, cache.ListOptions{Status: internal.StatusPending})
+		if err != nil {
+			t.Fatalf("%s: ListURLs() error = %v", name, err)
+		}
+		if len(page.Trackers) != 0 {
+			t.Errorf("%s: ListURLs(pending) = %v, want none", name, page.Trackers)
+		}
+	}
+}
+
+func TestStoreUpdateConflict(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+		if err := s.StoreURL(ctx, &cache.URLTracker{ID: "c-1", URL: "https://example.com", Status: internal.StatusPending}); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+
+		first, _ := s.GetURL(ctx, "c-1")
+		second, _ := s.GetURL(ctx, "c-1")
+
+		first.Status = internal.StatusProcessing
+		if err := s.UpdateURL(ctx, first); err != nil {
+			t.Fatalf("%s: UpdateURL() error = %v", name, err)
+		}
+		if first.Revision != 2 {
+			t.Errorf("%s: UpdateURL() revision = %d, want 2", name, first.Revision)
+		}
+
+		second.Status = internal.StatusFailed
+		if err := s.UpdateURL(ctx, second); !errors.Is(err, cache.ErrConflict) {
+			t.Errorf("%s: UpdateURL() stale error = %v, want %v", name, err, cache.ErrConflict)
+		}
+
+		got, err := s.GetURL(ctx, "c-1")
+		if err != nil {
+			t.Fatalf("%s: GetURL() error = %v", name, err)
+		}
+		if got.Status != internal.StatusProcessing || got.Revision != 2 {
+			t.Errorf("%s: GetURL() = %+v, want the first write only", name, got)
+		}
+	}
+}
+
+func TestStoreListPagination(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+		base := time.Now()
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			tracker := &cache.URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending, CreatedAt: base}
+			if err := s.StoreURL(ctx, tracker); err != nil {
+				t.Fatalf("%s: StoreURL() error = %v", name, err)
+			}
+		}
+
+		var ids []string
+		cursor := ""
+		for {
+			page, err := s.ListURLs(ctx, cache.ListOptions{Order: cache.OrderDesc, Cursor: cursor, Limit: 2})
+			if err != nil {
+				t.Fatalf("%s: ListURLs() error = %v", name, err)
+			}
+			for _, tr := range page.Trackers {
+				ids = append(ids, tr.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if got := len(ids); got != 5 || ids[0] != "e" || ids[4] != "a" {
+			t.Errorf("%s: paginated IDs = %v, want [e d c b a]", name, ids)
+		}
+	}
+}
+
+func TestStoreReliableQueue(t *testing.T) {
+	for name, s := range openBackends(t, Config{Consumer: "w1", Lease: time.Millisecond}) {
+		ctx := context.Background()
+		for _, id := range []string{"q-1", "q-2"} {
+			tracker := &cache.URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending}
+			if err := s.StoreURL(ctx, tracker); err != nil {
+				t.Fatalf("%s: StoreURL() error = %v", name, err)
+			}
+		}
+
+		first, err := s.DequeueURL(ctx)
+		if err != nil || first == nil || first.ID != "q-1" {
+			t.Fatalf("%s: DequeueURL() = %v, %v, want q-1", name, first, err)
+		}
+		if err := s.AckURL(ctx, first.ID); err != nil {
+			t.Fatalf("%s: AckURL() error = %v", name, err)
+		}
+
+		second, err := s.DequeueURL(ctx)
+		if err != nil || second == nil || second.ID != "q-2" {
+			t.Fatalf("%s: DequeueURL() = %v, %v, want q-2", name, second, err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		requeued, err := s.RequeueExpired(ctx)
+		if err != nil {
+			t.Fatalf("%s: RequeueExpired() error = %v", name, err)
+		}
+		if requeued != 1 {
+			t.Errorf("%s: RequeueExpired() = %d, want 1", name, requeued)
+		}
+
+		again, err := s.DequeueURL(ctx)
+		if err != nil || again == nil || again.ID != "q-2" {
+			t.Errorf("%s: DequeueURL() after requeue = %v, %v, want q-2", name, again, err)
+		}
+
+		empty, err := s.DequeueURL(ctx)
+		if err != nil || empty != nil {
+			t.Errorf("%s: DequeueURL() on empty queue = %v, %v, want nil, nil", name, empty, err)
+		}
+	}
+}
+
+func TestStoreExtendLease(t *testing.T) {
+	for name, s := range openBackends(t, Config{Consumer: "w1", Lease: 100 * time.Millisecond}) {
+		ctx := context.Background()
+		tracker := &cache.URLTracker{ID: "x-1", URL: "https://example.com", Status: internal.StatusPending}
+		if err := s.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+		if _, err := s.DequeueURL(ctx); err != nil {
+			t.Fatalf("%s: DequeueURL() error = %v", name, err)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if err := s.ExtendLease(ctx, tracker.ID); err != nil {
+			t.Fatalf("%s: ExtendLease() error = %v", name, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+
+		if n, err := s.RequeueExpired(ctx); err != nil || n != 0 {
+			t.Errorf("%s: RequeueExpired() = %d, %v, want the extended lease kept", name, n, err)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if n, err := s.RequeueExpired(ctx); err != nil || n != 1 {
+			t.Errorf("%s: RequeueExpired() = %d, %v, want 1", name, n, err)
+		}
+		if err := s.ExtendLease(ctx, tracker.ID); !errors.Is(err, cache.ErrLeaseLost) {
+			t.Errorf("%s: ExtendLease() after requeue error = %v, want ErrLeaseLost", name, err)
+		}
+	}
+}
+
+func TestStorePriorityLanes(t *testing.T) {
+	cfg := Config{LaneWeights: "interactive=2,normal=1,bulk=1"}
+	for name, s := range openBackends(t, cfg) {
+		ctx := context.Background()
+		trackers := map[string]string{
+			"b-1": internal.PriorityBulk,
+			"b-2": internal.PriorityBulk,
+			"n-1": internal.PriorityNormal,
+			"n-2": "",
+			"i-1": internal.PriorityInteractive,
+		}
+		for _, id := range []string{"b-1", "b-2", "n-1", "n-2", "i-1"} {
+			tracker := &cache.URLTracker{ID: id, URL: "https://example.com", Status: internal.StatusPending, Priority: trackers[id]}
+			if err := s.StoreURL(ctx, tracker); err != nil {
+				t.Fatalf("%s: StoreURL() error = %v", name, err)
+			}
+		}
+
+		var got []string
+		for {
+			tracker, err := s.DequeueURL(ctx)
+			if err != nil {
+				t.Fatalf("%s: DequeueURL() error = %v", name, err)
+			}
+			if tracker == nil {
+				break
+			}
+			got = append(got, tracker.ID)
+		}
+
+		// once a lane runs dry its turns go to the next lane by priority
+		want := "[i-1 n-1 b-1 n-2 b-2]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("%s: dequeued priorities = %v, want %s", name, got, want)
+		}
+	}
+}
+
+func TestStoreDelayedJobs(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+		later := &cache.URLTracker{ID: "later", URL: "https://example.com", Status: internal.StatusPending, NotBefore: time.Now().Add(time.Hour)}
+		if err := s.StoreURL(ctx, later); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+		soon := &cache.URLTracker{ID: "soon", URL: "https://example.com", Status: internal.StatusPending, Priority: internal.PriorityBulk}
+		if err := s.StoreURL(ctx, soon); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+		if err := s.ScheduleURL(ctx, soon.ID, soon.Priority, time.Now().Add(5*time.Millisecond)); err != nil {
+			t.Fatalf("%s: ScheduleURL() error = %v", name, err)
+		}
+
+		// drain the immediate enqueue from StoreURL
+		if got, err := s.DequeueURL(ctx); err != nil || got == nil || got.ID != "soon" {
+			t.Fatalf("%s: DequeueURL() = %v, %v, want soon", name, got, err)
+		}
+		if got, err := s.DequeueURL(ctx); err != nil || got != nil {
+			t.Fatalf("%s: DequeueURL() before due = %v, %v, want nil, nil", name, got, err)
+		}
+
+		stats, err := s.QueueStats(ctx)
+		if err != nil {
+			t.Fatalf("%s: QueueStats() error = %v", name, err)
+		}
+		if stats.Scheduled != 2 {
+			t.Errorf("%s: QueueStats() scheduled = %d, want 2", name, stats.Scheduled)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		promoted, err := s.PromoteDue(ctx)
+		if err != nil {
+			t.Fatalf("%s: PromoteDue() error = %v", name, err)
+		}
+		if promoted != 1 {
+			t.Errorf("%s: PromoteDue() = %d, want 1", name, promoted)
+		}
+
+		stats, err = s.QueueStats(ctx)
+		if err != nil {
+			t.Fatalf("%s: QueueStats() error = %v", name, err)
+		}
+		if stats.Scheduled != 1 || stats.Lanes[internal.PriorityBulk] != 1 {
+			t.Errorf("%s: QueueStats() = %+v, want 1 scheduled and 1 bulk", name, stats)
+		}
+
+		got, err := s.DequeueURL(ctx)
+		if err != nil || got == nil || got.ID != "soon" {
+			t.Errorf("%s: DequeueURL() after promotion = %v, %v, want soon", name, got, err)
+		}
+	}
+}
+
+func TestStoreDeadLetters(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+		base := time.Now()
+		for i, id := range []string{"d-1", "d-2", "d-3"} {
+			d := &cache.DeadLetter{ID: id, Reason: cache.ReasonCrawlFailed, Attempts: i + 1, DeadAt: base.Add(time.Duration(i) * time.Second)}
+			if err := s.AddDeadLetter(ctx, d); err != nil {
+				t.Fatalf("%s: AddDeadLetter() error = %v", name, err)
+			}
+		}
+
+		letters, err := s.ListDeadLetters(ctx)
+		if err != nil {
+			t.Fatalf("%s: ListDeadLetters() error = %v", name, err)
+		}
+		if len(letters) != 3 || letters[0].ID != "d-3" || letters[0].Attempts != 3 {
+			t.Errorf("%s: ListDeadLetters() = %+v, want newest first", name, letters)
+		}
+
+		if err := s.RemoveDeadLetter(ctx, "d-2"); err != nil {
+			t.Fatalf("%s: RemoveDeadLetter() error = %v", name, err)
+		}
+		if err := s.RemoveDeadLetter(ctx, "d-2"); err != cache.ErrNoDeadLetter {
+			t.Errorf("%s: RemoveDeadLetter() twice error = %v, want %v", name, err, cache.ErrNoDeadLetter)
+		}
+		if _, err := s.GetDeadLetter(ctx, "d-2"); err != cache.ErrNoDeadLetter {
+			t.Errorf("%s: GetDeadLetter() error = %v, want %v", name, err, cache.ErrNoDeadLetter)
+		}
+
+		purged, err := s.PurgeDeadLetters(ctx)
+		if err != nil || purged != 2 {
+			t.Errorf("%s: PurgeDeadLetters() = %d, %v, want 2", name, purged, err)
+		}
+		if letters, _ := s.ListDeadLetters(ctx); len(letters) != 0 {
+			t.Errorf("%s: ListDeadLetters() after purge = %+v, want none", name, letters)
+		}
+	}
+}
+
+func TestStoreHostLimits(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+
+		// a slow refill so the bucket stays empty for the test
+		limit := cache.HostLimit{Rate: 0.1, Burst: 2, Concurrency: 2}
+		for _, id := range []string{"h-1", "h-2"} {
+			wait, err := s.AcquireHost(ctx, "example.com", id, limit, time.Minute)
+			if err != nil || wait != 0 {
+				t.Fatalf("%s: AcquireHost(%s) = %v, %v, want 0, nil", name, id, wait, err)
+			}
+		}
+
+		if _, err := s.AcquireHost(ctx, "example.com", "h-3", limit, time.Minute); err != cache.ErrHostBusy {
+			t.Errorf("%s: AcquireHost() over concurrency error = %v, want %v", name, err, cache.ErrHostBusy)
+		}
+
+		if err := s.ReleaseHost(ctx, "example.com", "h-1"); err != nil {
+			t.Fatalf("%s: ReleaseHost() error = %v", name, err)
+		}
+		wait, err := s.AcquireHost(ctx, "example.com", "h-3", limit, time.Minute)
+		if err != nil || wait < 9*time.Second || wait > 10*time.Second {
+			t.Errorf("%s: AcquireHost() with empty bucket = %v, %v, want about 10s", name, wait, err)
+		}
+
+		// other hosts have buckets of their own
+		if wait, err := s.AcquireHost(ctx, "example.org", "h-4", limit, time.Minute); err != nil || wait != 0 {
+			t.Errorf("%s: AcquireHost(example.org) = %v, %v, want 0, nil", name, wait, err)
+		}
+
+		// slots held past their deadline are freed
+		unlimitedRate := cache.HostLimit{Concurrency: 1}
+		if wait, err := s.AcquireHost(ctx, "example.net", "h-5", unlimitedRate, -time.Second); err != nil || wait != 0 {
+			t.Fatalf("%s: AcquireHost(example.net) = %v, %v, want 0, nil", name, wait, err)
+		}
+		if wait, err := s.AcquireHost(ctx, "example.net", "h-6", unlimitedRate, time.Minute); err != nil || wait != 0 {
+			t.Errorf("%s: AcquireHost() after expired slot = %v, %v, want 0, nil", name, wait, err)
+		}
+	}
+}
+
+func TestStoreRobots(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+
+		if _, err := s.GetRobots(ctx, "https://example.com"); err != cache.ErrNoRobots {
+			t.Errorf("%s: GetRobots() before put error = %v, want %v", name, err, cache.ErrNoRobots)
+		}
+
+		f := &cache.RobotsFile{StatusCode: 200, Body: "User-agent: *\nDisallow: /private\n", FetchedAt: time.Now()}
+		if err := s.PutRobots(ctx, "https://example.com", f, time.Hour); err != nil {
+			t.Fatalf("%s: PutRobots() error = %v", name, err)
+		}
+		got, err := s.GetRobots(ctx, "https://example.com")
+		if err != nil {
+			t.Fatalf("%s: GetRobots() error = %v", name, err)
+		}
+		if got.StatusCode != 200 || got.Body != f.Body {
+			t.Errorf("%s: GetRobots() = %+v, want %+v", name, got, f)
+		}
+		if _, err := s.GetRobots(ctx, "http://example.com"); err != cache.ErrNoRobots {
+			t.Errorf("%s: GetRobots() of other origin error = %v, want %v", name, err, cache.ErrNoRobots)
+		}
+	}
+}
+
+func TestStoreLinks(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+
+		if _, err := s.GetLink(ctx, "https://example.com/a"); err != cache.ErrNoLink {
+			t.Errorf("%s: GetLink() before put error = %v, want %v", name, err, cache.ErrNoLink)
+		}
+
+		want := &cache.LinkStatus{StatusCode: 404, CheckedAt: time.Now()}
+		if err := s.PutLink(ctx, "https://example.com/a", want, time.Hour); err != nil {
+			t.Fatalf("%s: PutLink() error = %v", name, err)
+		}
+
+		got, err := s.GetLink(ctx, "https://example.com/a")
+		if err != nil {
+			t.Fatalf("%s: GetLink() error = %v", name, err)
+		}
+		if got.StatusCode != 404 || got.Error != "" {
+			t.Errorf("%s: GetLink() = %+v, want %+v", name, got, want)
+		}
+		if _, err := s.GetLink(ctx, "https://example.com/b"); err != cache.ErrNoLink {
+			t.Errorf("%s: GetLink() of other link error = %v, want %v", name, err, cache.ErrNoLink)
+		}
+	}
+}
+
+func TestStoreBatches(t *testing.T) {
+	for name, s := range openBackends(t, Config{}) {
+		ctx := context.Background()
+
+		for _, id := range []string{"c-2", "c-1", "c-3"} {
+			tracker := &cache.URLTracker{ID: id, URL: "https://example.com/" + id, Status: internal.StatusPending}
+			if err := s.StoreURL(ctx, tracker); err != nil {
+				t.Fatalf("%s: StoreURL() error = %v", name, err)
+			}
+		}
+		if err := s.AddToBatch(ctx, "b-1", "c-2", "c-1", "gone"); err != nil {
+			t.Fatalf("%s: AddToBatch() error = %v", name, err)
+		}
+		if err := s.AddToBatch(ctx, "b-1", "c-1"); err != nil {
+			t.Fatalf("%s: AddToBatch() again error = %v", name, err)
+		}
+
+		trackers, err := s.GetBatch(ctx, "b-1")
+		if err != nil {
+			t.Fatalf("%s: GetBatch() error = %v", name, err)
+		}
+		var ids []string
+		for _, tr := range trackers {
+			ids = append(ids, tr.ID)
+		}
+		if fmt.Sprint(ids) != "[c-1 c-2]" {
+			t.Errorf("%s: GetBatch() = %v, want [c-1 c-2]", name, ids)
+		}
+
+		if trackers, err := s.GetBatch(ctx, "b-2"); err != nil || len(trackers) != 0 {
+			t.Errorf("%s: GetBatch(unknown) = %v, %v, want none", name, trackers, err)
+		}
+	}
+}
+
+func TestStoreBlockingDequeue(t *testing.T) {
+	cfg := Config{Consumer: "w1", Lease: time.Minute, BlockTimeout: 5 * time.Second}
+	for name, s := range openBackends(t, cfg) {
+		ctx := context.Background()
+
+		type dequeued struct {
+			tracker *cache.URLTracker
+			err     error
+			elapsed time.Duration
+		}
+		got := make(chan dequeued, 1)
+		start := time.Now()
+		go func() {
+			tracker, err := s.DequeueURL(ctx)
+			got <- dequeued{tracker, err, time.Since(start)}
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		if err := s.StoreURL(ctx, &cache.URLTracker{ID: "b-1", URL: "https://example.com/b-1", Status: internal.StatusPending}); err != nil {
+			t.Fatalf("%s: StoreURL() error = %v", name, err)
+		}
+
+		d := <-got
+		if d.err != nil || d.tracker == nil || d.tracker.ID != "b-1" {
+			t.Fatalf("%s: DequeueURL() = %+v, %v, want b-1", name, d.tracker, d.err)
+		}
+		if d.elapsed > 2*time.Second {
+			t.Errorf("%s: DequeueURL() took %v to see the new job", name, d.elapsed)
+		}
+
+		// cancelling stops the wait
+		cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		start = time.Now()
+		tracker, err := s.DequeueURL(cctx)
+		cancel()
+		if tracker != nil || err == nil {
+			t.Errorf("%s: DequeueURL() on empty queue = %+v, %v, want context error", name, tracker, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: DequeueURL() took %v to notice cancellation", name, elapsed)
+		}
+	}
+}
+
+func TestOpenInvalidLaneWeights(t *testing.T) {
+	if _, err := Open(Config{Backend: BackendMemory, LaneWeights: "urgent=1"}); err == nil {
+		t.Error("Open() expected error for an unknown lane")
+	}
+}
+
+func TestStoreRuns(t *testing.T) {
+	for name, s := range openBackends(t, Config{RunRetention: 2}) {
+		ctx := context.Background()
+		for _, result := range []string{"one", "two", "three"} {
+			if err := s.AddRun(ctx, "r-1", &cache.AnalysisRun{Status: internal.StatusCompleted, Result: result}); err != nil {
+				t.Fatalf("%s: AddRun() error = %v", name, err)
+			}
+		}
+
+		runs, err := s.GetRuns(ctx, "r-1")
+		if err != nil {
+			t.Fatalf("%s: GetRuns() error = %v", name, err)
+		}
+		if len(runs) != 2 || runs[0].Result != "three" || runs[1].Result != "two" {
+			t.Errorf("%s: GetRuns() = %+v, want [three two]", name, runs)
+		}
+	}
+}
+
+func TestFileStoreSharedBetweenProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.json")
+
+	producer, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	consumer, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	ctx := context.Background()
+	tracker := &cache.URLTracker{ID: "shared-1", URL: "https://example.com", Status: internal.StatusPending}
+	if err := producer.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+
+	got, err := consumer.DequeueURL(ctx)
+	if err != nil {
+		t.Fatalf("DequeueURL() error = %v", err)
+	}
+	if got == nil || got.ID != tracker.ID {
+		t.Fatalf("DequeueURL() = %v, want %q", got, tracker.ID)
+	}
+
+	got, err = producer.DequeueURL(ctx)
+	if err != nil || got != nil {
+		t.Errorf("DequeueURL() from producer = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestFileStoreIdleDequeueDoesNotWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idle.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	ctx := context.Background()
+	tracker := &cache.URLTracker{ID: "idle-1", URL: "https://example.com", Status: internal.StatusPending}
+	if err := s.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	if got, err := s.DequeueURL(ctx); err != nil || got == nil {
+		t.Fatalf("DequeueURL() = %v, %v, want %s", got, err, tracker.ID)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if got, err := s.DequeueURL(ctx); err != nil || got != nil {
+			t.Fatalf("DequeueURL() on empty queue = %v, %v, want nil, nil", got, err)
+		}
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("dequeueing from an empty queue rewrote the data file")
+	}
+}
+
+func TestFileStoreSeesSameSizeWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	ctx := context.Background()
+	tracker := &cache.URLTracker{ID: "same-1", URL: "https://example.com/a", Status: internal.StatusPending}
+	if err := s.StoreURL(ctx, tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process saves a change of the same size within the mtime
+	// resolution
+	data = bytes.Replace(data, []byte("https://example.com/a"), []byte("https://example.com/b"), 1)
+	if err := os.WriteFile(path+".other", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".other", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetURL(ctx, tracker.ID)
+	if err != nil || got.URL != "https://example.com/b" {
+		t.Errorf("GetURL() = %v, %v, want the other process's write", got, err)
+	}
+}
+
+func TestFileStoreKeepsCachesInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := s.StoreURL(ctx, &cache.URLTracker{ID: "t-1", URL: "https://example.com", Status: internal.StatusPending}); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.AcquireHost(ctx, "example.com", "t-1", cache.DefaultHostLimit, time.Minute); err != nil {
+		t.Fatalf("AcquireHost() error = %v", err)
+	}
+	if err := s.PutRobots(ctx, "https://example.com", &cache.RobotsFile{StatusCode: 404}, time.Hour); err != nil {
+		t.Fatalf("PutRobots() error = %v", err)
+	}
+	if err := s.PutLink(ctx, "https://example.com/a", &cache.LinkStatus{StatusCode: 200}, time.Hour); err != nil {
+		t.Fatalf("PutLink() error = %v", err)
+	}
+	if _, err := s.GetLink(ctx, "https://example.com/a"); err != nil {
+		t.Errorf("GetLink() error = %v, want the cached link", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("caches were written to the file: %s", after)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestRedisConfigFromEnv(t *testing.T) {
+	t.Setenv("REDIS_URL", "redis://:pw@redis-a:6379/1?read_timeout=2s")
+	t.Setenv("REDIS_USERNAME", "svc")
+	t.Setenv("REDIS_TLS", "true")
+	t.Setenv("REDIS_POOL_SIZE", "7")
+
+	cfg, err := RedisConfigFromEnv()
+	if err != nil {
+		t.Fatalf("RedisConfigFromEnv() error = %v", err)
+	}
+	if len(cfg.Addrs) != 1 || cfg.Addrs[0] != "redis-a:6379" || cfg.DB != 1 {
+		t.Errorf("RedisConfigFromEnv() addrs = %v db %d", cfg.Addrs, cfg.DB)
+	}
+	if cfg.Username != "svc" || cfg.Password != "pw" || !cfg.TLS || cfg.PoolSize != 7 || cfg.ReadTimeout != 2*time.Second {
+		t.Errorf("RedisConfigFromEnv() = %+v", cfg)
+	}
+
+	t.Setenv("REDIS_URL", "")
+	t.Setenv("REDIS_ADDR", "node-1:6379, node-2:6379")
+	cfg, err = RedisConfigFromEnv()
+	if err != nil {
+		t.Fatalf("RedisConfigFromEnv() error = %v", err)
+	}
+	if len(cfg.Addrs) != 2 || cfg.Addrs[1] != "node-2:6379" {
+		t.Errorf("RedisConfigFromEnv() addrs = %v, want both nodes", cfg.Addrs)
+	}
+
+	t.Setenv("REDIS_DB", "first")
+	if _, err := RedisConfigFromEnv(); err == nil {
+		t.Error("RedisConfigFromEnv() expected error for an invalid REDIS_DB")
+	}
+}
@@ -0,0 +1,106 @@
+package urlnorm
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonicalize reduces a URL to the form used to detect duplicate
+// submissions. Two URLs with the same canonical form are considered the same
+// page:
+//
+//   - user info is dropped
+//   - scheme and host are lowercased and IDN hosts converted to punycode
+//   - default ports are stripped
+//   - dot segments are resolved and a trailing slash is dropped, except for
+//     the root path which is always "/"; escaped slashes (%2F) stay as they
+//     are
+//   - query parameters are sorted by key, keeping their raw form and the
+//     order of repeated keys, and an empty query is removed
+//   - fragments are dropped unless they are "#!" routes
+func Canonicalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.New("URL must be absolute")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.User = nil
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", errors.New("URL has no host")
+	}
+	if !strings.Contains(host, ":") {
+		if host, err = idna.Lookup.ToASCII(host); err != nil {
+			return "", err
+		}
+	} else {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+
+	port := u.Port()
+	if port != "" && port != defaultPorts[u.Scheme] {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	u.Host = host
+
+	// clean the escaped form so %2F is not taken for a separator
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	p = path.Clean(p)
+	if p == "." {
+		p = "/"
+	}
+	if u.Path, err = url.PathUnescape(p); err != nil {
+		return "", err
+	}
+	u.RawPath = p
+
+	u.RawQuery = sortQuery(u.RawQuery)
+	u.ForceQuery = false
+
+	if !strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment = ""
+	}
+	u.RawFragment = ""
+
+	return u.String(), nil
+}
+
+// sortQuery sorts the &-separated pairs of a raw query by key and drops empty
+// ones. Pairs are not decoded, so queries url.ParseQuery rejects, such as
+// ones with ";" separators or invalid escapes, are kept as they are.
+func sortQuery(raw string) string {
+	var pairs []string
+	for _, p := range strings.Split(raw, "&") {
+		if p != "" {
+			pairs = append(pairs, p)
+		}
+	}
+	key := func(p string) string {
+		k, _, _ := strings.Cut(p, "=")
+		return k
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return key(pairs[i]) < key(pairs[j])
+	})
+	return strings.Join(pairs, "&")
+}
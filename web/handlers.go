@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"net/url"
+	"urltracker/internal/cache"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -13,14 +15,32 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) Tracking(w http.ResponseWriter, r *http.Request) {
-	trackers, err := app.Redis.GetAllURLs(r.Context())
+	query := r.URL.Query()
+
+	opts, err := cache.ParseListOptions(query)
+	if err != nil {
+		app.errorLog.Println("Invalid listing options:", err)
+		opts, _ = cache.ParseListOptions(url.Values{})
+	}
+
+	var trackers []*cache.URLTracker
+	var next string
+
+	page, err := app.Store.ListURLs(r.Context(), opts)
 	if err != nil {
 		app.errorLog.Println("Error fetching URLs:", err)
-		trackers = nil
+	} else {
+		trackers = page.Trackers
+		if page.NextCursor != "" {
+			query.Set("cursor", page.NextCursor)
+			next = "/tracking?" + query.Encode()
+		}
 	}
 
 	dataMap := make(map[string]any)
 	dataMap["trackers"] = trackers
+	dataMap["options"] = opts
+	dataMap["next"] = next
 	tData := &templateData{
 		Data: dataMap,
 	}
@@ -31,14 +51,34 @@ func (app *application) Tracking(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) TrackingItem(w http.ResponseWriter, r *http.Request) {
-	tracker, err := app.Redis.GetURL(r.Context(), chi.URLParam(r, "id"))
+	tracker, err := app.Store.GetURL(r.Context(), chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorLog.Println("Error fetching URL:", err)
 		tracker = nil
 	}
 
+	var runs []*cache.AnalysisRun
+	if tracker != nil {
+		runs, err = app.Store.GetRuns(r.Context(), tracker.ID)
+		if err != nil {
+			app.errorLog.Println("Error fetching runs:", err)
+		}
+	}
+
+	var batch *cache.BatchSummary
+	if tracker != nil && tracker.Sitemap != nil {
+		trackers, err := app.Store.GetBatch(r.Context(), tracker.ID)
+		if err != nil {
+			app.errorLog.Println("Error fetching batch:", err)
+		} else {
+			batch = cache.SummarizeBatch(trackers)
+		}
+	}
+
 	dataMap := make(map[string]any)
 	dataMap["tracker"] = tracker
+	dataMap["runs"] = runs
+	dataMap["batch"] = batch
 	tData := &templateData{
 		Data: dataMap,
 	}
@@ -10,35 +10,53 @@ import (
 	"testing"
 	"time"
 	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 
 	"github.com/go-chi/chi/v5"
 )
 
-type mockRedisStore struct {
+type mockStore struct {
+	storage.Store
 	allURLs    []*cache.URLTracker
-	getAllErr  error
+	nextCursor string
+	listErr    error
+	listOpts   cache.ListOptions
 	tracker    *cache.URLTracker
 	getErr     error
-	getAllHits int
+	runs       []*cache.AnalysisRun
+	batch      []*cache.URLTracker
+	listHits   int
 	getHits    int
 }
 
-func (m *mockRedisStore) GetAllURLs(_ context.Context) ([]*cache.URLTracker, error) {
-	m.getAllHits++
-	return m.allURLs, m.getAllErr
+func (m *mockStore) ListURLs(_ context.Context, opts cache.ListOptions) (*cache.URLPage, error) {
+	m.listHits++
+	m.listOpts = opts
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+	return &cache.URLPage{Trackers: m.allURLs, NextCursor: m.nextCursor}, nil
 }
 
-func (m *mockRedisStore) GetURL(_ context.Context, _ string) (*cache.URLTracker, error) {
+func (m *mockStore) GetURL(_ context.Context, _ string) (*cache.URLTracker, error) {
 	m.getHits++
 	return m.tracker, m.getErr
 }
 
-func newTestApplication(redis RedisStore) *application {
+func (m *mockStore) GetRuns(_ context.Context, _ string) ([]*cache.AnalysisRun, error) {
+	return m.runs, nil
+}
+
+func (m *mockStore) GetBatch(_ context.Context, _ string) ([]*cache.URLTracker, error) {
+	return m.batch, nil
+}
+
+func newTestApplication(store storage.Store) *application {
 	return &application{
 		ApiAddr:  "http://localhost:4001",
 		infoLog:  log.New(io.Discard, "", 0),
 		errorLog: log.New(io.Discard, "", 0),
-		Redis:    redis,
+		Store:    store,
 	}
 }
 
@@ -71,8 +89,8 @@ func TestTrackingHandler(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	mockRedis := &mockRedisStore{allURLs: []*cache.URLTracker{tracker}}
-	app := newTestApplication(mockRedis)
+	store := &mockStore{allURLs: []*cache.URLTracker{tracker}}
+	app := newTestApplication(store)
 
 	r := httptest.NewRequest(http.MethodGet, "/tracking", nil)
 	w := httptest.NewRecorder()
@@ -83,8 +101,8 @@ func TestTrackingHandler(t *testing.T) {
 		t.Fatalf("Tracking() status = %d, want %d", w.Code, http.StatusOK)
 	}
 
-	if mockRedis.getAllHits != 1 {
-		t.Fatalf("Tracking() GetAllURLs calls = %d, want 1", mockRedis.getAllHits)
+	if store.listHits != 1 {
+		t.Fatalf("Tracking() ListURLs calls = %d, want 1", store.listHits)
 	}
 
 	body := w.Body.String()
@@ -104,8 +122,8 @@ func TestTrackingItemHandler(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	mockRedis := &mockRedisStore{tracker: tracker}
-	app := newTestApplication(mockRedis)
+	store := &mockStore{tracker: tracker}
+	app := newTestApplication(store)
 
 	router := chi.NewRouter()
 	router.Get("/tracking/{id}", app.TrackingItem)
@@ -123,3 +141,314 @@ func TestTrackingItemHandler(t *testing.T) {
 		t.Errorf("TrackingItem() response missing tracker URL")
 	}
 }
+
+func TestTrackingHandlerPagination(t *testing.T) {
+	store := &mockStore{
+		allURLs:    []*cache.URLTracker{{ID: "1", URL: "https://example.com", Status: "failed"}},
+		nextCursor: "abc",
+	}
+	app := newTestApplication(store)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking?status=failed&sort=updated", nil)
+	w := httptest.NewRecorder()
+
+	app.Tracking(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Tracking() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if store.listOpts.Status != "failed" || store.listOpts.SortBy != cache.SortUpdated {
+		t.Errorf("Tracking() list options = %+v, want status=failed sort=updated", store.listOpts)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "cursor=abc") {
+		t.Errorf("Tracking() response missing next page link")
+	}
+}
+
+func TestTrackingItemHandlerRunHistory(t *testing.T) {
+	tracker := &cache.URLTracker{ID: "abc", URL: "https://example.com", Status: "completed"}
+	store := &mockStore{
+		tracker: tracker,
+		runs: []*cache.AnalysisRun{
+			{StartedAt: time.Now(), Status: "completed", DurationMS: 1200, Result: `{"title":"Second title","internal_links":3}`},
+			{StartedAt: time.Now(), Status: "failed", Error: "connection refused"},
+		},
+	}
+	app := newTestApplication(store)
+
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Analysis History") {
+		t.Errorf("TrackingItem() response missing history section")
+	}
+	if !strings.Contains(body, "Second title") || !strings.Contains(body, "connection refused") {
+		t.Errorf("TrackingItem() response missing run details")
+	}
+}
+
+func TestTrackingItemHandlerRetryAttempts(t *testing.T) {
+	retryAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tracker := &cache.URLTracker{ID: "abc", URL: "https://example.com", Status: "retrying", Attempts: 2, NotBefore: retryAt}
+	store := &mockStore{
+		tracker: tracker,
+		runs: []*cache.AnalysisRun{
+			{StartedAt: time.Now(), Status: "retrying", Attempt: 2, RetryAt: retryAt, Error: "Service Unavailable"},
+			{StartedAt: time.Now(), Status: "retrying", Attempt: 1, Error: "i/o timeout"},
+		},
+	}
+	app := newTestApplication(store)
+
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"Retrying", "Next Attempt", "Jan 02, 2030 03:04:05", "next 03:04:05", "i/o timeout"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerBlockedByRobots(t *testing.T) {
+	tracker := &cache.URLTracker{ID: "abc", URL: "https://example.com/private", Status: "completed", Result: `{"blocked_by_robots":true}`}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	if !strings.Contains(w.Body.String(), "Blocked by robots.txt") {
+		t.Errorf("TrackingItem() response missing robots.txt badge")
+	}
+}
+
+func TestTrackingItemHandlerBrokenLinks(t *testing.T) {
+	result := `{"inaccessible_links":2,"link_counts":{"2xx":3,"4xx":1,"error":1},` +
+		`"broken_links":[{"url":"https://example.com/gone","status_code":404},{"url":"https://down.example","error":"no such host"}]}`
+	tracker := &cache.URLTracker{ID: "abc", URL: "https://example.com", Status: "completed", Result: result}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"2xx 3", "3xx 0", "4xx 1", "error 1", "https://example.com/gone", ">404<", "no such host"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerSiteAudit(t *testing.T) {
+	result := `{"pages":[{"url":"https://example.com/","depth":0,"linked_from":1,"issues":0,"result":{"title":"Home"}},` +
+		`{"url":"https://example.com/old","depth":0,"linked_from":0,"issues":3,"result":{"title":""}},` +
+		`{"url":"https://example.com/gone","depth":1,"linked_from":1,"issues":0,"error":"Not Found"}],` +
+		`"totals":{"pages":3,"failed":1,"broken_links":2,"issues":3},` +
+		`"worst_pages":[{"url":"https://example.com/gone","error":"Not Found"},{"url":"https://example.com/old","issues":3}],` +
+		`"orphans":["https://example.com/old"],"unvisited":7,"stopped_by":"max_pages"}`
+	tracker := &cache.URLTracker{
+		ID:     "abc",
+		URL:    "https://example.com/",
+		Status: "completed",
+		Result: result,
+		Audit:  &cache.SiteAudit{MaxDepth: 2, MaxPages: 3},
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"Site audit", "depth 2, up to 3 pages", "Orphan Pages", "Worst Pages", "3 issues", "Not Found", "Stopped early (max_pages), 7 pages", "2 distinct broken"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerAuditProgress(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:       "abc",
+		URL:      "https://example.com/",
+		Status:   "processing",
+		Audit:    &cache.SiteAudit{MaxDepth: 2, MaxPages: 50},
+		Progress: &cache.Progress{Done: 12, Queued: 30, Limit: 50},
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	if !strings.Contains(w.Body.String(), "12 of at most 50 pages crawled, 30 queued") {
+		t.Errorf("TrackingItem() response missing the progress")
+	}
+}
+
+func TestTrackingItemHandlerSitemap(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:      "abc",
+		URL:     "https://example.com/",
+		Status:  "completed",
+		Result:  `{"sitemaps":["https://example.com/sitemap.xml"],"failed":[{"url":"https://example.com/news.xml","status_code":404,"error":"Not Found"}],"urls":3,"queued":2,"existing":1}`,
+		Sitemap: &cache.SitemapBatch{MaxURLs: 500},
+	}
+	batch := []*cache.URLTracker{
+		{ID: "1", URL: "https://example.com/a", Status: "completed"},
+		{ID: "2", URL: "https://example.com/b", Status: "failed", Error: "server said 500"},
+		{ID: "3", URL: "https://example.com/c", Status: "pending"},
+	}
+	app := newTestApplication(&mockStore{tracker: tracker, batch: batch})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"Sitemap", "up to 500 pages", "3 (2 queued, 1 already tracked)", "Unreadable Sitemaps", "news.xml", "3 pages:", "completed 1", "failed 1", `href="/tracking/2"`, "server said 500"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerAnalyzers(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:        "abc",
+		URL:       "https://example.com/",
+		Status:    "completed",
+		Result:    `{"title":"Example","analyzers":{"document":{"version":"1"},"login":{"version":"1"}}}`,
+		Analyzers: []string{"document", "login"},
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"Analyzers</dt>", "document v1", "login v1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerDoctype(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:     "abc",
+		URL:    "https://example.com/",
+		Status: "completed",
+		Result: `{"html_version":"HTML 4.01 Transitional","analyzers":{"document":{"version":"1","data":{"doctype":` +
+			`{"present":true,"name":"HTML","public_id":"-//W3C//DTD HTML 4.01 Transitional//EN","mode":"quirks","not_first":true}}}}}`,
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"HTML 4.01 Transitional", "Quirks", "Doctype is not the first token", "-//W3C//DTD HTML 4.01 Transitional//EN"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+}
+
+func TestTrackingItemHandlerSEO(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:     "abc",
+		URL:    "https://example.com/",
+		Status: "completed",
+		Result: `{"title":"Home","analyzers":{"seo":{"version":"1","data":{"title":"Home","title_length":4,"description_length":0,` +
+			`"canonical":"https://example.com/","canonical_self":true,"x_robots_tag":["noindex"],"noindex":true,"nofollow":false,` +
+			`"hreflang":[{"lang":"de","url":"https://example.com/de/"}],` +
+			`"warnings":[{"code":"title_too_short","message":"The title is 4 characters long, aim for at least 30"}]}}}}`,
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"SEO:", "noindex", "X-Robots-Tag", "https://example.com/de/", "The title is 4 characters long", "title_too_short"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+	if strings.Contains(body, "No SEO warnings") {
+		t.Error("TrackingItem() reports no SEO warnings despite a warning")
+	}
+}
+
+func TestTrackingItemHandlerAccessibility(t *testing.T) {
+	tracker := &cache.URLTracker{
+		ID:     "abc",
+		URL:    "https://example.com/",
+		Status: "completed",
+		Result: `{"title":"Home","analyzers":{"accessibility":{"version":"1","data":{"issues":3,` +
+			`"outline":[{"level":1,"text":"Home"},{"level":3,"text":"News"}],"checks":[` +
+			`{"code":"missing_lang","title":"Document language not set","count":1,"samples":["html"]},` +
+			`{"code":"skipped_heading_level","title":"Skipped heading levels","count":1,"samples":["main#content > h3"]},` +
+			`{"code":"duplicate_id","title":"Duplicate IDs","count":1,"samples":["#note"]},` +
+			`{"code":"empty_link","title":"Links without text","count":0}]}}}}`,
+	}
+	app := newTestApplication(&mockStore{tracker: tracker})
+
+	r := httptest.NewRequest(http.MethodGet, "/tracking/abc", nil)
+	w := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/tracking/{id}", app.TrackingItem)
+	router.ServeHTTP(w, r)
+
+	body := w.Body.String()
+	for _, want := range []string{"Accessibility:", "3 issues", "Skipped heading levels", "main#content &gt; h3", "#note", "Links without text", "h3 News"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TrackingItem() response missing %q", want)
+		}
+	}
+	if strings.Contains(body, "ZgotmplZ") {
+		t.Error("TrackingItem() rendered an unsafe outline indent")
+	}
+}
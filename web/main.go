@@ -1,14 +1,13 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
-	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 )
 
 var serverPort, _ = strconv.Atoi(os.Getenv("SERVER_PORT"))
@@ -17,12 +16,7 @@ type application struct {
 	ApiAddr  string
 	infoLog  *log.Logger
 	errorLog *log.Logger
-	Redis    RedisStore
-}
-
-type RedisStore interface {
-	GetAllURLs(ctx context.Context) ([]*cache.URLTracker, error)
-	GetURL(ctx context.Context, id string) (*cache.URLTracker, error)
+	Store    storage.Store
 }
 
 func (app *application) serve() error {
@@ -44,26 +38,31 @@ func main() {
 	inforLog := log.New(os.Stdout, "[INFO] ", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "redis:6379"
-	}
-
 	apiAddr := os.Getenv("API_ADDR")
 	if apiAddr == "" {
 		apiAddr = "http://localhost:4001"
 	}
-	redisClient := cache.NewRedisClient(redisAddr)
-	defer redisClient.Close()
+
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	inforLog.Println("Using storage backend:", cfg.Describe())
 
 	app := &application{
 		ApiAddr:  apiAddr,
 		infoLog:  inforLog,
 		errorLog: errorLog,
-		Redis:    redisClient,
+		Store:    store,
 	}
 
-	err := app.serve()
+	err = app.serve()
 	if err != nil {
 		app.errorLog.Println(err)
 		log.Fatal(err)
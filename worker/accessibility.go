@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"urltracker/internal"
+
+	"github.com/gocolly/colly/v2"
+	"golang.org/x/net/html"
+)
+
+// maxAccessibilitySamples caps the selectors recorded per check.
+const maxAccessibilitySamples = 5
+
+// Codes of AccessibilityReport.Checks, in the order they are reported.
+const (
+	A11yMissingLang    = "missing_lang"
+	A11yMissingAlt     = "missing_alt"
+	A11yUnlabeledInput = "unlabeled_input"
+	A11ySkippedHeading = "skipped_heading_level"
+	A11yEmptyLink      = "empty_link"
+	A11yEmptyButton    = "empty_button"
+	A11yDuplicateID    = "duplicate_id"
+)
+
+// cssIdent matches IDs that can be written as #id without escaping.
+var cssIdent = regexp.MustCompile(`^-?[A-Za-z_][\w-]*$`)
+
+// AccessibilityReport is the output of the accessibility analyzer.
+type AccessibilityReport struct {
+	Lang string `json:"lang,omitempty"`
+	// Outline lists the page's headings in document order.
+	Outline []Heading `json:"outline,omitempty"`
+	// Issues is the sum of the checks' counts.
+	Issues int                  `json:"issues"`
+	Checks []AccessibilityCheck `json:"checks"`
+}
+
+// Heading is an entry of the heading outline.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// AccessibilityCheck counts the elements failing a check and gives CSS
+// selectors for the first few of them.
+type AccessibilityCheck struct {
+	Code    string   `json:"code"`
+	Title   string   `json:"title"`
+	Count   int      `json:"count"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+// accessibilityAnalyzer checks a page for common WCAG failures that can be
+// found in the markup alone.
+type accessibilityAnalyzer struct{}
+
+func (accessibilityAnalyzer) Name() string    { return internal.AnalyzerAccessibility }
+func (accessibilityAnalyzer) Version() string { return "1" }
+
+func (accessibilityAnalyzer) Attach(c *colly.Collector, _ *url.URL) ContributeFunc {
+	var doc *html.Node
+
+	c.OnHTML("html", func(e *colly.HTMLElement) {
+		if n, err := html.Parse(bytes.NewReader(e.Response.Body)); err == nil {
+			doc = n
+		}
+	})
+
+	return func(_ context.Context, _ *AnalysisResult) (any, error) {
+		if doc == nil {
+			// not an HTML page
+			return nil, nil
+		}
+		return auditAccessibility(doc), nil
+	}
+}
+
+// auditAccessibility runs the checks on the parsed document doc.
+func auditAccessibility(doc *html.Node) *AccessibilityReport {
+	var root *html.Node
+	var imgs, fields, links, buttons []*html.Node
+	var headings []*html.Node
+	var idOrder []string
+	ids := make(map[string]int)
+	labelled := make(map[string]bool)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if id, ok := attr(n, "id"); ok && id != "" {
+				if ids[id] == 0 {
+					idOrder = append(idOrder, id)
+				}
+				ids[id]++
+			}
+
+			switch n.Data {
+			case "html":
+				if root == nil {
+					root = n
+				}
+			case "img":
+				imgs = append(imgs, n)
+			case "input", "select", "textarea":
+				fields = append(fields, n)
+			case "label":
+				if id, ok := attr(n, "for"); ok {
+					labelled[id] = true
+				}
+			case "a":
+				if _, ok := attr(n, "href"); ok {
+					links = append(links, n)
+				}
+			case "button":
+				buttons = append(buttons, n)
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				headings = append(headings, n)
+			}
+			if role, _ := attr(n, "role"); role == "button" && n.Data != "button" && n.Data != "a" {
+				buttons = append(buttons, n)
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	report := &AccessibilityReport{Checks: []AccessibilityCheck{
+		{Code: A11yMissingLang, Title: "Document language not set"},
+		{Code: A11yMissingAlt, Title: "Images without alt text"},
+		{Code: A11yUnlabeledInput, Title: "Form fields without a label"},
+		{Code: A11ySkippedHeading, Title: "Skipped heading levels"},
+		{Code: A11yEmptyLink, Title: "Links without text"},
+		{Code: A11yEmptyButton, Title: "Buttons without text"},
+		{Code: A11yDuplicateID, Title: "Duplicate IDs"},
+	}}
+	checks := make(map[string]*AccessibilityCheck)
+	for i := range report.Checks {
+		checks[report.Checks[i].Code] = &report.Checks[i]
+	}
+	fail := func(code, sample string) {
+		c := checks[code]
+		c.Count++
+		if len(c.Samples) < maxAccessibilitySamples {
+			c.Samples = append(c.Samples, sample)
+		}
+	}
+	selector := func(n *html.Node) string {
+		return cssSelector(n, ids)
+	}
+
+	if root != nil {
+		report.Lang, _ = attr(root, "lang")
+		if report.Lang == "" {
+			report.Lang, _ = attr(root, "xml:lang")
+		}
+		report.Lang = strings.TrimSpace(report.Lang)
+	}
+	if report.Lang == "" {
+		fail(A11yMissingLang, "html")
+	}
+
+	for _, n := range imgs {
+		if _, ok := attr(n, "alt"); !ok && !hidden(n) && !ariaLabelled(n) {
+			fail(A11yMissingAlt, selector(n))
+		}
+	}
+
+	for _, n := range fields {
+		if typ, _ := attr(n, "type"); n.Data == "input" && slices.Contains([]string{"hidden", "submit", "reset", "button", "image"}, strings.ToLower(typ)) {
+			continue
+		}
+		if ariaLabelled(n) || hasAttr(n, "title") || inLabel(n) {
+			continue
+		}
+		if id, _ := attr(n, "id"); id != "" && labelled[id] {
+			continue
+		}
+		fail(A11yUnlabeledInput, selector(n))
+	}
+
+	prev := 0
+	for _, n := range headings {
+		level := int(n.Data[1] - '0')
+		report.Outline = append(report.Outline, Heading{Level: level, Text: collapseSpace(textContent(n))})
+		if prev > 0 && level > prev+1 {
+			fail(A11ySkippedHeading, selector(n))
+		}
+		prev = level
+	}
+
+	for _, n := range links {
+		if !hidden(n) && !hasAccessibleName(n) {
+			fail(A11yEmptyLink, selector(n))
+		}
+	}
+	for _, n := range buttons {
+		if !hidden(n) && !hasAccessibleName(n) {
+			fail(A11yEmptyButton, selector(n))
+		}
+	}
+
+	for _, id := range idOrder {
+		if ids[id] > 1 {
+			fail(A11yDuplicateID, idSelector(id))
+		}
+	}
+
+	for _, c := range report.Checks {
+		report.Issues += c.Count
+	}
+	return report
+}
+
+// hasAccessibleName reports whether a link or button has text, an ARIA
+// label, a title or an image with alt text that screen readers can announce.
+func hasAccessibleName(n *html.Node) bool {
+	if ariaLabelled(n) || hasAttr(n, "title") || strings.TrimSpace(textContent(n)) != "" {
+		return true
+	}
+	var found bool
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil && !found; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if alt, _ := attr(child, "alt"); child.Data == "img" && strings.TrimSpace(alt) != "" {
+				found = true
+				return
+			}
+			if ariaLabelled(child) && !hidden(child) {
+				found = true
+				return
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+	return found
+}
+
+// ariaLabelled reports whether n is named by aria-label or aria-labelledby.
+func ariaLabelled(n *html.Node) bool {
+	return hasAttr(n, "aria-label") || hasAttr(n, "aria-labelledby")
+}
+
+// hidden reports whether n is hidden from assistive technology or marked as
+// decoration.
+func hidden(n *html.Node) bool {
+	if v, _ := attr(n, "aria-hidden"); v == "true" {
+		return true
+	}
+	role, _ := attr(n, "role")
+	return role == "presentation" || role == "none"
+}
+
+// inLabel reports whether n sits inside a label element.
+func inLabel(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "label" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAttr reports whether n has the attribute key with a non-blank value.
+func hasAttr(n *html.Node, key string) bool {
+	v, _ := attr(n, key)
+	return strings.TrimSpace(v) != ""
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// cssSelector returns a selector that finds n in its document, anchored at
+// the nearest element with a unique ID or at body. ids counts the IDs of the
+// document.
+func cssSelector(n *html.Node, ids map[string]int) string {
+	var parts []string
+	for ; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		if id, _ := attr(n, "id"); ids[id] == 1 && cssIdent.MatchString(id) {
+			parts = append(parts, n.Data+"#"+id)
+			break
+		}
+		if n.Data == "html" || n.Data == "body" {
+			parts = append(parts, n.Data)
+			break
+		}
+
+		part := n.Data
+		index, count := 0, 0
+		for s := n.Parent.FirstChild; s != nil; s = s.NextSibling {
+			if s.Type == html.ElementNode && s.Data == n.Data {
+				count++
+				if s == n {
+					index = count
+				}
+			}
+		}
+		if count > 1 {
+			part += fmt.Sprintf(":nth-of-type(%d)", index)
+		}
+		parts = append(parts, part)
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, " > ")
+}
+
+// idSelector returns a selector for elements with the given ID.
+func idSelector(id string) string {
+	if cssIdent.MatchString(id) {
+		return "#" + id
+	}
+	return fmt.Sprintf("[id=%q]", id)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"urltracker/internal"
+
+	"golang.org/x/net/html"
+)
+
+func auditMarkup(t *testing.T, page string) *AccessibilityReport {
+	t.Helper()
+
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auditAccessibility(doc)
+}
+
+func findCheck(report *AccessibilityReport, code string) AccessibilityCheck {
+	for _, c := range report.Checks {
+		if c.Code == code {
+			return c
+		}
+	}
+	return AccessibilityCheck{}
+}
+
+func TestAuditAccessibility(t *testing.T) {
+	report := auditMarkup(t, `<!DOCTYPE html><html><body>
+		<main id="content">
+			<h1>Shop</h1>
+			<h3>Offers</h3>
+			<img src="a.png"><img src="b.png" alt=""><img src="c.png" role="presentation">
+			<form>
+				<input name="q">
+				<label for="email">Email</label><input id="email" name="email">
+				<label>Name <input name="name"></label>
+				<input type="hidden" name="token"><input type="submit">
+				<textarea aria-label="Message"></textarea>
+				<select name="size"></select>
+			</form>
+			<a href="/cart"></a>
+			<a href="/home"><img src="home.png" alt="Home"></a>
+			<a href="/skip" aria-hidden="true"></a>
+			<button> </button><button aria-label="Close">×</button>
+			<div role="button"></div>
+		</main>
+		<p id="note">1</p><p id="note">2</p><span id="x">3</span>
+		<h2>Footer</h2><h4>Legal</h4>
+	</body></html>`)
+
+	tests := []struct {
+		code    string
+		count   int
+		samples []string
+	}{
+		{A11yMissingLang, 1, []string{"html"}},
+		{A11yMissingAlt, 1, []string{"main#content > img:nth-of-type(1)"}},
+		{A11yUnlabeledInput, 2, []string{"main#content > form > input:nth-of-type(1)", "main#content > form > select"}},
+		{A11ySkippedHeading, 2, []string{"main#content > h3", "body > h4"}},
+		{A11yEmptyLink, 1, []string{"main#content > a:nth-of-type(1)"}},
+		{A11yEmptyButton, 2, []string{"main#content > button:nth-of-type(1)", "main#content > div"}},
+		{A11yDuplicateID, 1, []string{"#note"}},
+	}
+	for _, tt := range tests {
+		c := findCheck(report, tt.code)
+		if c.Count != tt.count || !slices.Equal(c.Samples, tt.samples) {
+			t.Errorf("%s = %d %q, want %d %q", tt.code, c.Count, c.Samples, tt.count, tt.samples)
+		}
+	}
+	if report.Issues != 10 {
+		t.Errorf("Issues = %d, want 10", report.Issues)
+	}
+
+	want := []Heading{{1, "Shop"}, {3, "Offers"}, {2, "Footer"}, {4, "Legal"}}
+	if !slices.Equal(report.Outline, want) {
+		t.Errorf("Outline = %v, want %v", report.Outline, want)
+	}
+}
+
+func TestAuditAccessibilityClean(t *testing.T) {
+	report := auditMarkup(t, `<!DOCTYPE html><html lang="en"><body>
+		<h1>Title</h1><h2>Section</h2><h3>Sub</h3><h2>Next</h2>
+		<img src="a.png" alt="A chart">
+		<label for="q">Search</label><input id="q" type="search">
+		<a href="/">Home</a><button>Go</button>
+	</body></html>`)
+
+	if report.Lang != "en" || report.Issues != 0 {
+		t.Errorf("report = %+v, want lang en and no issues", report)
+	}
+	if len(report.Checks) != 7 {
+		t.Errorf("Checks = %d, want all 7 reported", len(report.Checks))
+	}
+}
+
+func TestAuditAccessibilitySamplesCapped(t *testing.T) {
+	report := auditMarkup(t, `<html lang="de"><body>`+strings.Repeat(`<img src="x.png">`, 8)+`</body></html>`)
+
+	c := findCheck(report, A11yMissingAlt)
+	if c.Count != 8 || len(c.Samples) != maxAccessibilitySamples {
+		t.Errorf("missing alt = %d with %d samples, want 8 with %d", c.Count, len(c.Samples), maxAccessibilitySamples)
+	}
+}
+
+func TestCrawlURLAccessibility(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><img src="a.png"></body></html>`)
+	}))
+	defer srv.Close()
+
+	result, _, err := NewCrawler(crawlerConfig{}).Analyze(context.Background(), srv.URL, []string{internal.AnalyzerAccessibility})
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	report, ok := result.Analyzers[internal.AnalyzerAccessibility].Data.(*AccessibilityReport)
+	if !ok {
+		t.Fatalf("accessibility output = %#v, want a report", result.Analyzers[internal.AnalyzerAccessibility])
+	}
+	if report.Issues != 2 {
+		t.Errorf("Issues = %d, want a missing lang and a missing alt", report.Issues)
+	}
+}
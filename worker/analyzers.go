@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"urltracker/internal"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// builtInAnalyzers returns the analyzers every Crawler starts with, one for
+// each name in internal.Analyzers and in that order. The API accepts the
+// names in that list, so it is the one place to add an analyzer to;
+// TestCrawlerBuiltInAnalyzers fails for a name without an analyzer here.
+func builtInAnalyzers(cr *Crawler) []Analyzer {
+	var analyzers []Analyzer
+	for _, name := range internal.Analyzers {
+		switch name {
+		case internal.AnalyzerDocument:
+			analyzers = append(analyzers, documentAnalyzer{})
+		case internal.AnalyzerLinks:
+			analyzers = append(analyzers, linksAnalyzer{cr: cr})
+		case internal.AnalyzerLogin:
+			analyzers = append(analyzers, loginAnalyzer{})
+		case internal.AnalyzerSEO:
+			analyzers = append(analyzers, seoAnalyzer{})
+		case internal.AnalyzerAccessibility:
+			analyzers = append(analyzers, accessibilityAnalyzer{})
+		}
+	}
+	return analyzers
+}
+
+// The built-in analyzers write their findings to the top-level fields of
+// AnalysisResult, which predate the registry and which the site audit and
+// older readers of results use. Under their name they only record what has no
+// such field, so no finding is stored twice.
+
+// documentAnalyzer reads the doctype, title and heading counts.
+type documentAnalyzer struct{}
+
+// documentOutput is what documentAnalyzer records under its name.
+type documentOutput struct {
+	Doctype Doctype `json:"doctype"`
+}
+
+func (documentAnalyzer) Name() string { return internal.AnalyzerDocument }
+
+func (documentAnalyzer) Version() string { return "1" }
+
+func (documentAnalyzer) Attach(c *colly.Collector, _ *url.URL) ContributeFunc {
+	var title string
+	var doctype *Doctype
+	headings := make(map[string]int)
+
+	c.OnHTML("html", func(e *colly.HTMLElement) {
+		d := parseDoctype(e.Response.Body)
+		doctype = &d
+	})
+
+	c.OnHTML("head > title", func(e *colly.HTMLElement) {
+		title = e.Text
+	})
+
+	for i := 1; i <= 6; i++ {
+		hLevel := fmt.Sprintf("h%d", i)
+		c.OnHTML(hLevel, func(e *colly.HTMLElement) {
+			headings[hLevel]++
+		})
+	}
+
+	return func(_ context.Context, result *AnalysisResult) (any, error) {
+		result.Title = title
+		result.HeadingCounts = headings
+		if doctype == nil {
+			// not an HTML page
+			return nil, nil
+		}
+		result.HTMLVersion = doctype.Label()
+		return &documentOutput{Doctype: *doctype}, nil
+	}
+}
+
+// linksAnalyzer counts internal, external and inaccessible links and, if the
+// crawler checks links, probes them.
+type linksAnalyzer struct {
+	cr *Crawler
+}
+
+func (linksAnalyzer) Name() string { return internal.AnalyzerLinks }
+
+func (linksAnalyzer) Version() string { return "1" }
+
+func (a linksAnalyzer) Attach(c *colly.Collector, page *url.URL) ContributeFunc {
+	var internalLinks, externalLinks, inaccessible int
+	var links []string
+	seen := make(map[string]bool)
+
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		linkURL, ok := resolveLink(page, e.Attr("href"))
+		if !ok {
+			inaccessible++
+			return
+		}
+
+		if linkURL.Host == page.Host {
+			internalLinks++
+		} else {
+			externalLinks++
+		}
+
+		if link, ok := crawlableLink(linkURL); ok && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	})
+
+	return func(ctx context.Context, result *AnalysisResult) (any, error) {
+		result.InternalLinks = internalLinks
+		result.ExternalLinks = externalLinks
+		result.InaccessibleLinks = inaccessible
+
+		if a.cr.links != nil && len(links) > 0 {
+			checks := a.cr.links.checkAll(ctx, links)
+			if err := ctx.Err(); errors.Is(err, context.Canceled) {
+				// the worker is shutting down, the page is crawled again
+				return nil, &crawlError{Err: err}
+			}
+			result.Links = checks
+			result.LinkCounts, result.BrokenLinks = summarizeLinks(checks)
+			result.InaccessibleLinks += len(result.BrokenLinks)
+		}
+		return nil, nil
+	}
+}
+
+// loginAnalyzer detects login forms.
+type loginAnalyzer struct{}
+
+func (loginAnalyzer) Name() string { return internal.AnalyzerLogin }
+
+func (loginAnalyzer) Version() string { return "1" }
+
+func (loginAnalyzer) Attach(c *colly.Collector, _ *url.URL) ContributeFunc {
+	var found bool
+
+	c.OnHTML("form", func(e *colly.HTMLElement) {
+		e.ForEach("input[type=password], input[name*=pass], input[name*=login]", func(_ int, _ *colly.HTMLElement) {
+			found = true
+		})
+	})
+
+	return func(_ context.Context, result *AnalysisResult) (any, error) {
+		result.HasLoginForm = found
+		return nil, nil
+	}
+}
+
+// resolveLink resolves href against page. It reports false for empty and
+// unparsable hrefs.
+func resolveLink(page *url.URL, href string) (*url.URL, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || href == "#" {
+		return nil, false
+	}
+
+	linkURL, err := url.Parse(href)
+	if err != nil {
+		return nil, false
+	}
+	if !linkURL.IsAbs() {
+		linkURL = page.ResolveReference(linkURL)
+	}
+	return linkURL, true
+}
+
+// crawlableLink returns linkURL without its fragment if it is an http(s)
+// URL.
+func crawlableLink(linkURL *url.URL) (string, bool) {
+	if linkURL.Scheme != "http" && linkURL.Scheme != "https" {
+		return "", false
+	}
+	u := *linkURL
+	u.Fragment = ""
+	return u.String(), true
+}
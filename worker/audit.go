@@ -0,0 +1,338 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+	"urltracker/internal/urlnorm"
+)
+
+// Reasons a site audit stopped before it ran out of pages within its depth.
+const (
+	AuditStoppedPages   = "max_pages"
+	AuditStoppedTimeout = "timeout"
+)
+
+// maxWorstPages is how many pages SiteReport.WorstPages lists at most.
+const maxWorstPages = 5
+
+// PageFunc analyses the page at rawURL with the named analyzers and returns
+// the links found on it.
+type PageFunc func(ctx context.Context, rawURL string, analyzers []string) (*AnalysisResult, []string, error)
+
+// SiteReport is the result of a site audit.
+type SiteReport struct {
+	Pages      []PageReport `json:"pages"`
+	Totals     SiteTotals   `json:"totals"`
+	WorstPages []PageReport `json:"worst_pages,omitempty"`
+	// Orphans are pages listed in the submission that none of the crawled
+	// pages link to.
+	Orphans []string `json:"orphans,omitempty"`
+	// Unvisited counts pages that were found but not crawled because the
+	// audit stopped early, see StoppedBy.
+	Unvisited int    `json:"unvisited,omitempty"`
+	StoppedBy string `json:"stopped_by,omitempty"`
+}
+
+// PageReport is one page of a site audit. Depth is how many clicks away from
+// the submitted URL the page was found and LinkedFrom how many other crawled
+// pages link to it.
+type PageReport struct {
+	URL        string          `json:"url"`
+	Depth      int             `json:"depth"`
+	LinkedFrom int             `json:"linked_from"`
+	Issues     int             `json:"issues"`
+	Result     *AnalysisResult `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// SiteTotals adds up the pages of a site audit. BrokenLinks counts distinct
+// broken links, the other link counts are summed over the pages.
+type SiteTotals struct {
+	Pages             int `json:"pages"`
+	Failed            int `json:"failed"`
+	BlockedByRobots   int `json:"blocked_by_robots"`
+	InternalLinks     int `json:"internal_links"`
+	ExternalLinks     int `json:"external_links"`
+	InaccessibleLinks int `json:"inaccessible_links"`
+	BrokenLinks       int `json:"broken_links"`
+	MissingTitles     int `json:"missing_titles"`
+	LoginForms        int `json:"login_forms"`
+	Issues            int `json:"issues"`
+}
+
+// pageIssues counts what is wrong with a page: each inaccessible link, a
+// missing title and not having exactly one h1. Analyzers that did not run
+// add nothing.
+func pageIssues(r *AnalysisResult) int {
+	if !fetched(r) {
+		return 0
+	}
+	n := r.InaccessibleLinks
+	if r.Ran(internal.AnalyzerDocument) {
+		if strings.TrimSpace(r.Title) == "" {
+			n++
+		}
+		if r.HeadingCounts["h1"] != 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// fetched reports whether r is the analysis of a page rather than a stand-in
+// for a page that robots.txt kept the crawler from.
+func fetched(r *AnalysisResult) bool {
+	return r != nil && r.HeadingCounts != nil
+}
+
+// siteAuditor crawls the pages of a site breadth first, one at a time, under
+// the same host limits and robots.txt policy as single pages.
+type siteAuditor struct {
+	analyze PageFunc
+	store   storage.Store
+	limits  cache.HostLimits
+	robots  *robotsChecker
+	logger  *log.Logger
+}
+
+// auditPage is a page waiting to be crawled.
+type auditPage struct {
+	url   string
+	key   string
+	depth int
+}
+
+// crawl is the CrawlerFunc of site audits. It fails only if the submitted
+// page cannot be analysed; failures of other pages end up in the report.
+// When ctx runs out it reports the pages crawled so far.
+func (a *siteAuditor) crawl(ctx context.Context, t *cache.URLTracker) (string, error) {
+	audit := t.Audit
+	if audit == nil {
+		audit, _ = cache.NewSiteAudit(0, 0, nil)
+	}
+	root, err := url.Parse(t.URL)
+	if err != nil || root.Host == "" {
+		return "", fmt.Errorf("invalid URL %q", t.URL)
+	}
+
+	var queue []auditPage
+	seen := make(map[string]bool)
+	enqueue := func(rawURL string, depth int) {
+		key, ok := siteKey(root, rawURL)
+		if ok && !seen[key] {
+			seen[key] = true
+			queue = append(queue, auditPage{url: rawURL, key: key, depth: depth})
+		}
+	}
+	enqueue(t.URL, 0)
+	if len(queue) == 0 {
+		return "", fmt.Errorf("invalid URL %q", t.URL)
+	}
+	rootKey := queue[0].key
+	listed := make(map[string]bool)
+	for _, p := range audit.Pages {
+		enqueue(p, 0)
+		if key, ok := siteKey(root, p); ok {
+			listed[key] = true
+		}
+	}
+
+	report := &SiteReport{}
+	var keys []string
+	inbound := make(map[string]int)
+	for len(queue) > 0 {
+		if len(report.Pages) >= audit.MaxPages {
+			report.StoppedBy = AuditStoppedPages
+			break
+		}
+		a.progress(ctx, t, len(report.Pages), len(queue), audit.MaxPages)
+
+		p := queue[0]
+		page, links, err := a.visit(ctx, t, p, len(report.Pages) == 0)
+		if err != nil {
+			if len(report.Pages) == 0 || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", err
+			}
+			report.StoppedBy = AuditStoppedTimeout
+			break
+		}
+		queue = queue[1:]
+		report.Pages = append(report.Pages, *page)
+		keys = append(keys, p.key)
+
+		for _, link := range links {
+			key, ok := siteKey(root, link)
+			if !ok || key == p.key {
+				continue
+			}
+			inbound[key]++
+			if p.depth < audit.MaxDepth {
+				enqueue(link, p.depth+1)
+			}
+		}
+	}
+	report.Unvisited = len(queue)
+	a.progress(ctx, t, len(report.Pages), len(queue), audit.MaxPages)
+
+	for i := range report.Pages {
+		report.Pages[i].LinkedFrom = inbound[keys[i]]
+		if keys[i] != rootKey && listed[keys[i]] && inbound[keys[i]] == 0 {
+			report.Orphans = append(report.Orphans, report.Pages[i].URL)
+		}
+	}
+	summarizeSite(report)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %w", err)
+	}
+	return string(data), nil
+}
+
+// visit analyses one page of the audit. It returns an error only if the page
+// could not be analysed and it is the first one, or ctx is done; other
+// failures are recorded on the page.
+func (a *siteAuditor) visit(ctx context.Context, t *cache.URLTracker, p auditPage, first bool) (*PageReport, []string, error) {
+	page := &PageReport{URL: p.url, Depth: p.depth}
+
+	verdict, err := a.robots.check(ctx, p.url)
+	if err != nil {
+		a.logger.Println("robots.txt:", err)
+	}
+	obey := a.robots != nil && a.robots.policy == RobotsObey
+	if obey && !verdict.allowed {
+		page.Result = &AnalysisResult{BlockedByRobots: true}
+		return page, nil, nil
+	}
+
+	// the job already waited for the host before the first page
+	if !first {
+		limit := a.limits.For(hostOf(p.url))
+		if obey {
+			limit = politeLimit(limit, verdict.crawlDelay)
+		}
+		if err := a.throttle(ctx, hostOf(p.url), t.ID, limit); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	result, links, err := a.analyze(ctx, p.url, t.Analyzers)
+	if err != nil {
+		if first || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		page.Error = err.Error()
+		return page, nil, nil
+	}
+	if !verdict.allowed {
+		result.BlockedByRobots = true
+	}
+	page.Result = result
+	page.Issues = pageIssues(result)
+	return page, links, nil
+}
+
+// throttle waits until job id may make another request to host. Errors of
+// the store are logged and ignored.
+func (a *siteAuditor) throttle(ctx context.Context, host, id string, limit cache.HostLimit) error {
+	err := waitForHost(ctx, a.store, host, id, limit)
+	if err != nil && ctx.Err() == nil {
+		a.logger.Println("host limit:", err)
+		return nil
+	}
+	return err
+}
+
+// progress records on the tracker how far the audit got.
+func (a *siteAuditor) progress(ctx context.Context, t *cache.URLTracker, done, queued, limit int) {
+	p := &cache.Progress{Done: done, Queued: min(queued, limit-done), Limit: limit}
+	fresh, err := updateTracker(context.WithoutCancel(ctx), a.store, t, t.Status, func(t *cache.URLTracker) {
+		t.Progress = p
+	})
+	if err != nil {
+		a.logger.Println("audit progress of", t.ID, ":", err)
+		return
+	}
+	*t = *fresh
+}
+
+// siteKey returns the canonical form of rawURL if it is on the same host as
+// root.
+func siteKey(root *url.URL, rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.EqualFold(u.Host, root.Host) {
+		return "", false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	key, err := urlnorm.Canonicalize(rawURL)
+	return key, err == nil
+}
+
+// summarizeSite fills in the totals and worst pages of report.
+func summarizeSite(report *SiteReport) {
+	totals := &report.Totals
+	broken := make(map[string]bool)
+	for _, p := range report.Pages {
+		totals.Pages++
+		totals.Issues += p.Issues
+		if p.Error != "" {
+			totals.Failed++
+			continue
+		}
+		r := p.Result
+		if r.BlockedByRobots {
+			totals.BlockedByRobots++
+		}
+		if !fetched(r) {
+			continue
+		}
+		totals.InternalLinks += r.InternalLinks
+		totals.ExternalLinks += r.ExternalLinks
+		totals.InaccessibleLinks += r.InaccessibleLinks
+		for _, l := range r.BrokenLinks {
+			broken[l.URL] = true
+		}
+		if r.Ran(internal.AnalyzerDocument) && strings.TrimSpace(r.Title) == "" {
+			totals.MissingTitles++
+		}
+		if r.HasLoginForm {
+			totals.LoginForms++
+		}
+	}
+	totals.BrokenLinks = len(broken)
+
+	var worst []PageReport
+	for _, p := range report.Pages {
+		if p.Error != "" || p.Issues > 0 {
+			// keep the report small, the full results are in Pages
+			worst = append(worst, PageReport{URL: p.URL, Depth: p.Depth, LinkedFrom: p.LinkedFrom, Issues: p.Issues, Error: p.Error})
+		}
+	}
+	// failed pages first, then by number of issues
+	slices.SortStableFunc(worst, func(a, b PageReport) int {
+		if (a.Error != "") != (b.Error != "") {
+			if a.Error != "" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.Issues, a.Issues)
+	})
+	if len(worst) > maxWorstPages {
+		worst = worst[:maxWorstPages]
+	}
+	report.WorstPages = worst
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+// testSite serves a small site:
+//
+//	/ -> /a, /b, https://elsewhere.example
+//	/a -> /, /a/deep
+//	/b -> /missing, /a
+//	/a/deep, /lonely: no links
+func testSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pages := map[string]string{
+		"/":       `<title>Home</title>|<h1>Home</h1><a href="/a">a</a><a href="/b#top">b</a><a href="https://elsewhere.example/">out</a>`,
+		"/a":      `<title>A</title>|<h1>A</h1><a href="/">home</a><a href="/a/deep">deep</a>`,
+		"/b":      `<title></title>|<a href="/missing">missing</a><a href="/a">a</a>`,
+		"/a/deep": `<title>Deep</title>|<h1>Deep</h1>`,
+		"/lonely": `<title>Lonely</title>|<h1>Lonely</h1>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		head, body, _ := strings.Cut(body, "|")
+		fmt.Fprintf(w, "<!DOCTYPE html><html><head>%s</head><body>%s</body></html>", head, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestAuditor(store storage.Store, analyze PageFunc) *siteAuditor {
+	return &siteAuditor{
+		analyze: analyze,
+		store:   store,
+		logger:  log.New(io.Discard, "", 0),
+	}
+}
+
+func storeAuditTracker(t *testing.T, store storage.Store, url string, audit *cache.SiteAudit) *cache.URLTracker {
+	t.Helper()
+
+	tracker := &cache.URLTracker{ID: "site", URL: url, Status: internal.StatusPending, Audit: audit}
+	if err := store.StoreURL(context.Background(), tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	tracker.Status = internal.StatusProcessing
+	if err := store.UpdateURL(context.Background(), tracker); err != nil {
+		t.Fatalf("UpdateURL() error = %v", err)
+	}
+	return tracker
+}
+
+func runAudit(t *testing.T, a *siteAuditor, ctx context.Context, tracker *cache.URLTracker) *SiteReport {
+	t.Helper()
+
+	data, err := a.crawl(ctx, tracker)
+	if err != nil {
+		t.Fatalf("crawl() error = %v", err)
+	}
+	var report SiteReport
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		t.Fatalf("crawl() = %s: %v", data, err)
+	}
+	return &report
+}
+
+func TestSiteAudit(t *testing.T) {
+	srv := testSite(t)
+	store := storage.NewMemoryStore()
+	tracker := storeAuditTracker(t, store, srv.URL+"/", &cache.SiteAudit{MaxDepth: 1, MaxPages: 10, Pages: []string{srv.URL + "/lonely"}})
+
+	report := runAudit(t, newTestAuditor(store, NewCrawler(crawlerConfig{}).Analyze), context.Background(), tracker)
+
+	// /a/deep and /missing are two clicks away
+	var urls []string
+	for _, p := range report.Pages {
+		urls = append(urls, p.URL)
+	}
+	want := []string{srv.URL + "/", srv.URL + "/lonely", srv.URL + "/a", srv.URL + "/b"}
+	if fmt.Sprint(urls) != fmt.Sprint(want) {
+		t.Errorf("pages = %v, want %v", urls, want)
+	}
+	if report.StoppedBy != "" || report.Unvisited != 0 {
+		t.Errorf("StoppedBy = %q, Unvisited = %d, want a complete audit", report.StoppedBy, report.Unvisited)
+	}
+
+	if got := report.Pages[2].LinkedFrom; got != 2 {
+		t.Errorf("/a LinkedFrom = %d, want 2", got)
+	}
+	if fmt.Sprint(report.Orphans) != fmt.Sprint([]string{srv.URL + "/lonely"}) {
+		t.Errorf("Orphans = %v, want /lonely", report.Orphans)
+	}
+
+	totals := report.Totals
+	if totals.Pages != 4 || totals.InternalLinks != 6 || totals.ExternalLinks != 1 || totals.MissingTitles != 1 {
+		t.Errorf("Totals = %+v", totals)
+	}
+	if len(report.WorstPages) != 1 || report.WorstPages[0].URL != srv.URL+"/b" || report.WorstPages[0].Issues != 2 {
+		t.Errorf("WorstPages = %+v, want /b with a missing title and h1", report.WorstPages)
+	}
+
+	stored, err := store.GetURL(context.Background(), tracker.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := stored.Progress; p == nil || p.Done != 4 || p.Queued != 0 || p.Limit != 10 {
+		t.Errorf("Progress = %+v, want 4 of 10 done", p)
+	}
+	if stored.Revision != tracker.Revision {
+		t.Errorf("tracker revision %d, stored %d: crawl lost track of its own updates", tracker.Revision, stored.Revision)
+	}
+}
+
+func TestSiteAuditPageBudget(t *testing.T) {
+	srv := testSite(t)
+	store := storage.NewMemoryStore()
+	tracker := storeAuditTracker(t, store, srv.URL+"/", &cache.SiteAudit{MaxDepth: 5, MaxPages: 2})
+
+	report := runAudit(t, newTestAuditor(store, NewCrawler(crawlerConfig{}).Analyze), context.Background(), tracker)
+
+	if len(report.Pages) != 2 || report.StoppedBy != AuditStoppedPages || report.Unvisited != 2 {
+		t.Errorf("report = %d pages, StoppedBy %q, Unvisited %d, want 2, %q, 2", len(report.Pages), report.StoppedBy, report.Unvisited, AuditStoppedPages)
+	}
+}
+
+func TestSiteAuditFailures(t *testing.T) {
+	srv := testSite(t)
+	store := storage.NewMemoryStore()
+	a := newTestAuditor(store, NewCrawler(crawlerConfig{}).Analyze)
+
+	// a missing start page fails the audit, a missing page further in only
+	// shows up in the report
+	tracker := storeAuditTracker(t, store, srv.URL+"/gone", nil)
+	if _, err := a.crawl(context.Background(), tracker); err == nil {
+		t.Error("crawl() of a missing start page succeeded")
+	}
+
+	store = storage.NewMemoryStore()
+	a.store = store
+	tracker = storeAuditTracker(t, store, srv.URL+"/b", &cache.SiteAudit{MaxDepth: 1, MaxPages: 10})
+	report := runAudit(t, a, context.Background(), tracker)
+	if report.Totals.Failed != 1 || report.WorstPages[0].URL != srv.URL+"/missing" || report.WorstPages[0].Error == "" {
+		t.Errorf("report = %+v, want /missing failed and listed first", report)
+	}
+}
+
+func TestSiteAuditTimeout(t *testing.T) {
+	analyze := func(ctx context.Context, rawURL string, _ []string) (*AnalysisResult, []string, error) {
+		if rawURL != "https://example.com/" {
+			<-ctx.Done()
+			return nil, nil, ctx.Err()
+		}
+		result := &AnalysisResult{Title: "Home", HeadingCounts: map[string]int{"h1": 1}}
+		return result, []string{"https://example.com/a", "https://example.com/b"}, nil
+	}
+	store := storage.NewMemoryStore()
+	tracker := storeAuditTracker(t, store, "https://example.com/", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report := runAudit(t, newTestAuditor(store, analyze), ctx, tracker)
+
+	if len(report.Pages) != 1 || report.StoppedBy != AuditStoppedTimeout || report.Unvisited != 2 {
+		t.Errorf("report = %d pages, StoppedBy %q, Unvisited %d, want 1, %q, 2", len(report.Pages), report.StoppedBy, report.Unvisited, AuditStoppedTimeout)
+	}
+
+	// cancellation is not a timeout, the job is to be requeued
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := newTestAuditor(store, analyze).crawl(ctx, tracker); !errors.Is(err, context.Canceled) {
+		t.Errorf("crawl() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestProcessNextSiteAudit(t *testing.T) {
+	analyze := func(ctx context.Context, rawURL string, _ []string) (*AnalysisResult, []string, error) {
+		return &AnalysisResult{Title: rawURL, HeadingCounts: map[string]int{"h1": 1}}, []string{"https://example.com/a"}, nil
+	}
+	store := storage.NewMemoryStore()
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+	for _, tracker := range []*cache.URLTracker{
+		{ID: "page", URL: "https://example.com/", Status: internal.StatusPending},
+		{ID: "site", URL: "https://example.com/", Status: internal.StatusPending, Audit: &cache.SiteAudit{MaxDepth: 1, MaxPages: 5}},
+	} {
+		if err := store.StoreURL(ctx, tracker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crawl := func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return `{"title":"page"}`, nil
+	}
+	opts := jobOptions{audit: newTestAuditor(store, analyze)}
+	for range 2 {
+		if _, err := processNext(ctx, ctx, store, crawl, opts, logger); err != nil {
+			t.Fatalf("processNext() error = %v", err)
+		}
+	}
+
+	page, _ := store.GetURL(ctx, "page")
+	site, _ := store.GetURL(ctx, "site")
+	if page.Status != internal.StatusCompleted || page.Progress != nil || strings.Contains(page.Result, "pages") {
+		t.Errorf("page tracker = %+v, want a single page result", page)
+	}
+	if site.Status != internal.StatusCompleted || site.Progress == nil || site.Progress.Done != 2 || !strings.Contains(site.Result, `"pages":[`) {
+		t.Errorf("site tracker = %+v, want a site report of 2 pages", site)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"urltracker/internal/cache"
 
@@ -12,97 +17,243 @@ import (
 )
 
 type AnalysisResult struct {
-	Title             string         `json:"title"`
-	HTMLVersion       string         `json:"html_version"`
-	HeadingCounts     map[string]int `json:"heading_counts"`
-	InternalLinks     int            `json:"internal_links"`
-	ExternalLinks     int            `json:"external_links"`
-	InaccessibleLinks int            `json:"inaccessible_links"`
-	HasLoginForm      bool           `json:"has_login_form"`
-	Error             string         `json:"error,omitempty"`
+	Title         string         `json:"title"`
+	HTMLVersion   string         `json:"html_version"`
+	HeadingCounts map[string]int `json:"heading_counts"`
+	InternalLinks int            `json:"internal_links"`
+	ExternalLinks int            `json:"external_links"`
+	// InaccessibleLinks counts empty and unparsable hrefs as well as links
+	// whose probe failed or got a 4xx or 5xx response.
+	InaccessibleLinks int    `json:"inaccessible_links"`
+	HasLoginForm      bool   `json:"has_login_form"`
+	Error             string `json:"error,omitempty"`
+	// BlockedByRobots is set when robots.txt disallows the URL. With the
+	// obey policy nothing else is filled in.
+	BlockedByRobots bool `json:"blocked_by_robots,omitempty"`
+	// Links holds the probed status of every distinct http(s) link on the
+	// page, LinkCounts how many fell into each Link class and BrokenLinks
+	// those that are 4xx, 5xx or unreachable.
+	Links       []LinkCheck    `json:"links,omitempty"`
+	LinkCounts  map[string]int `json:"link_counts,omitempty"`
+	BrokenLinks []LinkCheck    `json:"broken_links,omitempty"`
+	// Analyzers holds the version and output of every analyzer that ran,
+	// by name.
+	Analyzers map[string]AnalyzerOutput `json:"analyzers,omitempty"`
 }
 
-func CrawlURL(tracker *cache.URLTracker) (string, error) {
-	c := colly.NewCollector()
+// Ran reports whether the analyzer with the given name ran on the page.
+// Results stored before analyzers were recorded ran all built-in ones.
+func (r *AnalysisResult) Ran(name string) bool {
+	if r.Analyzers == nil {
+		return true
+	}
+	_, ok := r.Analyzers[name]
+	return ok
+}
 
-	result := &AnalysisResult{
-		HeadingCounts: make(map[string]int),
+// Crawls that exceed one of the crawlerConfig timeouts fail with an error
+// wrapping one of these.
+var (
+	ErrConnectTimeout  = errors.New("connect timeout")
+	ErrTLSTimeout      = errors.New("TLS handshake timeout")
+	ErrResponseTimeout = errors.New("response timeout")
+)
+
+// crawlerConfig limits a single crawl. Zero values fall back to the defaults
+// of the crawler package.
+type crawlerConfig struct {
+	ConnectTimeout time.Duration
+	TLSTimeout     time.Duration
+	// Timeout bounds the whole request including reading the body.
+	Timeout time.Duration
+	// MaxBodySize truncates larger responses, in bytes.
+	MaxBodySize int
+}
+
+var defaultCrawlerConfig = crawlerConfig{
+	ConnectTimeout: 10 * time.Second,
+	TLSTimeout:     10 * time.Second,
+	Timeout:        30 * time.Second,
+	MaxBodySize:    10 << 20,
+}
+
+// Crawler analyses pages. It shares one transport, and so its connection
+// pool, between all crawls.
+type Crawler struct {
+	cfg       crawlerConfig
+	transport *http.Transport
+	// links probes the links found on each page. Nil skips the probes.
+	links     *linkChecker
+	analyzers *Registry
+}
+
+func NewCrawler(cfg crawlerConfig) *Crawler {
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultCrawlerConfig.ConnectTimeout
+	}
+	if cfg.TLSTimeout <= 0 {
+		cfg.TLSTimeout = defaultCrawlerConfig.TLSTimeout
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultCrawlerConfig.Timeout
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultCrawlerConfig.MaxBodySize
 	}
 
-	c.OnHTML("html", func(e *colly.HTMLElement) {
-		body := strings.ToLower(string(e.Response.Body))
+	cr := &Crawler{
+		cfg:       cfg,
+		analyzers: &Registry{},
+		transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   cfg.TLSTimeout,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+	cr.analyzers.analyzers = builtInAnalyzers(cr)
+	return cr
+}
 
-		switch {
-		case strings.Contains(body, "<!doctype html>"):
-			result.HTMLVersion = "HTML5"
-		case strings.Contains(body, "HTML 4.01"):
-			result.HTMLVersion = "HTML 4.01"
-		default:
-			result.HTMLVersion = "Unknown"
-		}
-	})
+// Register adds an analyzer the crawler can run. Unless a submission selects
+// otherwise, it runs on every page.
+func (cr *Crawler) Register(a Analyzer) error {
+	return cr.analyzers.Register(a)
+}
 
-	c.OnHTML("head > title", func(e *colly.HTMLElement) {
-		result.Title = e.Text
-	})
+// Client returns an HTTP client with the crawler's transport and timeout for
+// requests made outside of a crawl, such as fetching robots.txt.
+func (cr *Crawler) Client() *http.Client {
+	return &http.Client{Transport: cr.transport, Timeout: cr.cfg.Timeout}
+}
 
-	for i := 1; i <= 6; i++ {
-		hLevel := fmt.Sprintf("h%d", i)
-		c.OnHTML(hLevel, func(e *colly.HTMLElement) {
-			result.HeadingCounts[hLevel]++
-		})
+// CheckLinks makes the crawler probe every link it finds with up to
+// concurrency requests at a time, each limited to timeout, and share the
+// results through store for ttl. The probes keep to limits per host and,
+// through robots, to robots.txt.
+func (cr *Crawler) CheckLinks(store linkStore, limits cache.HostLimits, robots *robotsChecker, concurrency int, timeout, ttl time.Duration) {
+	cr.links = &linkChecker{
+		store:       store,
+		hosts:       store,
+		limits:      limits,
+		robots:      robots,
+		client:      newLinkClient(cr.transport),
+		concurrency: concurrency,
+		timeout:     timeout,
+		ttl:         ttl,
 	}
+}
 
-	pageURL, urlErr := url.Parse(tracker.URL)
-	if urlErr != nil {
-		return "", fmt.Errorf("invalid URL: %w", urlErr)
+// CrawlURL analyses the page of tracker with the analyzers it selected. It
+// gives up when ctx is done.
+func (cr *Crawler) CrawlURL(ctx context.Context, tracker *cache.URLTracker) (string, error) {
+	result, _, err := cr.Analyze(ctx, tracker.URL, tracker.Analyzers)
+	if err != nil {
+		return "", err
 	}
 
-	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		href := strings.TrimSpace(e.Attr("href"))
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
 
-		if href == "" || href == "#" {
-			result.InaccessibleLinks++
-			return
-		}
+	return string(data), nil
+}
 
-		linkURL, err := url.Parse(href)
-		if err != nil {
-			result.InaccessibleLinks++
-			return
-		}
+// Analyze fetches the page at rawURL and runs the named analyzers on it, or
+// all registered ones if names is empty. Besides the result it returns the
+// distinct http(s) links found on the page, without fragments.
+func (cr *Crawler) Analyze(ctx context.Context, rawURL string, names []string) (*AnalysisResult, []string, error) {
+	analyzers, err := cr.analyzers.Select(names)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if !linkURL.IsAbs() {
-			linkURL = pageURL.ResolveReference(linkURL)
-		}
+	pageURL, urlErr := url.Parse(rawURL)
+	if urlErr != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %w", urlErr)
+	}
 
-		if linkURL.Host == pageURL.Host {
-			result.InternalLinks++
-		} else {
-			result.ExternalLinks++
+	// robots.txt is checked by the worker before the crawl, see robots.go
+	c := colly.NewCollector(
+		colly.UserAgent(crawlerUserAgent),
+		colly.IgnoreRobotsTxt(),
+		colly.StdlibContext(ctx),
+		colly.MaxBodySize(cr.cfg.MaxBodySize),
+	)
+	c.WithTransport(cr.transport)
+	c.SetRequestTimeout(cr.cfg.Timeout)
+
+	// site audits follow the links whichever analyzers run
+	var links []string
+	seen := make(map[string]bool)
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		linkURL, ok := resolveLink(pageURL, e.Attr("href"))
+		if !ok {
+			return
+		}
+		if link, ok := crawlableLink(linkURL); ok && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
 		}
 	})
 
-	c.OnHTML("form", func(e *colly.HTMLElement) {
-		e.ForEach("input[type=password], input[name*=pass], input[name*=login]", func(_ int, _ *colly.HTMLElement) {
-			result.HasLoginForm = true
-		})
-	})
+	contributions := make([]ContributeFunc, len(analyzers))
+	for i, a := range analyzers {
+		contributions[i] = a.Attach(c, pageURL)
+	}
 
 	var onError error
 	c.OnError(func(e *colly.Response, err error) {
-		onError = fmt.Errorf("Failed to collect data for URL. \nError: %w \n Status Code: %d", err, e.StatusCode)
+		ce := &crawlError{StatusCode: e.StatusCode, Err: cr.timeoutError(ctx, err)}
+		if e.Headers != nil {
+			ce.RetryAfter = parseRetryAfter(e.Headers.Get("Retry-After"), time.Now())
+		}
+		onError = ce
 	})
 
-	c.Visit(tracker.URL)
+	if err := c.Visit(rawURL); err != nil && onError == nil {
+		onError = &crawlError{Err: cr.timeoutError(ctx, err)}
+	}
 	if onError != nil {
-		return "", onError
+		return nil, nil, onError
 	}
 
-	data, err := json.Marshal(result)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal result: %w", err)
+	result := &AnalysisResult{
+		HeadingCounts: make(map[string]int),
+		Analyzers:     make(map[string]AnalyzerOutput, len(analyzers)),
+	}
+	for i, a := range analyzers {
+		data, err := contributions[i](ctx, result)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Analyzers[a.Name()] = AnalyzerOutput{Version: a.Version(), Data: data}
 	}
 
-	return string(data), nil
+	return result, links, nil
+}
+
+// timeoutError tells which of the timeouts err ran into, if any. Errors
+// caused by ctx being cancelled are returned as they are.
+func (cr *Crawler) timeoutError(ctx context.Context, err error) error {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return fmt.Errorf("%w after %s: %w", ErrConnectTimeout, cr.cfg.ConnectTimeout, err)
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		// net/http does not export the error type
+		return fmt.Errorf("%w after %s: %w", ErrTLSTimeout, cr.cfg.TLSTimeout, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: job deadline exceeded: %w", ErrResponseTimeout, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w after %s: %w", ErrResponseTimeout, cr.cfg.Timeout, err)
+	}
+	return err
 }
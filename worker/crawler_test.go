@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+func TestCrawlURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE html><html><head><title>Example</title></head><body><h1>Hi</h1><a href="/about">About</a></body></html>`)
+	}))
+	defer srv.Close()
+
+	result, err := NewCrawler(crawlerConfig{}).CrawlURL(context.Background(), &cache.URLTracker{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("CrawlURL() error = %v", err)
+	}
+	for _, want := range []string{`"title":"Example"`, `"html_version":"HTML5"`, `"internal_links":1`} {
+		if !strings.Contains(result, want) {
+			t.Errorf("CrawlURL() = %s, missing %s", result, want)
+		}
+	}
+
+	// the built-ins record their findings only once, in the top-level fields
+	var parsed struct {
+		Analyzers map[string]AnalyzerOutput `json:"analyzers"`
+	}
+	if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{internal.AnalyzerLinks, internal.AnalyzerLogin} {
+		if out, ok := parsed.Analyzers[name]; !ok || out.Version != "1" || out.Data != nil {
+			t.Errorf("%s output = %+v, want version 1 without data", name, out)
+		}
+	}
+}
+
+func TestCrawlURLResponseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cr := NewCrawler(crawlerConfig{Timeout: 50 * time.Millisecond})
+	_, err := cr.CrawlURL(context.Background(), &cache.URLTracker{URL: srv.URL})
+	if !errors.Is(err, ErrResponseTimeout) {
+		t.Fatalf("CrawlURL() error = %v, want %v", err, ErrResponseTimeout)
+	}
+	if !strings.Contains(err.Error(), "response timeout after 50ms") {
+		t.Errorf("CrawlURL() error = %q, want the limit in the message", err)
+	}
+	if ok, _ := retryable(err); !ok {
+		t.Error("response timeout is not retryable")
+	}
+}
+
+func TestCrawlURLCancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := NewCrawler(crawlerConfig{}).CrawlURL(ctx, &cache.URLTracker{URL: srv.URL})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CrawlURL() error = %v, want %v", err, context.Canceled)
+	}
+	if errors.Is(err, ErrResponseTimeout) {
+		t.Errorf("CrawlURL() error = %v, cancellation reported as a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CrawlURL() took %v after cancellation", elapsed)
+	}
+}
+
+func TestCrawlURLMaxBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head>%s<title>Late</title></head></html>`, strings.Repeat("<!-- padding -->", 100))
+	}))
+	defer srv.Close()
+
+	result, err := NewCrawler(crawlerConfig{MaxBodySize: 256}).CrawlURL(context.Background(), &cache.URLTracker{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("CrawlURL() error = %v", err)
+	}
+	if strings.Contains(result, "Late") {
+		t.Errorf("CrawlURL() = %s, read past MaxBodySize", result)
+	}
+}
+
+func TestCrawlerTimeoutError(t *testing.T) {
+	cr := NewCrawler(crawlerConfig{ConnectTimeout: time.Second, TLSTimeout: 2 * time.Second, Timeout: 3 * time.Second})
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{"dial", context.Background(), &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrConnectTimeout},
+		{"tls", context.Background(), errors.New("net/http: TLS handshake timeout"), ErrTLSTimeout},
+		{"read", context.Background(), &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ErrResponseTimeout},
+		{"job deadline", expired, context.DeadlineExceeded, ErrResponseTimeout},
+		{"refused", context.Background(), errors.New("connection refused"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cr.timeoutError(tt.ctx, tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("timeoutError() = %v, want %v unchanged", got, tt.err)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+				t.Errorf("timeoutError() = %v, want it to wrap %v and %v", got, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestCrawlURLChecksLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body>
+				<a href="/ok">ok</a> <a href="/ok#top">ok again</a>
+				<a href="/gone">gone</a> <a href="#">nowhere</a>
+				<a href="mailto:me@example.com">mail</a>
+				<a href="http://127.0.0.1:1/">down</a>
+			</body></html>`)
+		case "/ok":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cr := NewCrawler(crawlerConfig{})
+	cr.CheckLinks(storage.NewMemoryStore(), cache.HostLimits{}, nil, 2, time.Second, time.Hour)
+	data, err := cr.CrawlURL(context.Background(), &cache.URLTracker{URL: srv.URL + "/"})
+	if err != nil {
+		t.Fatalf("CrawlURL() error = %v", err)
+	}
+
+	var result AnalysisResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Links) != 3 {
+		t.Errorf("Links = %+v, want /ok, /gone and the unreachable host", result.Links)
+	}
+	if result.LinkCounts[Link2xx] != 1 || result.LinkCounts[Link4xx] != 1 || result.LinkCounts[LinkError] != 1 {
+		t.Errorf("LinkCounts = %v", result.LinkCounts)
+	}
+	if len(result.BrokenLinks) != 2 || result.BrokenLinks[0].URL != srv.URL+"/gone" {
+		t.Errorf("BrokenLinks = %+v", result.BrokenLinks)
+	}
+	// "#" plus the two broken links
+	if result.InaccessibleLinks != 3 {
+		t.Errorf("InaccessibleLinks = %d, want 3", result.InaccessibleLinks)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Rendering modes a browser picks from the doctype, see
+// https://html.spec.whatwg.org/multipage/parsing.html#the-initial-insertion-mode
+const (
+	RenderingStandards     = "standards"
+	RenderingLimitedQuirks = "limited-quirks"
+	RenderingQuirks        = "quirks"
+)
+
+// Doctype is the DOCTYPE declaration of a page.
+type Doctype struct {
+	// Present is false if the page has no doctype at all.
+	Present  bool   `json:"present"`
+	Name     string `json:"name,omitempty"`
+	PublicID string `json:"public_id,omitempty"`
+	SystemID string `json:"system_id,omitempty"`
+	// Family is HTML or XHTML, Version the version within it, such as 5
+	// or 4.01, and Variant strict, transitional or frameset for versions
+	// that have them. All three are empty for doctypes that are not
+	// recognized.
+	Family  string `json:"family,omitempty"`
+	Version string `json:"version,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	// Mode is the rendering mode the doctype puts browsers in.
+	Mode string `json:"mode"`
+	// NotFirst is set when comments, text or elements come before the
+	// doctype; an XML declaration may. Browsers ignore a doctype that
+	// follows text or elements, which leaves the page in quirks mode.
+	NotFirst bool `json:"not_first,omitempty"`
+}
+
+// Label describes the HTML version, such as "HTML5", "HTML 4.01 Strict" or
+// "XHTML 1.1", or returns "Unknown".
+func (d Doctype) Label() string {
+	if d.Family == "" {
+		return "Unknown"
+	}
+	if d.Family == "HTML" && d.Version == "5" {
+		return "HTML5"
+	}
+	label := d.Family + " " + d.Version
+	if d.Variant != "" {
+		label += " " + strings.ToUpper(d.Variant[:1]) + d.Variant[1:]
+	}
+	return label
+}
+
+// parseDoctype finds the doctype of the page in body and classifies it.
+func parseDoctype(body []byte) Doctype {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	content, comments := false, false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return Doctype{Mode: RenderingQuirks}
+		case html.CommentToken:
+			// the tokenizer reads an XML declaration as a comment, but it
+			// belongs before the doctype of XHTML pages
+			if !strings.HasPrefix(string(z.Text()), "?xml") {
+				comments = true
+			}
+		case html.TextToken:
+			if strings.Trim(string(z.Text()), " \t\n\r\f\ufeff") != "" {
+				content = true
+			}
+		case html.DoctypeToken:
+			d := classifyDoctype(string(z.Text()))
+			d.NotFirst = content || comments
+			if content {
+				d.Mode = RenderingQuirks
+			}
+			return d
+		default:
+			content = true
+		}
+	}
+}
+
+// doctypeToken splits the contents of a doctype token into its name and its
+// public and system identifiers, each quoted with either kind of quote.
+var doctypeToken = regexp.MustCompile(`(?is)^\s*(\S+)?(?:\s+(?:PUBLIC\s*(?:"([^"]*)"|'([^']*)')(?:\s*(?:"([^"]*)"|'([^']*)'))?|SYSTEM\s*(?:"([^"]*)"|'([^']*)')))?\s*$`)
+
+// w3cDTD matches the public identifiers of the W3C's HTML and XHTML DTDs.
+var w3cDTD = regexp.MustCompile(`(?i)^-//W3C//DTD (XHTML Basic|XHTML|HTML) ([0-9.]+)(?: (Strict|Transitional|Frameset|Final))?//`)
+
+// classifyDoctype classifies the doctype with the given token contents.
+func classifyDoctype(token string) Doctype {
+	d := Doctype{Present: true}
+	m := doctypeToken.FindStringSubmatchIndex(token)
+	if m == nil {
+		// malformed, browsers force quirks mode
+		d.Mode = RenderingQuirks
+		return d
+	}
+	// group returns submatch i and whether it took part in the match, as
+	// an empty identifier is not the same as a missing one
+	group := func(i int) (string, bool) {
+		if m[2*i] < 0 {
+			return "", false
+		}
+		return token[m[2*i]:m[2*i+1]], true
+	}
+	d.Name, _ = group(1)
+	var hasSystem bool
+	for i := 2; i <= 7; i++ {
+		v, ok := group(i)
+		switch {
+		case !ok:
+		case i <= 3:
+			d.PublicID = v
+		default:
+			d.SystemID, hasSystem = v, true
+		}
+	}
+	d.Mode = doctypeMode(d, hasSystem)
+
+	if !strings.EqualFold(d.Name, "html") {
+		return d
+	}
+	if d.PublicID == "" && (d.SystemID == "" || strings.EqualFold(d.SystemID, "about:legacy-compat")) {
+		d.Family, d.Version = "HTML", "5"
+		return d
+	}
+	if strings.HasPrefix(strings.ToLower(d.PublicID), "-//ietf//dtd html 2.0") {
+		d.Family, d.Version = "HTML", "2.0"
+		return d
+	}
+	w := w3cDTD.FindStringSubmatch(d.PublicID)
+	if w == nil {
+		return d
+	}
+	switch strings.ToLower(w[1]) {
+	case "html":
+		d.Family = "HTML"
+	case "xhtml":
+		d.Family = "XHTML"
+	default:
+		d.Family = "XHTML Basic"
+	}
+	d.Version = w[2]
+	switch variant := strings.ToLower(w[3]); {
+	case variant == "strict", variant == "transitional", variant == "frameset":
+		d.Variant = variant
+	case d.Family == "HTML" && strings.HasPrefix(d.Version, "4."), d.Family == "XHTML" && d.Version == "1.0":
+		// the strict DTDs leave the variant out
+		d.Variant = "strict"
+	}
+	return d
+}
+
+// doctypeMode is the rendering mode of d, following the HTML parsing spec.
+// hasSystem tells a missing system identifier from an empty one.
+func doctypeMode(d Doctype, hasSystem bool) string {
+	public := strings.ToLower(d.PublicID)
+	system := strings.ToLower(d.SystemID)
+	switch {
+	case !strings.EqualFold(d.Name, "html"),
+		public == "-//w3o//dtd w3 html strict 3.0//en//",
+		public == "-/w3c/dtd html 4.0 transitional/en",
+		public == "html",
+		system == "http://www.ibm.com/data/dtd/v11/ibmxhtml1-transitional.dtd",
+		hasAnyPrefix(public, quirkyPublicIDs),
+		!hasSystem && hasAnyPrefix(public, html401Loose):
+		return RenderingQuirks
+	case hasAnyPrefix(public, xhtml10Loose),
+		hasSystem && hasAnyPrefix(public, html401Loose):
+		return RenderingLimitedQuirks
+	}
+	return RenderingStandards
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	html401Loose = []string{
+		"-//w3c//dtd html 4.01 frameset//",
+		"-//w3c//dtd html 4.01 transitional//",
+	}
+	xhtml10Loose = []string{
+		"-//w3c//dtd xhtml 1.0 frameset//",
+		"-//w3c//dtd xhtml 1.0 transitional//",
+	}
+	// quirkyPublicIDs are the public identifier prefixes that put browsers
+	// in quirks mode.
+	quirkyPublicIDs = []string{
+		"+//silmaril//dtd html pro v0r11 19970101//",
+		"-//as//dtd html 3.0 aswedit + extensions//",
+		"-//advasoft ltd//dtd html 3.0 aswedit + extensions//",
+		"-//ietf//dtd html 2.0 level 1//",
+		"-//ietf//dtd html 2.0 level 2//",
+		"-//ietf//dtd html 2.0 strict level 1//",
+		"-//ietf//dtd html 2.0 strict level 2//",
+		"-//ietf//dtd html 2.0 strict//",
+		"-//ietf//dtd html 2.0//",
+		"-//ietf//dtd html 2.1e//",
+		"-//ietf//dtd html 3.0//",
+		"-//ietf//dtd html 3.2 final//",
+		"-//ietf//dtd html 3.2//",
+		"-//ietf//dtd html 3//",
+		"-//ietf//dtd html level 0//",
+		"-//ietf//dtd html level 1//",
+		"-//ietf//dtd html level 2//",
+		"-//ietf//dtd html level 3//",
+		"-//ietf//dtd html strict level 0//",
+		"-//ietf//dtd html strict level 1//",
+		"-//ietf//dtd html strict level 2//",
+		"-//ietf//dtd html strict level 3//",
+		"-//ietf//dtd html strict//",
+		"-//ietf//dtd html//",
+		"-//metrius//dtd metrius presentational//",
+		"-//microsoft//dtd internet explorer 2.0 html strict//",
+		"-//microsoft//dtd internet explorer 2.0 html//",
+		"-//microsoft//dtd internet explorer 2.0 tables//",
+		"-//microsoft//dtd internet explorer 3.0 html strict//",
+		"-//microsoft//dtd internet explorer 3.0 html//",
+		"-//microsoft//dtd internet explorer 3.0 tables//",
+		"-//netscape comm. corp.//dtd html//",
+		"-//netscape comm. corp.//dtd strict html//",
+		"-//o'reilly and associates//dtd html 2.0//",
+		"-//o'reilly and associates//dtd html extended 1.0//",
+		"-//o'reilly and associates//dtd html extended relaxed 1.0//",
+		"-//sq//dtd html 2.0 hotmetal + extensions//",
+		"-//softquad software//dtd hotmetal pro 6.0::19990601::extensions to html 4.0//",
+		"-//softquad//dtd hotmetal pro 4.0::19971010::extensions to html 4.0//",
+		"-//spyglass//dtd html 2.0 extended//",
+		"-//sun microsystems corp.//dtd hotjava html//",
+		"-//sun microsystems corp.//dtd hotjava strict html//",
+		"-//w3c//dtd html 3 1995-03-24//",
+		"-//w3c//dtd html 3.2 draft//",
+		"-//w3c//dtd html 3.2 final//",
+		"-//w3c//dtd html 3.2//",
+		"-//w3c//dtd html 3.2s draft//",
+		"-//w3c//dtd html 4.0 frameset//",
+		"-//w3c//dtd html 4.0 transitional//",
+		"-//w3c//dtd html experimental 19960712//",
+		"-//w3c//dtd html experimental 970421//",
+		"-//w3c//dtd w3 html//",
+		"-//w3o//dtd w3 html 3.0//",
+		"-//webtechs//dtd mozilla html 2.0//",
+		"-//webtechs//dtd mozilla html//",
+	}
+)
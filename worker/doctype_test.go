@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"urltracker/internal"
+)
+
+func TestParseDoctype(t *testing.T) {
+	tests := []struct {
+		name      string
+		page      string
+		wantLabel string
+		wantMode  string
+		notFirst  bool
+	}{
+		{"html5", `<!DOCTYPE html><html></html>`, "HTML5", RenderingStandards, false},
+		{"html5 upper case", "\ufeff\n  <!doctype HTML>\n<html></html>", "HTML5", RenderingStandards, false},
+		{"legacy compat", `<!DOCTYPE html SYSTEM "about:legacy-compat"><html></html>`, "HTML5", RenderingStandards, false},
+		{"html 4.01 strict", `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">`, "HTML 4.01 Strict", RenderingStandards, false},
+		{"html 4.01 transitional", `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">`, "HTML 4.01 Transitional", RenderingLimitedQuirks, false},
+		{"html 4.01 transitional without system id", `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN">`, "HTML 4.01 Transitional", RenderingQuirks, false},
+		{"html 4.01 frameset", `<!DOCTYPE HTML PUBLIC '-//W3C//DTD HTML 4.01 Frameset//EN' 'http://www.w3.org/TR/html4/frameset.dtd'>`, "HTML 4.01 Frameset", RenderingLimitedQuirks, false},
+		{"xhtml 1.0 strict", `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`, "XHTML 1.0 Strict", RenderingStandards, false},
+		{"xhtml 1.0 transitional", `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">`, "XHTML 1.0 Transitional", RenderingLimitedQuirks, false},
+		{"xhtml 1.1", `<?xml version="1.0"?><!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`, "XHTML 1.1", RenderingStandards, false},
+		{"xhtml basic", `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML Basic 1.1//EN" "http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd">`, "XHTML Basic 1.1", RenderingStandards, false},
+		{"html 3.2", `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">`, "HTML 3.2", RenderingQuirks, false},
+		{"html 2.0", `<!DOCTYPE HTML PUBLIC "-//IETF//DTD HTML 2.0//EN">`, "HTML 2.0", RenderingQuirks, false},
+		{"unknown public id", `<!DOCTYPE html PUBLIC "-//Example//DTD Custom//EN">`, "Unknown", RenderingStandards, false},
+		{"not html", `<!DOCTYPE svg><svg></svg>`, "Unknown", RenderingQuirks, false},
+		{"no doctype", `<html><head><title>Old</title></head></html>`, "Unknown", RenderingQuirks, false},
+		{"after a comment", `<!-- generated --><!DOCTYPE html><html></html>`, "HTML5", RenderingStandards, true},
+		{"after an element", `<p>hello</p><!DOCTYPE html>`, "HTML5", RenderingQuirks, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parseDoctype([]byte(tt.page))
+			if d.Label() != tt.wantLabel || d.Mode != tt.wantMode || d.NotFirst != tt.notFirst {
+				t.Errorf("parseDoctype() = %+v, label %q, want %q, mode %s, not first %v", d, d.Label(), tt.wantLabel, tt.wantMode, tt.notFirst)
+			}
+		})
+	}
+}
+
+func TestParseDoctypeIdentifiers(t *testing.T) {
+	d := parseDoctype([]byte(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`))
+	if !d.Present || d.Name != "html" || d.PublicID != "-//W3C//DTD XHTML 1.0 Strict//EN" || d.SystemID != "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd" {
+		t.Errorf("parseDoctype() = %+v", d)
+	}
+	if d.Family != "XHTML" || d.Version != "1.0" || d.Variant != "strict" {
+		t.Errorf("parseDoctype() classified as %s %s %s, want XHTML 1.0 strict", d.Family, d.Version, d.Variant)
+	}
+}
+
+func TestCrawlURLDoctype(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
+<html><head><title>Old</title></head><body></body></html>`)
+	}))
+	defer srv.Close()
+
+	result, _, err := NewCrawler(crawlerConfig{}).Analyze(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if result.HTMLVersion != "HTML 4.01 Transitional" {
+		t.Errorf("HTMLVersion = %q, want HTML 4.01 Transitional", result.HTMLVersion)
+	}
+
+	// the doctype is recorded under the analyzer's name
+	data, err := json.Marshal(result.Analyzers[internal.AnalyzerDocument])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Version string `json:"version"`
+		Data    struct {
+			Doctype Doctype `json:"doctype"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != "1" || out.Data.Doctype.Mode != RenderingLimitedQuirks || out.Data.Doctype.Variant != "transitional" {
+		t.Errorf("document output = %s", data)
+	}
+}
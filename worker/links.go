@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+// Link classes counted in AnalysisResult.LinkCounts.
+const (
+	Link2xx   = "2xx"
+	Link3xx   = "3xx"
+	Link4xx   = "4xx"
+	Link5xx   = "5xx"
+	LinkError = "error"
+	// LinkSkipped counts links that were not probed, see LinkCheck.Skipped.
+	LinkSkipped = "skipped"
+)
+
+// Reasons a link was not probed.
+const (
+	// LinkSkippedRobots is given for links robots.txt disallows under the
+	// obey policy.
+	LinkSkippedRobots = "robots"
+	// LinkSkippedBudget is given for links that were not probed before the
+	// time set aside for link checks ran out.
+	LinkSkippedBudget = "budget"
+)
+
+// maxLinkBudgetMargin is how much of the job's time link checks leave at
+// most for writing back the result. Jobs with less time left keep a quarter
+// of it.
+const maxLinkBudgetMargin = 10 * time.Second
+
+// linkErrorTTL caps how long a link that failed with a server or network
+// error is cached, as those tend to be temporary.
+const linkErrorTTL = 5 * time.Minute
+
+// LinkCheck is the probed status of one link on a page.
+type LinkCheck struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Skipped tells why the link was not probed, if it was not.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Class returns which of the Link classes c falls into.
+func (c LinkCheck) Class() string {
+	switch {
+	case c.Skipped != "":
+		return LinkSkipped
+	case c.StatusCode >= 500:
+		return Link5xx
+	case c.StatusCode >= 400:
+		return Link4xx
+	case c.StatusCode >= 300:
+		return Link3xx
+	case c.StatusCode >= 200:
+		return Link2xx
+	}
+	return LinkError
+}
+
+// Broken reports whether following the link does not get a page.
+func (c LinkCheck) Broken() bool {
+	switch c.Class() {
+	case Link4xx, Link5xx, LinkError:
+		return true
+	}
+	return false
+}
+
+// linkStore caches probed links and shares the host limits between workers.
+type linkStore interface {
+	storage.Links
+	storage.Hosts
+}
+
+// linkChecker probes the links found on a page, sharing its results between
+// workers through the store for ttl. Probes keep to the same host limits and
+// robots.txt policy as page crawls.
+type linkChecker struct {
+	store       storage.Links
+	hosts       storage.Hosts
+	limits      cache.HostLimits
+	robots      *robotsChecker
+	client      *http.Client
+	concurrency int
+	// timeout bounds each probe, including the GET fallback.
+	timeout time.Duration
+	ttl     time.Duration
+}
+
+// newLinkClient returns a client on transport that reports redirects instead
+// of following them.
+func newLinkClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAll probes links with at most concurrency requests at a time, and one
+// at a time per host, and returns their statuses in the same order. If ctx
+// has a deadline the probes stop short of it, and the links not probed by
+// then are marked as skipped.
+func (lc *linkChecker) checkAll(ctx context.Context, links []string) []LinkCheck {
+	if deadline, ok := ctx.Deadline(); ok {
+		margin := min(maxLinkBudgetMargin, time.Until(deadline)/4)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-margin))
+		defer cancel()
+	}
+
+	checks := make([]LinkCheck, len(links))
+	sem := make(chan struct{}, max(lc.concurrency, 1))
+	hosts := &hostLocks{locks: make(map[string]*sync.Mutex)}
+	var wg sync.WaitGroup
+
+	for i, link := range links {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(links); j++ {
+				checks[j] = LinkCheck{URL: links[j], Skipped: LinkSkippedBudget}
+			}
+			wg.Wait()
+			return checks
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			checks[i] = lc.check(ctx, link, hosts)
+		}()
+	}
+
+	wg.Wait()
+	return checks
+}
+
+// check returns the status of link from the store, probing it if it is not
+// cached and robots.txt allows it. hosts serializes the probes per host.
+func (lc *linkChecker) check(ctx context.Context, link string, hosts *hostLocks) LinkCheck {
+	if s, err := lc.store.GetLink(ctx, link); err == nil {
+		return LinkCheck{URL: link, StatusCode: s.StatusCode, Error: s.Error}
+	}
+
+	// a robots.txt that cannot be fetched allows everything
+	verdict, _ := lc.robots.check(ctx, link)
+	obey := lc.robots != nil && lc.robots.policy == RobotsObey
+	if obey && !verdict.allowed {
+		return LinkCheck{URL: link, Skipped: LinkSkippedRobots}
+	}
+
+	host := hostOf(link)
+	defer hosts.lock(host)()
+	if lc.hosts != nil {
+		limit := lc.limits.For(host)
+		if obey {
+			limit = politeLimit(limit, verdict.crawlDelay)
+		}
+		// the host lock stands in for a concurrency slot, which the page's
+		// own host could not spare while the job holds one
+		limit.Concurrency = 0
+		// a limiter that is down does not stop the probes
+		if err := waitForHost(ctx, lc.hosts, host, "", limit); err != nil && ctx.Err() != nil {
+			return LinkCheck{URL: link, Skipped: LinkSkippedBudget}
+		}
+	}
+
+	c := lc.probe(ctx, link)
+	if ctx.Err() != nil {
+		// the time for link checks ran out, not the link
+		return LinkCheck{URL: link, Skipped: LinkSkippedBudget}
+	}
+
+	ttl := lc.ttl
+	if c.Class() == Link5xx || c.Class() == LinkError {
+		ttl = min(ttl, linkErrorTTL)
+	}
+	s := &cache.LinkStatus{StatusCode: c.StatusCode, Error: c.Error, CheckedAt: time.Now()}
+	// a failed write only costs another probe next time
+	_ = lc.store.PutLink(context.WithoutCancel(ctx), link, s, ttl)
+	return c
+}
+
+// probe sends a HEAD request for link and falls back to GET if that fails or
+// is refused, since plenty of servers handle HEAD badly.
+func (lc *linkChecker) probe(ctx context.Context, link string) LinkCheck {
+	ctx, cancel := context.WithTimeout(ctx, lc.timeout)
+	defer cancel()
+
+	status, err := lc.request(ctx, http.MethodHead, link)
+	if err == nil && status < 400 {
+		return LinkCheck{URL: link, StatusCode: status}
+	}
+	if ctx.Err() == nil {
+		status, err = lc.request(ctx, http.MethodGet, link)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("no response within %s", lc.timeout)
+		}
+		return LinkCheck{URL: link, Error: err.Error()}
+	}
+	return LinkCheck{URL: link, StatusCode: status}
+}
+
+func (lc *linkChecker) request(ctx context.Context, method, link string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+
+	resp, err := lc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// drain a little so the connection can be reused, but never the whole
+	// body of a GET
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// hostLocks serializes the probes of one page per host.
+type hostLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock waits until no other probe of host is running and returns the
+// function that lets the next one go.
+func (h *hostLocks) lock(host string) func() {
+	h.mu.Lock()
+	l, ok := h.locks[host]
+	if !ok {
+		l = &sync.Mutex{}
+		h.locks[host] = l
+	}
+	h.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// summarizeLinks counts checks by class and picks out the broken ones.
+func summarizeLinks(checks []LinkCheck) (map[string]int, []LinkCheck) {
+	counts := make(map[string]int)
+	var broken []LinkCheck
+	for _, c := range checks {
+		counts[c.Class()]++
+		if c.Broken() {
+			broken = append(broken, c)
+		}
+	}
+	return counts, broken
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+func newTestLinkChecker(store storage.Links) *linkChecker {
+	return &linkChecker{
+		store:       store,
+		client:      newLinkClient(http.DefaultTransport),
+		concurrency: 4,
+		timeout:     time.Second,
+		ttl:         time.Hour,
+	}
+}
+
+func TestLinkCheckerProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		case "/error":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/slow":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	lc := newTestLinkChecker(storage.NewMemoryStore())
+	lc.timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		path   string
+		status int
+		class  string
+	}{
+		{"/ok", 200, Link2xx},
+		{"/no-head", 200, Link2xx},
+		{"/moved", 301, Link3xx},
+		{"/missing", 404, Link4xx},
+		{"/error", 502, Link5xx},
+		{"/slow", 0, LinkError},
+	}
+	for _, tt := range tests {
+		c := lc.probe(context.Background(), srv.URL+tt.path)
+		if c.StatusCode != tt.status || c.Class() != tt.class {
+			t.Errorf("probe(%s) = %+v (%s), want %d (%s)", tt.path, c, c.Class(), tt.status, tt.class)
+		}
+	}
+
+	if c := lc.probe(context.Background(), srv.URL+"/slow"); !strings.Contains(c.Error, "100ms") {
+		t.Errorf("probe(/slow) error = %q, want the timeout in the message", c.Error)
+	}
+}
+
+func TestLinkCheckerCachesResults(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	first := newTestLinkChecker(store).checkAll(ctx, []string{srv.URL + "/a"})
+	second := newTestLinkChecker(store).checkAll(ctx, []string{srv.URL + "/a"})
+
+	if first[0].StatusCode != 404 || second[0].StatusCode != 404 {
+		t.Errorf("checkAll() = %+v then %+v, want 404 twice", first, second)
+	}
+	// HEAD and the GET fallback, then nothing
+	if got := hits.Load(); got != 2 {
+		t.Errorf("server saw %d requests, want 2", got)
+	}
+
+	s, err := store.GetLink(ctx, srv.URL+"/a")
+	if err != nil || s.StatusCode != 404 {
+		t.Errorf("GetLink() = %+v, %v, want cached 404", s, err)
+	}
+}
+
+func TestLinkCheckerBoundsConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	lc := newTestLinkChecker(storage.NewMemoryStore())
+	lc.concurrency = 3
+	var links []string
+	for i := 0; i < 10; i++ {
+		links = append(links, fmt.Sprintf("%s/%d", srv.URL, i))
+	}
+
+	checks := lc.checkAll(context.Background(), links)
+	for i, c := range checks {
+		if c.URL != links[i] || c.StatusCode != 200 {
+			t.Errorf("checkAll()[%d] = %+v, want 200 for %s", i, c, links[i])
+		}
+	}
+	if got := peak.Load(); got > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", got)
+	}
+}
+
+func TestSummarizeLinks(t *testing.T) {
+	counts, broken := summarizeLinks([]LinkCheck{
+		{URL: "a", StatusCode: 200},
+		{URL: "b", StatusCode: 204},
+		{URL: "c", StatusCode: 302},
+		{URL: "d", StatusCode: 404},
+		{URL: "e", StatusCode: 503},
+		{URL: "f", Error: "no such host"},
+	})
+
+	want := map[string]int{Link2xx: 2, Link3xx: 1, Link4xx: 1, Link5xx: 1, LinkError: 1}
+	for class, n := range want {
+		if counts[class] != n {
+			t.Errorf("counts[%s] = %d, want %d", class, counts[class], n)
+		}
+	}
+	if len(broken) != 3 || broken[0].URL != "d" || broken[2].URL != "f" {
+		t.Errorf("broken = %+v, want d, e and f", broken)
+	}
+}
+
+// failingLinks is a link cache that is always unreachable.
+type failingLinks struct{}
+
+func (failingLinks) GetLink(context.Context, string) (*cache.LinkStatus, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func (failingLinks) PutLink(context.Context, string, *cache.LinkStatus, time.Duration) error {
+	return fmt.Errorf("connection refused")
+}
+
+func TestLinkCheckerWithoutCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	checks := newTestLinkChecker(failingLinks{}).checkAll(context.Background(), []string{srv.URL})
+	if checks[0].StatusCode != 200 {
+		t.Errorf("checkAll() = %+v, want 200 despite the cache being down", checks)
+	}
+}
+
+func TestLinkCheckerPolite(t *testing.T) {
+	var active, peak, private atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		case "/private":
+			private.Add(1)
+		}
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	store := storage.NewMemoryStore()
+	lc := newTestLinkChecker(store)
+	lc.hosts = store
+	lc.limits = cache.HostLimits{Default: cache.HostLimit{Rate: 20, Burst: 1, Concurrency: 1}}
+	lc.robots = &robotsChecker{policy: RobotsObey, store: store, client: http.DefaultClient, ttl: time.Hour}
+
+	links := []string{srv.URL + "/a", srv.URL + "/private", srv.URL + "/b", srv.URL + "/c"}
+	started := time.Now()
+	checks := lc.checkAll(context.Background(), links)
+
+	if checks[1].Skipped != LinkSkippedRobots || checks[1].Class() != LinkSkipped || checks[1].Broken() {
+		t.Errorf("checkAll()[1] = %+v, want it skipped for robots.txt", checks[1])
+	}
+	if got := private.Load(); got != 0 {
+		t.Errorf("server saw %d requests for a disallowed link", got)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if checks[i].StatusCode != 200 {
+			t.Errorf("checkAll()[%d] = %+v, want 200", i, checks[i])
+		}
+	}
+	if got := peak.Load(); got != 1 {
+		t.Errorf("peak concurrency on one host = %d, want 1", got)
+	}
+	// one token up front, then one every 50ms
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("three probes took %s, want the host rate to space them out", elapsed)
+	}
+}
+
+func TestCrawlURLLinkBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/slow">slow</a> <a href="/ok">ok</a></body></html>`)
+		case "/slow":
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	cr := NewCrawler(crawlerConfig{})
+	cr.CheckLinks(storage.NewMemoryStore(), cache.HostLimits{}, nil, 1, 5*time.Second, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+
+	result, _, err := cr.Analyze(ctx, srv.URL+"/", nil)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v, want the page despite unprobed links", err)
+	}
+	if ctx.Err() != nil {
+		t.Error("link checks used up the whole job deadline")
+	}
+	for _, c := range result.Links {
+		if c.Skipped != LinkSkippedBudget {
+			t.Errorf("link %+v, want it skipped for the budget", c)
+		}
+	}
+	if len(result.Links) != 2 || result.LinkCounts[LinkSkipped] != 2 || len(result.BrokenLinks) != 0 {
+		t.Errorf("Links = %+v, LinkCounts = %v", result.Links, result.LinkCounts)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"urltracker/internal/storage"
+)
+
+// pool runs processNext on a fixed number of goroutines that share one store,
+// so at most concurrency jobs are in flight at any time.
+type pool struct {
+	store       storage.Store
+	crawl       CrawlerFunc
+	opts        jobOptions
+	logger      *log.Logger
+	concurrency int
+	// jobTimeout bounds each job. A job's crawl is cancelled when the pool
+	// is told to stop, but its outcome is still written.
+	jobTimeout time.Duration
+	// interval is how long an idle slot waits before polling again, and how
+	// often expired leases and due scheduled jobs are moved back to the queue.
+	interval time.Duration
+	// blocking is set when the store waits for jobs in DequeueURL, so an
+	// empty dequeue is retried right away.
+	blocking bool
+	// drainTimeout is how long crawls in flight may go on once the pool is
+	// told to stop. Jobs whose crawl is cut off are put back on the queue.
+	drainTimeout time.Duration
+
+	mu       sync.Mutex
+	drained  int
+	requeued []string
+}
+
+// run processes jobs until ctx is cancelled. It stops taking new jobs as soon
+// as ctx is done, gives the jobs in flight drainTimeout to finish, requeues
+// the ones that did not and logs what happened to them.
+func (p *pool) run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	// crawls outlive ctx by up to drainTimeout
+	crawlCtx, cancelCrawls := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelCrawls()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		p.logger.Println("draining jobs in flight for up to", p.drainTimeout)
+
+		t := time.NewTimer(p.drainTimeout)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+			cancelCrawls()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.maintain(ctx)
+	}()
+
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx, crawlCtx)
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+
+	if ctx.Err() != nil && p.drained > 0 {
+		p.logger.Printf("drained %d jobs in flight: %d finished, %d requeued %v", p.drained, p.drained-len(p.requeued), len(p.requeued), p.requeued)
+	}
+}
+
+// work takes jobs until ctx is done and runs them on crawlCtx.
+func (p *pool) work(ctx, crawlCtx context.Context) {
+	for ctx.Err() == nil {
+		processed, err := p.next(ctx, crawlCtx)
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.logger.Println("dequeue error:", err)
+		}
+		if err != nil || (!processed && !p.blocking) {
+			// queue empty or unreachable, back off before polling again
+			if !sleep(ctx, p.interval) {
+				return
+			}
+		}
+	}
+}
+
+// next takes one job and processes it on a context derived from crawlCtx and
+// limited to jobTimeout. Waiting for a job stops as soon as ctx is done; jobs
+// that end after that count as drained.
+func (p *pool) next(ctx, crawlCtx context.Context) (bool, error) {
+	opts := p.opts
+	opts.timeout = p.jobTimeout
+	opts.requeued = func(id string) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.requeued = append(p.requeued, id)
+	}
+
+	processed, err := processNext(ctx, crawlCtx, p.store, p.crawl, opts, p.logger)
+	if processed && ctx.Err() != nil {
+		p.mu.Lock()
+		p.drained++
+		p.mu.Unlock()
+	}
+	return processed, err
+}
+
+// maintain periodically requeues jobs with expired leases and queues
+// scheduled jobs that became due.
+func (p *pool) maintain(ctx context.Context) {
+	for {
+		requeued, err := p.store.RequeueExpired(ctx)
+		if err != nil {
+			p.logger.Println("requeue expired leases:", err)
+		} else if requeued > 0 {
+			p.logger.Println("requeued", requeued, "jobs with expired leases")
+		}
+
+		promoted, err := p.store.PromoteDue(ctx)
+		if err != nil {
+			p.logger.Println("promote scheduled jobs:", err)
+		} else if promoted > 0 {
+			p.logger.Println("queued", promoted, "scheduled jobs")
+		}
+
+		if !sleep(ctx, p.interval) {
+			return
+		}
+	}
+}
+
+// sleep waits for d and reports false if ctx was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
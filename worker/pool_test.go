@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+func newTestPool(t *testing.T, jobs int, concurrency int, crawl CrawlerFunc) (*pool, storage.Store) {
+	t.Helper()
+
+	store := storage.NewMemoryStore()
+	store.UseReliableQueue("test", time.Minute)
+	ctx := context.Background()
+	for i := 0; i < jobs; i++ {
+		tracker := &cache.URLTracker{ID: fmt.Sprintf("job-%d", i), URL: "https://example.com", Status: internal.StatusPending}
+		if err := store.StoreURL(ctx, tracker); err != nil {
+			t.Fatalf("StoreURL() error = %v", err)
+		}
+	}
+
+	return &pool{
+		store:       store,
+		crawl:       crawl,
+		logger:      log.New(io.Discard, "", 0),
+		concurrency: concurrency,
+		jobTimeout:  time.Minute,
+		interval:    time.Millisecond,
+	}, store
+}
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	var active, peak, done atomic.Int32
+	finished := make(chan struct{})
+
+	p, store := newTestPool(t, 6, 3, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		n := active.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+		if done.Add(1) == 6 {
+			close(finished)
+		}
+		return "ok", nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool did not process all jobs")
+	}
+	cancel()
+	<-stopped
+
+	if got := peak.Load(); got != 3 {
+		t.Errorf("peak concurrency = %d, want 3", got)
+	}
+
+	page, err := store.ListURLs(context.Background(), cache.ListOptions{Status: internal.StatusCompleted})
+	if err != nil {
+		t.Fatalf("ListURLs() error = %v", err)
+	}
+	if len(page.Trackers) != 6 {
+		t.Errorf("completed trackers = %d, want 6", len(page.Trackers))
+	}
+}
+
+func TestPoolFinishesInFlightJobsOnCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var crawled atomic.Int32
+
+	var once sync.Once
+	p, store := newTestPool(t, 3, 1, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		crawled.Add(1)
+		once.Do(func() { close(started) })
+		<-release
+		return "ok", nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(stopped)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-stopped:
+		t.Fatal("run() returned before the job in flight finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not return after cancellation")
+	}
+
+	if got := crawled.Load(); got != 1 {
+		t.Errorf("crawled %d jobs, want only the one in flight", got)
+	}
+
+	tracker, err := store.GetURL(context.Background(), "job-0")
+	if err != nil {
+		t.Fatalf("GetURL() error = %v", err)
+	}
+	if tracker.Status != internal.StatusCompleted {
+		t.Errorf("job in flight status = %q, want %q", tracker.Status, internal.StatusCompleted)
+	}
+}
+
+func TestPoolRequeuesJobsLeftAfterDrain(t *testing.T) {
+	started := make(chan struct{})
+	p, store := newTestPool(t, 1, 1, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	p.drainTimeout = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(stopped)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not interrupt the crawl after the drain timeout")
+	}
+
+	tracker, err := store.GetURL(context.Background(), "job-0")
+	if err != nil {
+		t.Fatalf("GetURL() error = %v", err)
+	}
+	if tracker.Status != internal.StatusPending || tracker.Attempts != 0 {
+		t.Errorf("requeued tracker = %+v, want pending without the interrupted attempt", tracker)
+	}
+	stats, err := store.QueueStats(context.Background())
+	if err != nil {
+		t.Fatalf("QueueStats() error = %v", err)
+	}
+	if stats.Queued != 1 || stats.InFlight != 0 {
+		t.Errorf("queued = %d, in flight = %d, want the job back on the queue", stats.Queued, stats.InFlight)
+	}
+	if p.drained != 1 || len(p.requeued) != 1 || p.requeued[0] != "job-0" {
+		t.Errorf("drained = %d, requeued = %v, want job-0 requeued", p.drained, p.requeued)
+	}
+}
+
+func TestPoolDrainsWithinTimeout(t *testing.T) {
+	started := make(chan struct{})
+	p, store := newTestPool(t, 1, 1, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		close(started)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return "ok", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+	p.drainTimeout = 5 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(stopped)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not return after the job finished")
+	}
+
+	tracker, err := store.GetURL(context.Background(), "job-0")
+	if err != nil {
+		t.Fatalf("GetURL() error = %v", err)
+	}
+	if tracker.Status != internal.StatusCompleted {
+		t.Errorf("drained job status = %q, want %q", tracker.Status, internal.StatusCompleted)
+	}
+	if p.drained != 1 || len(p.requeued) != 0 {
+		t.Errorf("drained = %d, requeued = %v, want one job finished", p.drained, p.requeued)
+	}
+}
+
+func TestPoolBlockingDequeue(t *testing.T) {
+	crawled := make(chan time.Time, 1)
+	p, store := newTestPool(t, 0, 1, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		crawled <- time.Now()
+		return "ok", nil
+	})
+	p.store.(*storage.LocalStore).SetBlockTimeout(time.Minute)
+	p.blocking = true
+	p.interval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(stopped)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	submitted := time.Now()
+	tracker := &cache.URLTracker{ID: "late", URL: "https://example.com", Status: internal.StatusPending}
+	if err := store.StoreURL(context.Background(), tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+
+	select {
+	case at := <-crawled:
+		if d := at.Sub(submitted); d > time.Second {
+			t.Errorf("job picked up after %v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job submitted to an idle pool was not picked up")
+	}
+
+	// a worker waiting for jobs still stops right away
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pool did not stop while waiting for jobs")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	ErrUnknownAnalyzer   = errors.New("unknown analyzer")
+	ErrDuplicateAnalyzer = errors.New("analyzer already registered")
+)
+
+// Analyzer is one check run on crawled pages. Analyzers are registered with
+// a Registry and selected per submission.
+type Analyzer interface {
+	// Name keys the analyzer's output in AnalysisResult.Analyzers and is
+	// what submissions select it by.
+	Name() string
+	// Version is recorded with every output. Bump it whenever the output
+	// changes meaning, so stored results can be told apart.
+	Version() string
+	// Attach registers the callbacks of one analysis of page on c, such as
+	// OnResponse or OnHTML, and returns the function that contributes its
+	// findings once the page has been read.
+	Attach(c *colly.Collector, page *url.URL) ContributeFunc
+}
+
+// ContributeFunc returns the findings of one analysis, which are stored under
+// the analyzer's name, or nil if it found nothing to report or wrote them to
+// fields of result instead. ctx is the crawl's, for analyzers that make
+// further requests; an error fails the crawl.
+type ContributeFunc func(ctx context.Context, result *AnalysisResult) (any, error)
+
+// AnalyzerOutput is what one analyzer contributed to a result.
+type AnalyzerOutput struct {
+	Version string `json:"version"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Registry holds the analyzers a crawler can run, in registration order.
+type Registry struct {
+	analyzers []Analyzer
+}
+
+// Register adds a to the registry. Names must be unique.
+func (r *Registry) Register(a Analyzer) error {
+	if _, err := r.Select([]string{a.Name()}); err == nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateAnalyzer, a.Name())
+	}
+	r.analyzers = append(r.analyzers, a)
+	return nil
+}
+
+// Names returns the names of the registered analyzers.
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.analyzers))
+	for i, a := range r.analyzers {
+		names[i] = a.Name()
+	}
+	return names
+}
+
+// Select returns the analyzers with the given names in registration order,
+// or all of them if names is empty.
+func (r *Registry) Select(names []string) ([]Analyzer, error) {
+	if len(names) == 0 {
+		return r.analyzers, nil
+	}
+
+	want := make(map[string]bool, len(names))
+	for _, name := range names {
+		want[name] = true
+	}
+	var selected []Analyzer
+	for _, a := range r.analyzers {
+		if want[a.Name()] {
+			selected = append(selected, a)
+			delete(want, a.Name())
+		}
+	}
+	for _, name := range names {
+		if want[name] {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAnalyzer, name)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"urltracker/internal"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// countAnalyzer counts the elements matching selector.
+type countAnalyzer struct {
+	name     string
+	selector string
+}
+
+func (a countAnalyzer) Name() string    { return a.name }
+func (a countAnalyzer) Version() string { return "2" }
+
+func (a countAnalyzer) Attach(c *colly.Collector, _ *url.URL) ContributeFunc {
+	var n int
+	c.OnHTML(a.selector, func(*colly.HTMLElement) { n++ })
+	return func(context.Context, *AnalysisResult) (any, error) {
+		return map[string]int{"count": n}, nil
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := r.Register(countAnalyzer{name: name}); err != nil {
+			t.Fatalf("Register(%s) error = %v", name, err)
+		}
+	}
+	if err := r.Register(countAnalyzer{name: "b"}); !errors.Is(err, ErrDuplicateAnalyzer) {
+		t.Errorf("Register() of a taken name error = %v, want %v", err, ErrDuplicateAnalyzer)
+	}
+
+	tests := []struct {
+		names   []string
+		want    []string
+		wantErr error
+	}{
+		{nil, []string{"a", "b", "c"}, nil},
+		{[]string{"c", "a"}, []string{"a", "c"}, nil},
+		{[]string{"b", "nope"}, nil, ErrUnknownAnalyzer},
+	}
+	for _, tt := range tests {
+		selected, err := r.Select(tt.names)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Select(%v) error = %v, want %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, a := range selected {
+			got = append(got, a.Name())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Select(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlerBuiltInAnalyzers(t *testing.T) {
+	// the API accepts the names in internal.Analyzers, the worker must know
+	// every one of them
+	got := NewCrawler(crawlerConfig{}).analyzers.Names()
+	if !slices.Equal(got, internal.Analyzers) {
+		t.Errorf("built-in analyzers = %v, want %v", got, internal.Analyzers)
+	}
+
+	// registering them one by one catches names used twice
+	r := &Registry{}
+	for _, a := range builtInAnalyzers(&Crawler{}) {
+		if err := r.Register(a); err != nil {
+			t.Errorf("Register(%s) error = %v", a.Name(), err)
+		}
+	}
+}
+
+func TestAnalyzeSelectsAnalyzers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<!DOCTYPE html><html><head><title>Example</title></head><body>
+			<h1>Hi</h1><img src="a.png"><img src="b.png"><a href="/about">About</a>
+			<form><input type="password"></form></body></html>`)
+	}))
+	defer srv.Close()
+
+	cr := NewCrawler(crawlerConfig{})
+	if err := cr.Register(countAnalyzer{name: "images", selector: "img"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, links, err := cr.Analyze(context.Background(), srv.URL, []string{"images", internal.AnalyzerLogin})
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if result.Title != "" || result.InternalLinks != 0 || !result.HasLoginForm {
+		t.Errorf("Analyze() = %+v, want only the login form filled in", result)
+	}
+	if len(result.Analyzers) != 2 || result.Analyzers[internal.AnalyzerLogin].Version != "1" {
+		t.Errorf("Analyzers = %+v, want login and images", result.Analyzers)
+	}
+	if out := result.Analyzers["images"]; out.Version != "2" || fmt.Sprint(out.Data) != "map[count:2]" {
+		t.Errorf("images output = %+v, want version 2 with a count of 2", out)
+	}
+	if result.Ran(internal.AnalyzerDocument) {
+		t.Error("Ran(document) = true for a result without it")
+	}
+	// site audits follow links even without the links analyzer
+	if len(links) != 1 {
+		t.Errorf("links = %v, want /about", links)
+	}
+
+	if _, _, err := cr.Analyze(context.Background(), srv.URL, []string{"nope"}); !errors.Is(err, ErrUnknownAnalyzer) {
+		t.Errorf("Analyze() with an unknown analyzer error = %v, want %v", err, ErrUnknownAnalyzer)
+	}
+}
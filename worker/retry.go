@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// crawlError is a failed fetch together with what the server told us about
+// it. StatusCode is 0 when no response was received.
+type crawlError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *crawlError) Error() string {
+	return "Failed to collect data for URL. \nError: " + e.Err.Error() + " \n Status Code: " + strconv.Itoa(e.StatusCode)
+}
+
+func (e *crawlError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether err is likely to go away on its own: timeouts,
+// DNS hiccups, dropped connections, 5xx responses and 429. retryAfter is the
+// delay the server asked for, if any.
+func retryable(err error) (ok bool, retryAfter time.Duration) {
+	var ce *crawlError
+	if errors.As(err, &ce) && ce.StatusCode != 0 {
+		switch {
+		case ce.StatusCode == http.StatusTooManyRequests, ce.StatusCode >= 500:
+			return true, ce.RetryAfter
+		case ce.StatusCode == http.StatusRequestTimeout:
+			return true, 0
+		}
+		return false, 0
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// retryPolicy decides whether and when a failed crawl is tried again. The
+// zero value never retries.
+type retryPolicy struct {
+	// maxAttempts counts the first attempt too.
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// next returns the delay before the next attempt of a tracker whose
+// attempts-th attempt failed with err, or false if it should not be retried.
+// A Retry-After longer than maxDelay is cut down to it, so a server cannot
+// park a job for as long as it likes.
+func (p retryPolicy) next(attempts int, err error) (time.Duration, bool) {
+	if attempts >= p.maxAttempts {
+		return 0, false
+	}
+	ok, retryAfter := retryable(err)
+	if !ok {
+		return 0, false
+	}
+	if p.maxDelay > 0 {
+		retryAfter = min(retryAfter, p.maxDelay)
+	}
+	return max(p.backoff(attempts), retryAfter), true
+}
+
+// backoff doubles baseDelay for every attempt made, caps it at maxDelay and
+// picks a random delay in the upper half so retries of many trackers that
+// failed together spread out.
+func (p retryPolicy) backoff(attempts int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < attempts && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if p.maxDelay > 0 && d > p.maxDelay {
+		d = p.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
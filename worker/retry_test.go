@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       bool
+		retryAfter time.Duration
+	}{
+		{"503", &crawlError{StatusCode: 503, Err: errors.New("Service Unavailable")}, true, 0},
+		{"429 with Retry-After", &crawlError{StatusCode: 429, RetryAfter: time.Minute, Err: errors.New("Too Many Requests")}, true, time.Minute},
+		{"408", &crawlError{StatusCode: 408, Err: errors.New("Request Timeout")}, true, 0},
+		{"404", &crawlError{StatusCode: 404, Err: errors.New("Not Found")}, false, 0},
+		{"403", &crawlError{StatusCode: 403, Err: errors.New("Forbidden")}, false, 0},
+		{"deadline", &crawlError{Err: fmt.Errorf("get: %w", context.DeadlineExceeded)}, true, 0},
+		{"dns timeout", &crawlError{Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, true, 0},
+		{"dns temporary", &crawlError{Err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}}, true, 0},
+		{"dns not found", &crawlError{Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, false, 0},
+		{"connection reset", &crawlError{Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true, 0},
+		{"invalid URL", errors.New("invalid URL: missing scheme"), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, retryAfter := retryable(tt.err)
+			if got != tt.want || retryAfter != tt.retryAfter {
+				t.Errorf("retryable() = %v, %v, want %v, %v", got, retryAfter, tt.want, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Wed, 01 May 2024 12:01:30 GMT", 90 * time.Second},
+		{"Wed, 01 May 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	p := retryPolicy{maxAttempts: 4, baseDelay: 10 * time.Second, maxDelay: 30 * time.Second}
+	transient := &crawlError{StatusCode: 503, Err: errors.New("Service Unavailable")}
+
+	// the delay doubles per attempt, is capped and jittered into its upper half
+	for attempts, ceiling := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 30 * time.Second} {
+		for i := 0; i < 20; i++ {
+			d, ok := p.next(attempts, transient)
+			if !ok || d < ceiling/2 || d > ceiling {
+				t.Fatalf("next(%d) = %v, %v, want within [%v, %v]", attempts, d, ok, ceiling/2, ceiling)
+			}
+		}
+	}
+
+	if _, ok := p.next(4, transient); ok {
+		t.Error("next() retried after maxAttempts")
+	}
+
+	limited := &crawlError{StatusCode: 429, RetryAfter: 25 * time.Second, Err: errors.New("Too Many Requests")}
+	if d, ok := p.next(1, limited); !ok || d != 25*time.Second {
+		t.Errorf("next() = %v, %v, want Retry-After of 25s", d, ok)
+	}
+
+	// a Retry-After beyond maxDelay is capped
+	limited.RetryAfter = 365 * 24 * time.Hour
+	if d, ok := p.next(1, limited); !ok || d != 30*time.Second {
+		t.Errorf("next() = %v, %v, want maxDelay of 30s", d, ok)
+	}
+
+	if _, ok := (retryPolicy{}).next(1, transient); ok {
+		t.Error("zero retryPolicy retried")
+	}
+}
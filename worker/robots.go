@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+
+	"github.com/temoto/robotstxt"
+)
+
+// crawlerUserAgent is sent with every request and matched against the
+// User-agent groups of robots.txt.
+const crawlerUserAgent = "urltracker"
+
+// Robots policies.
+const (
+	RobotsObey   = "obey"
+	RobotsIgnore = "ignore"
+	RobotsReport = "report"
+)
+
+func isValidRobotsPolicy(p string) bool {
+	switch p {
+	case RobotsObey, RobotsIgnore, RobotsReport:
+		return true
+	}
+	return false
+}
+
+// maxRobotsSize caps how much of a robots.txt is read, like the 500 KiB
+// limit of RFC 9309.
+const maxRobotsSize = 500 << 10
+
+// robotsErrorTTL caps how long a robots.txt that failed with a server error
+// is cached.
+const robotsErrorTTL = 10 * time.Minute
+
+// robotsVerdict is what robots.txt says about one URL.
+type robotsVerdict struct {
+	allowed    bool
+	crawlDelay time.Duration
+}
+
+// robotsChecker looks URLs up in their host's robots.txt, fetching it at most
+// once per ttl for all workers through the store.
+type robotsChecker struct {
+	policy string
+	store  storage.Robots
+	client *http.Client
+	ttl    time.Duration
+}
+
+// check returns the verdict for rawURL. Under the ignore policy, or if
+// robots.txt cannot be fetched at all, everything is allowed.
+func (r *robotsChecker) check(ctx context.Context, rawURL string) (robotsVerdict, error) {
+	allowAll := robotsVerdict{allowed: true}
+	if r == nil || r.policy == RobotsIgnore {
+		return allowAll, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return allowAll, nil
+	}
+
+	data, err := r.load(ctx, u.Scheme+"://"+u.Host)
+	if err != nil {
+		return allowAll, err
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return robotsVerdict{
+		allowed:    data.TestAgent(path, crawlerUserAgent),
+		crawlDelay: data.FindGroup(crawlerUserAgent).CrawlDelay,
+	}, nil
+}
+
+// load returns the robots.txt of origin, fetching it if it is not cached or
+// the cached copy expired.
+func (r *robotsChecker) load(ctx context.Context, origin string) (*robotstxt.RobotsData, error) {
+	f, err := r.store.GetRobots(ctx, origin)
+	if errors.Is(err, cache.ErrNoRobots) {
+		f, err = r.fetch(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+		ttl := r.ttl
+		if f.StatusCode >= 500 {
+			// a failing server blocks everything, so ask again soon
+			ttl = min(ttl, robotsErrorTTL)
+		}
+		if err := r.store.PutRobots(ctx, origin, f, ttl); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := robotstxt.FromStatusAndString(f.StatusCode, f.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s/robots.txt: %w", origin, err)
+	}
+	return data, nil
+}
+
+func (r *robotsChecker) fetch(ctx context.Context, origin string) (*cache.RobotsFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s/robots.txt: %w", origin, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return nil, fmt.Errorf("read %s/robots.txt: %w", origin, err)
+	}
+
+	return &cache.RobotsFile{StatusCode: resp.StatusCode, Body: string(body), FetchedAt: time.Now()}, nil
+}
+
+// politeLimit slows limit down to the crawl delay a host asked for.
+func politeLimit(limit cache.HostLimit, crawlDelay time.Duration) cache.HostLimit {
+	if crawlDelay <= 0 {
+		return limit
+	}
+	rate := 1 / crawlDelay.Seconds()
+	if limit.Rate <= 0 || rate < limit.Rate {
+		limit.Rate, limit.Burst = rate, 1
+	}
+	return limit
+}
+
+// blockedByRobots stands in for the crawl of a URL that robots.txt disallows.
+func blockedByRobots(context.Context, *cache.URLTracker) (string, error) {
+	data, err := json.Marshal(&AnalysisResult{BlockedByRobots: true})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// reportRobots wraps crawl to flag its result as disallowed by robots.txt.
+func reportRobots(crawl CrawlerFunc) CrawlerFunc {
+	return func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		result, err := crawl(ctx, t)
+		if err != nil {
+			return result, err
+		}
+
+		var r AnalysisResult
+		if err := json.Unmarshal([]byte(result), &r); err != nil {
+			return result, nil
+		}
+		r.BlockedByRobots = true
+		data, err := json.Marshal(&r)
+		if err != nil {
+			return result, nil
+		}
+		return string(data), nil
+	}
+}
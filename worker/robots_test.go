@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+)
+
+// newRobotsServer serves body as robots.txt with status and counts how often
+// it was fetched.
+func newRobotsServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func newRobotsChecker(policy string) *robotsChecker {
+	return &robotsChecker{
+		policy: policy,
+		store:  storage.NewMemoryStore(),
+		client: http.DefaultClient,
+		ttl:    time.Hour,
+	}
+}
+
+func TestRobotsCheckerObey(t *testing.T) {
+	srv, fetches := newRobotsServer(t, http.StatusOK, "User-agent: *\nDisallow: /private\nCrawl-delay: 2\n\nUser-agent: urltracker\nDisallow: /admin\n")
+	r := newRobotsChecker(RobotsObey)
+	ctx := context.Background()
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/private/page", true},
+		{"/admin", false},
+		{"/admin/users?page=2", false},
+	}
+	for _, tt := range tests {
+		v, err := r.check(ctx, srv.URL+tt.path)
+		if err != nil {
+			t.Fatalf("check(%s) error = %v", tt.path, err)
+		}
+		if v.allowed != tt.allowed {
+			t.Errorf("check(%s) allowed = %v, want %v", tt.path, v.allowed, tt.allowed)
+		}
+	}
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", got)
+	}
+}
+
+func TestRobotsCheckerCrawlDelay(t *testing.T) {
+	srv, _ := newRobotsServer(t, http.StatusOK, "User-agent: *\nDisallow: /private\nCrawl-delay: 2\n")
+	r := newRobotsChecker(RobotsObey)
+
+	v, err := r.check(context.Background(), srv.URL+"/private")
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if v.allowed || v.crawlDelay != 2*time.Second {
+		t.Errorf("check() = %+v, want disallowed with a 2s crawl delay", v)
+	}
+
+	limit := politeLimit(cache.HostLimit{Rate: 1, Burst: 5, Concurrency: 2}, v.crawlDelay)
+	if limit.Rate != 0.5 || limit.Burst != 1 || limit.Concurrency != 2 {
+		t.Errorf("politeLimit() = %+v, want one request every 2s", limit)
+	}
+	if limit := politeLimit(cache.HostLimit{Rate: 0.1, Burst: 5}, v.crawlDelay); limit.Rate != 0.1 || limit.Burst != 5 {
+		t.Errorf("politeLimit() = %+v, want the stricter configured limit kept", limit)
+	}
+}
+
+func TestRobotsCheckerStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		allowed bool
+	}{
+		{"missing robots.txt allows everything", http.StatusNotFound, true},
+		{"failing server allows nothing", http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newRobotsServer(t, tt.status, "")
+			v, err := newRobotsChecker(RobotsObey).check(context.Background(), srv.URL+"/page")
+			if err != nil {
+				t.Fatalf("check() error = %v", err)
+			}
+			if v.allowed != tt.allowed {
+				t.Errorf("check() allowed = %v, want %v", v.allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRobotsCheckerIgnore(t *testing.T) {
+	srv, fetches := newRobotsServer(t, http.StatusOK, "User-agent: *\nDisallow: /\n")
+
+	for _, r := range []*robotsChecker{nil, newRobotsChecker(RobotsIgnore)} {
+		v, err := r.check(context.Background(), srv.URL+"/page")
+		if err != nil || !v.allowed {
+			t.Errorf("check() = %+v, %v, want allowed", v, err)
+		}
+	}
+	if got := fetches.Load(); got != 0 {
+		t.Errorf("robots.txt fetched %d times, want 0", got)
+	}
+}
+
+func TestProcessNextRobots(t *testing.T) {
+	srv, _ := newRobotsServer(t, http.StatusOK, "User-agent: *\nDisallow: /private\n")
+
+	tests := []struct {
+		policy      string
+		wantCrawled bool
+	}{
+		{RobotsObey, false},
+		{RobotsReport, true},
+		{RobotsIgnore, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			store := &mockStore{
+				dequeue: []*cache.URLTracker{
+					{ID: "r", URL: srv.URL + "/private", Status: internal.StatusPending},
+				},
+			}
+			logger := log.New(io.Discard, "", 0)
+
+			crawled := false
+			_, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+				crawled = true
+				return `{"title":"Private"}`, nil
+			}, jobOptions{robots: newRobotsChecker(tt.policy)}, logger)
+			if err != nil {
+				t.Fatalf("processNext() error = %v", err)
+			}
+
+			if crawled != tt.wantCrawled {
+				t.Errorf("crawled = %v, want %v", crawled, tt.wantCrawled)
+			}
+			final := store.updates[len(store.updates)-1]
+			if final.Status != internal.StatusCompleted {
+				t.Errorf("final status = %q, want %q", final.Status, internal.StatusCompleted)
+			}
+			if flagged := strings.Contains(final.Result, `"blocked_by_robots":true`); flagged != (tt.policy != RobotsIgnore) {
+				t.Errorf("result = %s, blocked_by_robots flagged = %v", final.Result, flagged)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"urltracker/internal"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Lengths search engines show of titles and meta descriptions without
+// cutting them off, and below which they tend to replace them.
+const (
+	minTitleLength       = 30
+	maxTitleLength       = 60
+	minDescriptionLength = 70
+	maxDescriptionLength = 160
+)
+
+// Codes of SEOReport.Warnings.
+const (
+	SEOTitleMissing        = "title_missing"
+	SEOTitleShort          = "title_too_short"
+	SEOTitleLong           = "title_too_long"
+	SEODescriptionMissing  = "description_missing"
+	SEODescriptionShort    = "description_too_short"
+	SEODescriptionLong     = "description_too_long"
+	SEODescriptionMultiple = "description_multiple"
+	SEOCanonicalMissing    = "canonical_missing"
+	SEOCanonicalMultiple   = "canonical_multiple"
+	SEOCanonicalInvalid    = "canonical_invalid"
+	SEONoIndex             = "noindex"
+	SEOOpenGraphIncomplete = "open_graph_incomplete"
+	SEOTwitterCardMissing  = "twitter_card_missing"
+	SEOHreflangInvalid     = "hreflang_invalid"
+	SEOHreflangDuplicate   = "hreflang_duplicate"
+	SEOH1Missing           = "h1_missing"
+	SEOH1Multiple          = "h1_multiple"
+	SEOH1DuplicateText     = "h1_duplicate_text"
+)
+
+// openGraphRequired are the properties every OpenGraph object must have.
+var openGraphRequired = []string{"og:title", "og:type", "og:image", "og:url"}
+
+// hreflangCode matches a language with an optional script and region, or
+// x-default.
+var hreflangCode = regexp.MustCompile(`(?i)^(x-default|[a-z]{2,3}(-[a-z]{4})?(-([a-z]{2}|[0-9]{3}))?)$`)
+
+// SEOReport is the output of the seo analyzer.
+type SEOReport struct {
+	Title             string `json:"title"`
+	TitleLength       int    `json:"title_length"`
+	Description       string `json:"description,omitempty"`
+	DescriptionLength int    `json:"description_length"`
+	// Canonical is the canonical URL resolved against the page, and
+	// CanonicalSelf whether it is the page itself.
+	Canonical     string `json:"canonical,omitempty"`
+	CanonicalSelf bool   `json:"canonical_self,omitempty"`
+	// Robots holds the directives of the robots meta tag and XRobotsTag
+	// those of the X-Robots-Tag header, in lower case. NoIndex and
+	// NoFollow combine both.
+	Robots     []string          `json:"robots,omitempty"`
+	XRobotsTag []string          `json:"x_robots_tag,omitempty"`
+	NoIndex    bool              `json:"noindex"`
+	NoFollow   bool              `json:"nofollow"`
+	OpenGraph  map[string]string `json:"open_graph,omitempty"`
+	Twitter    map[string]string `json:"twitter,omitempty"`
+	Hreflang   []Hreflang        `json:"hreflang,omitempty"`
+	H1         []string          `json:"h1,omitempty"`
+	Warnings   []SEOWarning      `json:"warnings,omitempty"`
+}
+
+// Hreflang is an alternate version of the page for a language.
+type Hreflang struct {
+	Lang string `json:"lang"`
+	URL  string `json:"url"`
+}
+
+// SEOWarning is something about a page that hurts how search engines and
+// social networks show it.
+type SEOWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// seoAnalyzer reads and checks the metadata search engines and social
+// networks use.
+type seoAnalyzer struct{}
+
+func (seoAnalyzer) Name() string    { return internal.AnalyzerSEO }
+func (seoAnalyzer) Version() string { return "1" }
+
+func (seoAnalyzer) Attach(c *colly.Collector, page *url.URL) ContributeFunc {
+	report := &SEOReport{}
+	var titles, descriptions, canonicals []string
+	var html bool
+
+	c.OnResponse(func(r *colly.Response) {
+		if r.Headers == nil {
+			return
+		}
+		for _, v := range r.Headers.Values("X-Robots-Tag") {
+			report.XRobotsTag = append(report.XRobotsTag, robotsDirectives(v)...)
+		}
+	})
+
+	c.OnHTML("html", func(*colly.HTMLElement) {
+		html = true
+	})
+
+	c.OnHTML("head > title", func(e *colly.HTMLElement) {
+		titles = append(titles, e.Text)
+	})
+
+	c.OnHTML("meta[name], meta[property]", func(e *colly.HTMLElement) {
+		content := strings.TrimSpace(e.Attr("content"))
+		name := strings.ToLower(strings.TrimSpace(e.Attr("name")))
+		if name == "" {
+			name = strings.ToLower(strings.TrimSpace(e.Attr("property")))
+		}
+
+		switch {
+		case name == "description":
+			descriptions = append(descriptions, content)
+		case name == "robots":
+			report.Robots = append(report.Robots, robotsDirectives(content)...)
+		case strings.HasPrefix(name, "og:"):
+			if report.OpenGraph == nil {
+				report.OpenGraph = make(map[string]string)
+			}
+			if _, ok := report.OpenGraph[name]; !ok {
+				report.OpenGraph[name] = content
+			}
+		case strings.HasPrefix(name, "twitter:"):
+			if report.Twitter == nil {
+				report.Twitter = make(map[string]string)
+			}
+			if _, ok := report.Twitter[name]; !ok {
+				report.Twitter[name] = content
+			}
+		}
+	})
+
+	c.OnHTML("link[rel][href]", func(e *colly.HTMLElement) {
+		rels := strings.Fields(strings.ToLower(e.Attr("rel")))
+		href := strings.TrimSpace(e.Attr("href"))
+		switch {
+		case slices.Contains(rels, "canonical"):
+			canonicals = append(canonicals, href)
+		case slices.Contains(rels, "alternate") && e.Attr("hreflang") != "":
+			link := href
+			if u, ok := resolveLink(page, href); ok {
+				link = u.String()
+			}
+			report.Hreflang = append(report.Hreflang, Hreflang{Lang: strings.TrimSpace(e.Attr("hreflang")), URL: link})
+		}
+	})
+
+	c.OnHTML("h1", func(e *colly.HTMLElement) {
+		report.H1 = append(report.H1, collapseSpace(e.Text))
+	})
+
+	return func(_ context.Context, _ *AnalysisResult) (any, error) {
+		if !html {
+			return nil, nil
+		}
+		if len(titles) > 0 {
+			report.Title = collapseSpace(titles[0])
+		}
+		if len(descriptions) > 0 {
+			report.Description = collapseSpace(descriptions[0])
+		}
+		report.TitleLength = utf8.RuneCountInString(report.Title)
+		report.DescriptionLength = utf8.RuneCountInString(report.Description)
+
+		if len(canonicals) > 0 {
+			report.Canonical = canonicals[0]
+			if u, ok := resolveLink(page, canonicals[0]); ok {
+				report.Canonical = u.String()
+				report.CanonicalSelf = sameDocument(u, page)
+			}
+		}
+
+		for _, d := range slices.Concat(report.Robots, report.XRobotsTag) {
+			// header directives may name the crawler they apply to
+			if _, after, ok := strings.Cut(d, ":"); ok && !strings.HasPrefix(d, "unavailable_after") && !strings.HasPrefix(d, "max-") {
+				d = strings.TrimSpace(after)
+			}
+			switch d {
+			case "noindex":
+				report.NoIndex = true
+			case "nofollow":
+				report.NoFollow = true
+			case "none":
+				report.NoIndex, report.NoFollow = true, true
+			}
+		}
+
+		report.Warnings = seoWarnings(report, len(descriptions), canonicals, page)
+		return report, nil
+	}
+}
+
+// seoWarnings checks report. descriptions counts the description meta tags
+// and canonicals holds the hrefs of all canonical links.
+func seoWarnings(report *SEOReport, descriptions int, canonicals []string, page *url.URL) []SEOWarning {
+	var warnings []SEOWarning
+	warn := func(code, format string, args ...any) {
+		warnings = append(warnings, SEOWarning{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case report.TitleLength == 0:
+		warn(SEOTitleMissing, "The page has no title")
+	case report.TitleLength < minTitleLength:
+		warn(SEOTitleShort, "The title is %d characters long, aim for at least %d", report.TitleLength, minTitleLength)
+	case report.TitleLength > maxTitleLength:
+		warn(SEOTitleLong, "The title is %d characters long, search results cut it off after about %d", report.TitleLength, maxTitleLength)
+	}
+
+	switch {
+	case report.DescriptionLength == 0:
+		warn(SEODescriptionMissing, "The page has no meta description")
+	case report.DescriptionLength < minDescriptionLength:
+		warn(SEODescriptionShort, "The meta description is %d characters long, aim for at least %d", report.DescriptionLength, minDescriptionLength)
+	case report.DescriptionLength > maxDescriptionLength:
+		warn(SEODescriptionLong, "The meta description is %d characters long, search results cut it off after about %d", report.DescriptionLength, maxDescriptionLength)
+	}
+	if descriptions > 1 {
+		warn(SEODescriptionMultiple, "The page has %d meta descriptions, only the first one is used", descriptions)
+	}
+
+	switch {
+	case len(canonicals) == 0:
+		warn(SEOCanonicalMissing, "The page has no canonical link")
+	case len(canonicals) > 1:
+		warn(SEOCanonicalMultiple, "The page has %d canonical links, search engines may ignore all of them", len(canonicals))
+	}
+	if len(canonicals) > 0 {
+		if u, ok := resolveLink(page, canonicals[0]); !ok || (u.Scheme != "http" && u.Scheme != "https") {
+			warn(SEOCanonicalInvalid, "The canonical link %q is not an http(s) URL", canonicals[0])
+		}
+	}
+
+	if report.NoIndex {
+		warn(SEONoIndex, "Robots directives keep search engines from indexing the page")
+	}
+
+	if report.OpenGraph != nil {
+		var missing []string
+		for _, p := range openGraphRequired {
+			if report.OpenGraph[p] == "" {
+				missing = append(missing, p)
+			}
+		}
+		if len(missing) > 0 {
+			warn(SEOOpenGraphIncomplete, "OpenGraph tags are missing %s", strings.Join(missing, ", "))
+		}
+	}
+	if report.Twitter != nil && report.Twitter["twitter:card"] == "" {
+		warn(SEOTwitterCardMissing, "Twitter tags are present without twitter:card")
+	}
+
+	langs := make(map[string]bool)
+	for _, h := range report.Hreflang {
+		lang := strings.ToLower(h.Lang)
+		switch {
+		case !hreflangCode.MatchString(lang):
+			warn(SEOHreflangInvalid, "%q is not a valid hreflang language code", h.Lang)
+		case langs[lang]:
+			warn(SEOHreflangDuplicate, "More than one alternate is given for hreflang %q", h.Lang)
+		}
+		langs[lang] = true
+	}
+
+	switch {
+	case len(report.H1) == 0:
+		warn(SEOH1Missing, "The page has no h1 heading")
+	case len(report.H1) > 1:
+		warn(SEOH1Multiple, "The page has %d h1 headings, it should have one", len(report.H1))
+	}
+	seen := make(map[string]bool)
+	for _, h := range report.H1 {
+		key := strings.ToLower(h)
+		if seen[key] {
+			warn(SEOH1DuplicateText, "The h1 heading %q appears more than once", h)
+			break
+		}
+		seen[key] = true
+	}
+
+	return warnings
+}
+
+// robotsDirectives splits the value of a robots meta tag or X-Robots-Tag
+// header into lower case directives.
+func robotsDirectives(value string) []string {
+	var directives []string
+	for _, d := range strings.Split(value, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			directives = append(directives, d)
+		}
+	}
+	return directives
+}
+
+// sameDocument reports whether a and b address the same document, ignoring
+// fragments, the case of the host and a trailing slash on an empty path.
+func sameDocument(a, b *url.URL) bool {
+	path := func(u *url.URL) string {
+		if u.Path == "" {
+			return "/"
+		}
+		return u.Path
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) &&
+		path(a) == path(b) && a.RawQuery == b.RawQuery
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
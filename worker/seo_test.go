@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"urltracker/internal"
+)
+
+// analyzeSEO serves page with the given X-Robots-Tag and returns the report
+// of the seo analyzer.
+func analyzeSEO(t *testing.T, xRobotsTag, page string) (*SEOReport, string) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if xRobotsTag != "" {
+			w.Header().Set("X-Robots-Tag", xRobotsTag)
+		}
+		fmt.Fprint(w, strings.ReplaceAll(page, "{{.}}", "http://"+r.Host))
+	}))
+	t.Cleanup(srv.Close)
+
+	result, _, err := NewCrawler(crawlerConfig{}).Analyze(context.Background(), srv.URL+"/page", []string{internal.AnalyzerSEO})
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	report, ok := result.Analyzers[internal.AnalyzerSEO].Data.(*SEOReport)
+	if !ok {
+		t.Fatalf("seo output = %#v, want a report", result.Analyzers[internal.AnalyzerSEO])
+	}
+	return report, srv.URL
+}
+
+func warningCodes(report *SEOReport) []string {
+	var codes []string
+	for _, w := range report.Warnings {
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func TestSEOAnalyzer(t *testing.T) {
+	report, base := analyzeSEO(t, "", `<!DOCTYPE html><html><head>
+		<title>  Widgets and gadgets for every occasion | Example  </title>
+		<meta name="description" content="Find the right widget or gadget for your home, garden or office, with free delivery on all orders over fifty euros.">
+		<link rel="canonical" href="/page">
+		<meta name="robots" content="index, follow">
+		<meta property="og:title" content="Widgets"><meta property="og:type" content="website">
+		<meta property="og:image" content="{{.}}/widget.png"><meta property="og:url" content="{{.}}/page">
+		<meta name="twitter:card" content="summary_large_image">
+		<link rel="alternate" hreflang="en" href="/page"><link rel="alternate" hreflang="de-AT" href="/de/page">
+		<link rel="alternate" hreflang="x-default" href="/page">
+		</head><body><h1>Widgets</h1></body></html>`)
+
+	if report.Title != "Widgets and gadgets for every occasion | Example" || report.TitleLength != 48 {
+		t.Errorf("Title = %q (%d), want it trimmed", report.Title, report.TitleLength)
+	}
+	if report.Canonical != base+"/page" || !report.CanonicalSelf {
+		t.Errorf("Canonical = %q, self %v, want the page itself", report.Canonical, report.CanonicalSelf)
+	}
+	if report.NoIndex || report.NoFollow || !slices.Equal(report.Robots, []string{"index", "follow"}) {
+		t.Errorf("Robots = %v, noindex %v, nofollow %v", report.Robots, report.NoIndex, report.NoFollow)
+	}
+	if len(report.OpenGraph) != 4 || report.Twitter["twitter:card"] != "summary_large_image" {
+		t.Errorf("OpenGraph = %v, Twitter = %v", report.OpenGraph, report.Twitter)
+	}
+	if len(report.Hreflang) != 3 || report.Hreflang[1] != (Hreflang{Lang: "de-AT", URL: base + "/de/page"}) {
+		t.Errorf("Hreflang = %+v", report.Hreflang)
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("Warnings = %+v, want none", report.Warnings)
+	}
+}
+
+func TestSEOAnalyzerWarnings(t *testing.T) {
+	report, _ := analyzeSEO(t, "googlebot: noindex, nofollow", `<html><head>
+		<title>Home</title>
+		<meta name="Description" content="`+strings.Repeat("Far too long. ", 15)+`">
+		<meta name="description" content="Another one">
+		<link rel="canonical" href="https://example.com/a"><link rel="canonical" href="https://example.com/b">
+		<meta property="og:title" content="Home">
+		<meta name="twitter:title" content="Home">
+		<link rel="alternate" hreflang="english" href="/en"><link rel="alternate" hreflang="fr" href="/fr"><link rel="alternate" hreflang="FR" href="/fr2">
+		</head><body><h1>Home</h1><h1> home </h1></body></html>`)
+
+	want := []string{
+		SEOTitleShort, SEODescriptionLong, SEODescriptionMultiple, SEOCanonicalMultiple, SEONoIndex,
+		SEOOpenGraphIncomplete, SEOTwitterCardMissing, SEOHreflangInvalid, SEOHreflangDuplicate, SEOH1Multiple, SEOH1DuplicateText,
+	}
+	if got := warningCodes(report); !slices.Equal(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+	if !report.NoIndex || !report.NoFollow || !slices.Equal(report.XRobotsTag, []string{"googlebot: noindex", "nofollow"}) {
+		t.Errorf("XRobotsTag = %v, noindex %v, nofollow %v", report.XRobotsTag, report.NoIndex, report.NoFollow)
+	}
+	if report.CanonicalSelf {
+		t.Error("CanonicalSelf = true for a canonical elsewhere")
+	}
+}
+
+func TestSEOAnalyzerMissing(t *testing.T) {
+	report, _ := analyzeSEO(t, "", `<html><head></head><body><p>bare</p></body></html>`)
+
+	want := []string{SEOTitleMissing, SEODescriptionMissing, SEOCanonicalMissing, SEOH1Missing}
+	if got := warningCodes(report); !slices.Equal(got, want) {
+		t.Errorf("warnings = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+	"urltracker/internal/urlnorm"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxSitemapSize caps a sitemap after decompression, like the 50 MiB
+	// limit of the sitemaps protocol.
+	maxSitemapSize = 50 << 20
+	// maxSitemapFiles bounds how many sitemaps one submission reads,
+	// following index files.
+	maxSitemapFiles = 100
+)
+
+// ErrNoSitemap is returned when none of the sitemaps of a submission could be
+// read.
+var ErrNoSitemap = errors.New("no sitemap could be read")
+
+// SitemapReport is the result of a sitemap submission. The trackers it
+// queued are reported on through the batch.
+type SitemapReport struct {
+	// Sitemaps lists the sitemaps that were read and Failed those that
+	// could not be.
+	Sitemaps []string         `json:"sitemaps"`
+	Failed   []SitemapFailure `json:"failed,omitempty"`
+	// URLs counts the distinct pages listed, of which Queued got a new
+	// tracker and Existing were tracked already. Skipped counts pages over
+	// the submission's limit, entries that are not http(s) URLs and pages
+	// on another host than the sitemap listing them.
+	URLs     int `json:"urls"`
+	Queued   int `json:"queued"`
+	Existing int `json:"existing"`
+	Skipped  int `json:"skipped,omitempty"`
+}
+
+// SitemapFailure is a sitemap that could not be read. StatusCode is 0 if no
+// response came back.
+type SitemapFailure struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error"`
+}
+
+// sitemapDoc is either a <urlset> of pages or a <sitemapindex> of further
+// sitemaps.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapCollector turns sitemap submissions into a batch of trackers.
+type sitemapCollector struct {
+	store  storage.Store
+	client *http.Client
+	limits cache.HostLimits
+	robots *robotsChecker
+	logger *log.Logger
+}
+
+// crawl is the CrawlerFunc of sitemap submissions. It reads the sitemaps of
+// the tracker's URL and queues a bulk tracker for each listed page.
+func (c *sitemapCollector) crawl(ctx context.Context, t *cache.URLTracker) (string, error) {
+	maxURLs := cache.DefaultSitemapURLs
+	if t.Sitemap != nil {
+		maxURLs = t.Sitemap.MaxURLs
+	}
+
+	queue, err := c.discover(ctx, t.URL)
+	if err != nil {
+		return "", err
+	}
+
+	// the job already waited for its own host before the first request
+	paid := hostOf(t.URL)
+
+	report := &SitemapReport{Sitemaps: []string{}}
+	var pages []string
+	seen := make(map[string]bool)
+	read := make(map[string]bool)
+	var lastErr error
+	for len(queue) > 0 && len(read) < maxSitemapFiles {
+		loc := queue[0]
+		queue = queue[1:]
+		if read[loc] {
+			continue
+		}
+		read[loc] = true
+
+		if hostOf(loc) == paid {
+			paid = ""
+		} else if err := c.throttle(ctx, loc); err != nil {
+			return "", err
+		}
+
+		doc, err := c.fetch(ctx, loc)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			lastErr = err
+			f := SitemapFailure{URL: loc, Error: err.Error()}
+			var ce *crawlError
+			if errors.As(err, &ce) {
+				f.StatusCode, f.Error = ce.StatusCode, ce.Err.Error()
+			}
+			report.Failed = append(report.Failed, f)
+			continue
+		}
+		report.Sitemaps = append(report.Sitemaps, loc)
+
+		for _, s := range doc.Sitemaps {
+			if s := strings.TrimSpace(s.Loc); s != "" {
+				queue = append(queue, s)
+			}
+		}
+		// the sitemaps protocol only lets a sitemap list pages of its own
+		// host
+		host := canonicalHost(loc)
+		for _, u := range doc.URLs {
+			key, err := urlnorm.Canonicalize(strings.TrimSpace(u.Loc))
+			if err != nil || !isHTTP(key) || canonicalHost(key) != host {
+				report.Skipped++
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			report.URLs++
+			if len(pages) == maxURLs {
+				report.Skipped++
+				continue
+			}
+			pages = append(pages, strings.TrimSpace(u.Loc))
+		}
+	}
+	if len(report.Sitemaps) == 0 {
+		if lastErr == nil {
+			lastErr = ErrNoSitemap
+		}
+		return "", fmt.Errorf("%w: %w", ErrNoSitemap, lastErr)
+	}
+
+	ids := make([]string, 0, len(pages))
+	for _, page := range pages {
+		tracker, created, err := c.track(ctx, page, t.Analyzers)
+		if err != nil {
+			return "", fmt.Errorf("queue %s: %w", page, err)
+		}
+		if created {
+			report.Queued++
+		} else {
+			report.Existing++
+		}
+		ids = append(ids, tracker.ID)
+	}
+	if err := c.store.AddToBatch(ctx, t.ID, ids...); err != nil {
+		return "", fmt.Errorf("group batch: %w", err)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %w", err)
+	}
+	return string(data), nil
+}
+
+// discover returns the sitemaps to start from: rawURL itself if it looks
+// like a sitemap, otherwise those robots.txt of its origin lists or, failing
+// that, /sitemap.xml.
+func (c *sitemapCollector) discover(ctx context.Context, rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", rawURL)
+	}
+	path := strings.ToLower(u.Path)
+	if strings.HasSuffix(path, ".xml") || strings.HasSuffix(path, ".xml.gz") {
+		return []string{rawURL}, nil
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	if c.robots != nil {
+		data, err := c.robots.load(ctx, origin)
+		if err != nil {
+			c.logger.Println("robots.txt:", err)
+		} else if len(data.Sitemaps) > 0 {
+			return data.Sitemaps, nil
+		}
+	}
+	return []string{origin + "/sitemap.xml"}, nil
+}
+
+// throttle waits until the sitemap at loc may be fetched under the limit of
+// its host. Errors of the store are logged and ignored.
+func (c *sitemapCollector) throttle(ctx context.Context, loc string) error {
+	host := hostOf(loc)
+	if host == "" || c.store == nil {
+		return nil
+	}
+
+	limit := c.limits.For(host)
+	if c.robots != nil && c.robots.policy == RobotsObey {
+		// robots.txt cannot block a sitemap, but its Crawl-delay holds
+		verdict, err := c.robots.check(ctx, loc)
+		if err != nil {
+			c.logger.Println("robots.txt:", err)
+		}
+		limit = politeLimit(limit, verdict.crawlDelay)
+	}
+	// the job holds a slot of its own host, so only the rate applies
+	limit.Concurrency = 0
+
+	err := waitForHost(ctx, c.store, host, "", limit)
+	if err != nil && ctx.Err() == nil {
+		c.logger.Println("host limit:", err)
+		return nil
+	}
+	return err
+}
+
+// fetch reads and parses the sitemap at loc, decompressing it if it is
+// gzipped.
+func (c *sitemapCollector) fetch(ctx context.Context, loc string) (*sitemapDoc, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, &crawlError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &crawlError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Err:        errors.New(http.StatusText(resp.StatusCode)),
+		}
+	}
+
+	// trust the content over the file name and headers, gzipped sitemaps
+	// are served with all sorts of content types
+	body := bufio.NewReader(resp.Body)
+	var r io.Reader = body
+	if magic, _ := body.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("decompress: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(io.LimitReader(r, maxSitemapSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	switch doc.XMLName.Local {
+	case "urlset", "sitemapindex":
+		return &doc, nil
+	}
+	return nil, fmt.Errorf("parse: <%s> is not a sitemap", doc.XMLName.Local)
+}
+
+// track returns the tracker of page, storing and queueing a new one with the
+// given analyzers in the bulk lane if the page is not tracked yet. The
+// boolean reports whether it was created.
+func (c *sitemapCollector) track(ctx context.Context, page string, analyzers []string) (*cache.URLTracker, bool, error) {
+	canonical, err := urlnorm.Canonicalize(page)
+	if err != nil {
+		return nil, false, err
+	}
+	existing, err := c.store.FindByCanonical(ctx, canonical)
+	if err != nil || existing != nil {
+		return existing, false, err
+	}
+
+	tracker := &cache.URLTracker{
+		ID:           uuid.New().String(),
+		URL:          page,
+		CanonicalURL: canonical,
+		Status:       internal.StatusPending,
+		Priority:     internal.PriorityBulk,
+		Analyzers:    analyzers,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	err = c.store.StoreURL(ctx, tracker)
+	if errors.Is(err, cache.ErrDuplicateURL) {
+		// tracked in the meantime
+		existing, err = c.store.FindByCanonical(ctx, canonical)
+		if err == nil && existing == nil {
+			err = cache.ErrDuplicateURL
+		}
+		return existing, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return tracker, true, nil
+}
+
+// canonicalHost returns the host, with a port other than the default, of the
+// canonical form of rawURL, or "" if it has none.
+func canonicalHost(rawURL string) string {
+	key, err := urlnorm.Canonicalize(rawURL)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(key)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// isHTTP reports whether rawURL is an http or https URL.
+func isHTTP(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+}
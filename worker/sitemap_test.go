@@ -0,0 +1,253 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"urltracker/internal"
+	"urltracker/internal/cache"
+	"urltracker/internal/storage"
+	"urltracker/internal/urlnorm"
+)
+
+// testSitemapSite serves a site whose robots.txt points at a sitemap index
+// of a plain sitemap, a gzipped one that repeats a page and one that is
+// missing.
+func testSitemapSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/robots.txt":
+			body = "User-agent: *\nDisallow: /\nSitemap: {{.}}/sitemap_index.xml\n"
+		case "/sitemap_index.xml":
+			body = `<?xml version="1.0"?><sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>{{.}}/pages.xml</loc></sitemap>
+<sitemap><loc>{{.}}/more.xml.gz</loc></sitemap>
+<sitemap><loc>{{.}}/gone.xml</loc></sitemap>
+<sitemap><loc>{{.}}/pages.xml</loc></sitemap>
+</sitemapindex>`
+		case "/pages.xml":
+			body = `<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>{{.}}/a</loc></url><url><loc> {{.}}/b </loc></url></urlset>`
+		case "/more.xml.gz":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			zw := gzip.NewWriter(w)
+			fmt.Fprintf(zw, `<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%[1]s/c</loc></url><url><loc>%[1]s/a</loc></url><url><loc>ftp://example.com/file</loc></url></urlset>`, srv.URL)
+			zw.Close()
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, strings.ReplaceAll(body, "{{.}}", srv.URL))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestSitemapCollector(store storage.Store) *sitemapCollector {
+	return &sitemapCollector{
+		store:  store,
+		client: http.DefaultClient,
+		robots: &robotsChecker{policy: RobotsObey, store: store, client: http.DefaultClient, ttl: time.Hour},
+		logger: log.New(io.Discard, "", 0),
+	}
+}
+
+func storeSitemapTracker(t *testing.T, store storage.Store, url string, maxURLs int) *cache.URLTracker {
+	t.Helper()
+
+	tracker := &cache.URLTracker{ID: "sitemap", URL: url, Status: internal.StatusPending, Sitemap: &cache.SitemapBatch{MaxURLs: maxURLs}}
+	if err := store.StoreURL(context.Background(), tracker); err != nil {
+		t.Fatalf("StoreURL() error = %v", err)
+	}
+	return tracker
+}
+
+func runSitemap(t *testing.T, c *sitemapCollector, tracker *cache.URLTracker) *SitemapReport {
+	t.Helper()
+
+	data, err := c.crawl(context.Background(), tracker)
+	if err != nil {
+		t.Fatalf("crawl() error = %v", err)
+	}
+	var report SitemapReport
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		t.Fatalf("report %s: %v", data, err)
+	}
+	return &report
+}
+
+func TestSitemapCollector(t *testing.T) {
+	srv := testSitemapSite(t)
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+
+	canonical, _ := urlnorm.Canonicalize(srv.URL + "/a")
+	known := &cache.URLTracker{ID: "known", URL: srv.URL + "/a", CanonicalURL: canonical, Status: internal.StatusCompleted}
+	if err := store.StoreURL(ctx, known); err != nil {
+		t.Fatal(err)
+	}
+	tracker := storeSitemapTracker(t, store, srv.URL+"/", 10)
+
+	report := runSitemap(t, newTestSitemapCollector(store), tracker)
+
+	wantSitemaps := []string{srv.URL + "/sitemap_index.xml", srv.URL + "/pages.xml", srv.URL + "/more.xml.gz"}
+	if fmt.Sprint(report.Sitemaps) != fmt.Sprint(wantSitemaps) {
+		t.Errorf("Sitemaps = %v, want %v", report.Sitemaps, wantSitemaps)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].URL != srv.URL+"/gone.xml" || report.Failed[0].StatusCode != http.StatusNotFound {
+		t.Errorf("Failed = %+v, want gone.xml with 404", report.Failed)
+	}
+	if report.URLs != 3 || report.Queued != 2 || report.Existing != 1 || report.Skipped != 1 {
+		t.Errorf("report = %+v, want 3 URLs, 2 queued, 1 existing, 1 skipped", report)
+	}
+
+	batch, err := store.GetBatch(ctx, tracker.ID)
+	if err != nil {
+		t.Fatalf("GetBatch() error = %v", err)
+	}
+	var urls []string
+	for _, child := range batch {
+		urls = append(urls, strings.TrimPrefix(child.URL, srv.URL))
+		if child.ID != "known" && (child.Priority != internal.PriorityBulk || child.Status != internal.StatusPending) {
+			t.Errorf("child %s = %+v, want a pending bulk tracker", child.URL, child)
+		}
+	}
+	if len(urls) != 3 || !strings.Contains(fmt.Sprint(urls), "/a") || !strings.Contains(fmt.Sprint(urls), "/b") || !strings.Contains(fmt.Sprint(urls), "/c") {
+		t.Errorf("batch = %v, want /a, /b and /c", urls)
+	}
+
+	stats, err := store.QueueStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.Lanes[internal.PriorityBulk]; got != 2 {
+		t.Errorf("bulk queue depth = %d, want 2", got)
+	}
+}
+
+func TestSitemapCollectorLimit(t *testing.T) {
+	srv := testSitemapSite(t)
+	store := storage.NewMemoryStore()
+	tracker := storeSitemapTracker(t, store, srv.URL+"/pages.xml", 1)
+
+	report := runSitemap(t, newTestSitemapCollector(store), tracker)
+
+	// a sitemap URL is read as is, without asking robots.txt
+	if fmt.Sprint(report.Sitemaps) != fmt.Sprint([]string{srv.URL + "/pages.xml"}) {
+		t.Errorf("Sitemaps = %v, want only pages.xml", report.Sitemaps)
+	}
+	if report.URLs != 2 || report.Queued != 1 || report.Skipped != 1 {
+		t.Errorf("report = %+v, want 1 of 2 URLs queued", report)
+	}
+}
+
+func TestSitemapCollectorOtherHosts(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><urlset><url><loc>https://elsewhere.example/x</loc></url></urlset>`)
+	}))
+	t.Cleanup(other.Close)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.xml":
+			fmt.Fprintf(w, `<?xml version="1.0"?><sitemapindex><sitemap><loc>%s/pages.xml</loc></sitemap><sitemap><loc>%s/cdn.xml</loc></sitemap></sitemapindex>`, srv.URL, other.URL)
+		case "/pages.xml":
+			fmt.Fprintf(w, `<?xml version="1.0"?><urlset><url><loc>%s/a</loc></url><url><loc>%s/b</loc></url><url><loc>https://elsewhere.example/c</loc></url></urlset>`, srv.URL, other.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	store := storage.NewMemoryStore()
+	tracker := storeSitemapTracker(t, store, srv.URL+"/index.xml", 10)
+
+	report := runSitemap(t, newTestSitemapCollector(store), tracker)
+
+	// a sitemap on another host may be listed in an index, but every sitemap
+	// only vouches for pages of its own host
+	if len(report.Sitemaps) != 3 {
+		t.Errorf("Sitemaps = %v, want the index and both sitemaps", report.Sitemaps)
+	}
+	if report.URLs != 1 || report.Queued != 1 || report.Skipped != 3 {
+		t.Errorf("report = %+v, want 1 URL queued and 3 on other hosts skipped", report)
+	}
+}
+
+func TestSitemapCollectorThrottled(t *testing.T) {
+	srv := testSitemapSite(t)
+	store := storage.NewMemoryStore()
+	tracker := storeSitemapTracker(t, store, srv.URL+"/", 10)
+	c := newTestSitemapCollector(store)
+	c.limits = cache.HostLimits{Default: cache.HostLimit{Rate: 20, Burst: 1, Concurrency: 1}}
+
+	started := time.Now()
+	report := runSitemap(t, c, tracker)
+
+	// the index is paid for by the job, the burst covers one more fetch
+	// and the other two wait 50ms each
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("reading %d sitemaps took %v, want the fetches spaced out", len(report.Sitemaps)+len(report.Failed), elapsed)
+	}
+}
+
+func TestSitemapCollectorFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	store := storage.NewMemoryStore()
+	tracker := storeSitemapTracker(t, store, srv.URL+"/", 10)
+
+	// without robots.txt the collector falls back to /sitemap.xml and its
+	// status decides whether the job is retried
+	_, err := newTestSitemapCollector(store).crawl(context.Background(), tracker)
+	if !errors.Is(err, ErrNoSitemap) {
+		t.Fatalf("crawl() error = %v, want %v", err, ErrNoSitemap)
+	}
+	if ok, _ := retryable(err); !ok {
+		t.Errorf("crawl() error = %v, want it retryable", err)
+	}
+}
+
+func TestProcessNextSitemap(t *testing.T) {
+	srv := testSitemapSite(t)
+	store := storage.NewMemoryStore()
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+	tracker := storeSitemapTracker(t, store, srv.URL+"/", 10)
+
+	crawl := func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return `{"title":"page"}`, nil
+	}
+	// robots.txt disallows everything, yet the sitemaps are read
+	c := newTestSitemapCollector(store)
+	opts := jobOptions{robots: c.robots, sitemaps: c}
+	if _, err := processNext(ctx, ctx, store, crawl, opts, logger); err != nil {
+		t.Fatalf("processNext() error = %v", err)
+	}
+
+	got, _ := store.GetURL(ctx, tracker.ID)
+	if got.Status != internal.StatusCompleted || !strings.Contains(got.Result, `"queued":3`) {
+		t.Errorf("sitemap tracker = %+v, want a report of 3 queued pages", got)
+	}
+}
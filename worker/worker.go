@@ -2,93 +2,625 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"urltracker/internal"
 	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 )
 
-type RedisStore interface {
-	DequeueURL(ctx context.Context) (*cache.URLTracker, error)
-	UpdateURL(ctx context.Context, t *cache.URLTracker) error
-}
-
-type CrawlerFunc func(t *cache.URLTracker) (string, error)
+// CrawlerFunc analyses the page of a tracker and returns the result as JSON.
+// It must give up once ctx is done.
+type CrawlerFunc func(ctx context.Context, t *cache.URLTracker) (string, error)
 
 func main() {
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "redis:6379"
-	}
 	interval := 5 * time.Second
 
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Consumer = os.Getenv("WORKER_ID")
+	if cfg.Consumer == "" {
+		cfg.Consumer, _ = os.Hostname()
+	}
+	cfg.Lease = 5 * time.Minute
+	if v := os.Getenv("WORKER_LEASE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid WORKER_LEASE_TIMEOUT %q: %v", v, err)
+		}
+		cfg.Lease = d
+	}
+	cfg.BlockTimeout = 5 * time.Second
+	if v := os.Getenv("WORKER_BLOCK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid WORKER_BLOCK_TIMEOUT %q", v)
+		}
+		cfg.BlockTimeout = d
+	}
+	if v := os.Getenv("RUN_RETENTION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid RUN_RETENTION %q", v)
+		}
+		cfg.RunRetention = n
+	}
+
+	concurrency := 4
+	if v := os.Getenv("WORKER_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid WORKER_CONCURRENCY %q", v)
+		}
+		concurrency = n
+	}
+	// the lease must outlast a job even if the heartbeat stops renewing it
+	jobTimeout := cfg.Lease * 4 / 5
+	if v := os.Getenv("WORKER_JOB_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid WORKER_JOB_TIMEOUT %q: %v", v, err)
+		}
+		jobTimeout = d
+	}
+	retry := retryPolicy{maxAttempts: 3, baseDelay: 30 * time.Second, maxDelay: 30 * time.Minute}
+	if v := os.Getenv("WORKER_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid WORKER_MAX_ATTEMPTS %q", v)
+		}
+		retry.maxAttempts = n
+	}
+	for name, dst := range map[string]*time.Duration{
+		"WORKER_RETRY_BASE_DELAY": &retry.baseDelay,
+		"WORKER_RETRY_MAX_DELAY":  &retry.maxDelay,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("invalid %s %q: %v", name, v, err)
+			}
+			*dst = d
+		}
+	}
+	def := cache.DefaultHostLimit
+	if v := os.Getenv("HOST_RATE"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Fatalf("invalid HOST_RATE %q", v)
+		}
+		def.Rate = f
+	}
+	for name, dst := range map[string]*int{
+		"HOST_BURST":       &def.Burst,
+		"HOST_CONCURRENCY": &def.Concurrency,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatalf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	limits, err := cache.ParseHostLimits(os.Getenv("HOST_LIMITS"), def)
+	if err != nil {
+		log.Fatal(err)
+	}
+	robotsPolicy := RobotsObey
+	if v := os.Getenv("ROBOTS_POLICY"); v != "" {
+		if !isValidRobotsPolicy(v) {
+			log.Fatalf("invalid ROBOTS_POLICY %q", v)
+		}
+		robotsPolicy = v
+	}
+	robotsTTL := 24 * time.Hour
+	if v := os.Getenv("ROBOTS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid ROBOTS_CACHE_TTL %q", v)
+		}
+		robotsTTL = d
+	}
+	crawlCfg := defaultCrawlerConfig
+	for name, dst := range map[string]*time.Duration{
+		"CRAWL_CONNECT_TIMEOUT": &crawlCfg.ConnectTimeout,
+		"CRAWL_TLS_TIMEOUT":     &crawlCfg.TLSTimeout,
+		"CRAWL_TIMEOUT":         &crawlCfg.Timeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s %q", name, v)
+			}
+			*dst = d
+		}
+	}
+	if v := os.Getenv("CRAWL_MAX_BODY_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid CRAWL_MAX_BODY_SIZE %q", v)
+		}
+		crawlCfg.MaxBodySize = n
+	}
+	linkConcurrency := 8
+	if v := os.Getenv("LINK_CHECK_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid LINK_CHECK_CONCURRENCY %q", v)
+		}
+		linkConcurrency = n
+	}
+	linkTimeout, linkTTL := 10*time.Second, time.Hour
+	for name, dst := range map[string]*time.Duration{
+		"LINK_CHECK_TIMEOUT": &linkTimeout,
+		"LINK_CACHE_TTL":     &linkTTL,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s %q", name, v)
+			}
+			*dst = d
+		}
+	}
+	drainTimeout := 20 * time.Second
+	if v := os.Getenv("WORKER_DRAIN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid WORKER_DRAIN_TIMEOUT %q", v)
+		}
+		drainTimeout = d
+	}
+	flag.IntVar(&concurrency, "concurrency", concurrency, "number of jobs processed in parallel (WORKER_CONCURRENCY)")
+	flag.DurationVar(&jobTimeout, "job-timeout", jobTimeout, "time limit of a single job (WORKER_JOB_TIMEOUT)")
+	flag.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "how long jobs in flight may finish on shutdown (WORKER_DRAIN_TIMEOUT)")
+	flag.Parse()
+	if concurrency < 1 {
+		log.Fatalf("invalid concurrency %d", concurrency)
+	}
+	if jobTimeout >= cfg.Lease {
+		log.Fatalf("job timeout %s must be shorter than the lease %s (WORKER_LEASE_TIMEOUT)", jobTimeout, cfg.Lease)
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	r := cache.NewRedisClient(redisAddr)
+	r, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
 	logger := log.New(os.Stdout, "[worker] ", log.LstdFlags)
+	crawler := NewCrawler(crawlCfg)
+	robots := &robotsChecker{
+		policy: robotsPolicy,
+		store:  r,
+		client: crawler.Client(),
+		ttl:    robotsTTL,
+	}
+	if linkConcurrency > 0 {
+		crawler.CheckLinks(r, limits, robots, linkConcurrency, linkTimeout, linkTTL)
+	}
 
-	logger.Println("worker starting, redis:", redisAddr)
+	logger.Println("worker starting, storage:", cfg.Describe(), "id:", cfg.Consumer, "concurrency:", concurrency, "robots:", robotsPolicy)
 
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Println("shutting down")
-			return
-		default:
-			for {
-				processed, err := processNext(ctx, r, CrawlURL, logger)
-				if err != nil {
-					logger.Println("dequeue error:", err)
-					break
-				}
-				if !processed {
-					// queue empty
-					break
-				}
-			}
+	p := &pool{
+		store:        r,
+		crawl:        crawler.CrawlURL,
+		logger:       logger,
+		concurrency:  concurrency,
+		jobTimeout:   jobTimeout,
+		interval:     interval,
+		blocking:     cfg.BlockTimeout > 0,
+		drainTimeout: drainTimeout,
+		opts: jobOptions{
+			retry:     retry,
+			limits:    limits,
+			robots:    robots,
+			heartbeat: cfg.Lease / 3,
+			audit: &siteAuditor{
+				analyze: crawler.Analyze,
+				store:   r,
+				limits:  limits,
+				robots:  robots,
+				logger:  logger,
+			},
+			sitemaps: &sitemapCollector{
+				store:  r,
+				client: crawler.Client(),
+				limits: limits,
+				robots: robots,
+				logger: logger,
+			},
+		},
+	}
+	p.run(ctx)
+
+	logger.Println("shutting down")
+}
+
+// jobOptions controls what processNext does around the crawl itself.
+type jobOptions struct {
+	retry  retryPolicy
+	limits cache.HostLimits
+	robots *robotsChecker
+	// audit crawls the trackers submitted as site audits. Without it they
+	// are crawled like single pages.
+	audit *siteAuditor
+	// sitemaps reads the sitemaps of sitemap submissions and queues their
+	// pages. Without it they are crawled like single pages.
+	sitemaps *sitemapCollector
+	// requeued is told about jobs whose crawl was interrupted and that were
+	// put back on the queue.
+	requeued func(id string)
+	// heartbeat is how often the lease of a job is extended while it is
+	// crawled. Zero leaves the lease alone.
+	heartbeat time.Duration
+	// timeout bounds each job. Zero leaves it to the crawl context.
+	timeout time.Duration
+}
+
+// processNext takes one job off the queue and processes it. Waiting for the
+// job stops when ctx is done, the job itself runs on crawlCtx, limited to
+// opts.timeout. It reports false if the queue was empty.
+func processNext(ctx, crawlCtx context.Context, store storage.Store, crawl CrawlerFunc, opts jobOptions, logger *log.Logger) (bool, error) {
+	tracker, err := store.DequeueURL(ctx)
+	if err != nil || tracker == nil {
+		return false, err
+	}
 
-			time.Sleep(interval)
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		crawlCtx, cancel = context.WithTimeout(crawlCtx, opts.timeout)
+		defer cancel()
+	}
+	processJob(crawlCtx, store, tracker, crawl, opts, logger)
+	return true, nil
+}
+
+// keepLeased extends the lease of the job id every interval until the
+// returned function is called, so a crawl that runs longer than one lease is
+// not handed to another worker. It gives up once the lease is lost.
+func keepLeased(ctx context.Context, store storage.Queue, id string, interval time.Duration, logger *log.Logger) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			err := store.ExtendLease(ctx, id)
+			if errors.Is(err, cache.ErrLeaseLost) {
+				logger.Println("lease of", id, "lost, it may be processed twice")
+				return
+			}
+			if err != nil {
+				logger.Println("extend lease:", err)
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
 	}
 }
 
-func processNext(ctx context.Context, store RedisStore, crawl CrawlerFunc, logger *log.Logger) (bool, error) {
-	tracker, err := store.DequeueURL(ctx)
+// processJob crawls a dequeued tracker and writes the outcome back.
+func processJob(ctx context.Context, store storage.Store, tracker *cache.URLTracker, crawl CrawlerFunc, opts jobOptions, logger *log.Logger) {
+	// Fetching stops once ctx is done, but whatever happened is still
+	// written back on a context that is not cancelled with it.
+	crawlCtx := ctx
+	ctx = context.WithoutCancel(ctx)
+
+	verdict, err := opts.robots.check(crawlCtx, tracker.URL)
 	if err != nil {
-		return false, err
+		logger.Println("robots.txt:", err)
 	}
-	if tracker == nil {
-		return false, nil
+	obey := opts.robots != nil && opts.robots.policy == RobotsObey
+	blocked := obey && !verdict.allowed
+	switch {
+	case tracker.Sitemap != nil && opts.sitemaps != nil:
+		// sitemaps are published for crawlers, robots.txt does not keep
+		// them from being read
+		crawl = opts.sitemaps.crawl
+		blocked = false
+	case blocked:
+		crawl = blockedByRobots
+	case tracker.Audit != nil && opts.audit != nil:
+		// the audit checks robots.txt for every page itself
+		crawl = opts.audit.crawl
+	case !verdict.allowed:
+		crawl = reportRobots(crawl)
 	}
 
-	tracker.Status = internal.StatusProcessing
-	tracker.UpdatedAt = time.Now()
-	if err := store.UpdateURL(ctx, tracker); err != nil {
+	host := hostOf(tracker.URL)
+	limit := opts.limits.For(host)
+	if obey {
+		limit = politeLimit(limit, verdict.crawlDelay)
+	}
+	// a blocked job never talks to the host, so it needs no slot
+	if host != "" && !blocked && !limit.Unlimited() {
+		// hold the slot for as long as the job may run at most
+		hold := defaultHostHold
+		if deadline, ok := crawlCtx.Deadline(); ok {
+			hold = time.Until(deadline)
+		}
+		wait, err := store.AcquireHost(ctx, host, tracker.ID, limit, hold)
+		if errors.Is(err, cache.ErrHostBusy) {
+			wait, err = hostBusyDelay, nil
+		}
+		if err != nil {
+			// Leave the job un-acked so its lease expires and it is retried.
+			logger.Println("host limit:", err)
+			return
+		}
+		if wait > 0 {
+			deferJob(ctx, store, tracker, wait, logger)
+			return
+		}
+		defer func() {
+			if err := store.ReleaseHost(ctx, host, tracker.ID); err != nil {
+				logger.Println("release host:", err)
+			}
+		}()
+	}
+
+	tracker, err = updateTracker(ctx, store, tracker, tracker.Status, func(t *cache.URLTracker) {
+		t.Status = internal.StatusProcessing
+		t.Attempts++
+	})
+	if errors.Is(err, errSuperseded) {
+		// someone else already decided what happens to this tracker
+		logger.Println("skipping", tracker.ID, "now", tracker.Status)
+		if err := store.AckURL(ctx, tracker.ID); err != nil {
+			logger.Println("ack:", err)
+		}
+		return
+	}
+	if err != nil {
 		logger.Println("update processing status:", err)
-		return true, nil
+		return
 	}
 
-	result, cErr := crawl(tracker)
+	started := time.Now()
+	stopHeartbeat := keepLeased(crawlCtx, store, tracker.ID, opts.heartbeat, logger)
+	result, cErr := crawl(crawlCtx, tracker)
+	stopHeartbeat()
+	finished := time.Now()
 
+	if cErr != nil && errors.Is(crawlCtx.Err(), context.Canceled) {
+		// the worker is shutting down and could not wait for the crawl
+		logger.Println("crawl of", tracker.ID, "interrupted")
+		if requeue(ctx, store, tracker, logger) && opts.requeued != nil {
+			opts.requeued(tracker.ID)
+		}
+		return
+	}
+
+	// the tracker mirrors the latest run, earlier ones live in the run history
+	status, errMsg := internal.StatusCompleted, ""
+	var retryAt time.Time
 	if cErr != nil {
-		tracker.Status = internal.StatusFailed
-		tracker.Error = cErr.Error()
-	} else {
-		tracker.Status = internal.StatusCompleted
-		tracker.Result = result
+		status, errMsg, result = internal.StatusFailed, cErr.Error(), ""
+		if delay, ok := opts.retry.next(tracker.Attempts, cErr); ok {
+			status, retryAt = internal.StatusRetrying, finished.Add(delay)
+		}
+	}
+
+	run := &cache.AnalysisRun{
+		StartedAt:  started,
+		FinishedAt: finished,
+		DurationMS: finished.Sub(started).Milliseconds(),
+		Status:     status,
+		Error:      errMsg,
+		Result:     result,
+		Attempt:    tracker.Attempts,
+		RetryAt:    retryAt,
 	}
-	tracker.UpdatedAt = time.Now()
 
-	if err := store.UpdateURL(ctx, tracker); err != nil {
+	tracker, err = updateTracker(ctx, store, tracker, internal.StatusProcessing, func(t *cache.URLTracker) {
+		t.Status = status
+		t.Error = errMsg
+		t.Result = result
+		if !retryAt.IsZero() {
+			t.NotBefore = retryAt
+		}
+	})
+	superseded := errors.Is(err, errSuperseded)
+	if superseded {
+		// keep the run for the history but leave the tracker as it is now
+		logger.Println("result of", tracker.ID, "superseded, tracker now", tracker.Status)
+	} else if err != nil {
+		// Leave the job un-acked so its lease expires and it is retried.
 		logger.Println("update final status:", err)
+		return
+	}
+
+	if err := store.AddRun(ctx, tracker.ID, run); err != nil {
+		logger.Println("record run:", err)
+	}
+
+	if status == internal.StatusRetrying && !superseded {
+		if err := store.ScheduleURL(ctx, tracker.ID, tracker.Priority, retryAt); err != nil {
+			// Leave the job un-acked so its lease expires and it is retried.
+			logger.Println("schedule retry:", err)
+			return
+		}
+	}
+
+	if status == internal.StatusFailed && !superseded {
+		dead := &cache.DeadLetter{
+			ID:        tracker.ID,
+			URL:       tracker.URL,
+			Reason:    cache.ReasonCrawlFailed,
+			Attempts:  tracker.Attempts,
+			LastError: errMsg,
+		}
+		if err := store.AddDeadLetter(ctx, dead); err != nil {
+			logger.Println("dead letter:", err)
+		}
+	}
+
+	if err := store.AckURL(ctx, tracker.ID); err != nil {
+		logger.Println("ack:", err)
+	}
+	if status == internal.StatusRetrying {
+		logger.Println("processed", tracker.ID, status, "attempt", tracker.Attempts, "next at", retryAt.Format(time.RFC3339))
 	} else {
-		logger.Println("processed", tracker.ID, tracker.Status)
+		logger.Println("processed", tracker.ID, status)
 	}
 
-	return true, nil
+}
+
+const (
+	// defaultHostHold bounds how long a job without a deadline keeps its
+	// host's concurrency slot if the worker dies before releasing it.
+	defaultHostHold = 5 * time.Minute
+	// hostBusyDelay is how long a job waits when its host has no free
+	// concurrency slot.
+	hostBusyDelay = 5 * time.Second
+)
+
+// hostOf returns the lower-cased host name of rawURL, without the port, or ""
+// if it has none.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// waitForHost blocks until job id may make another request to host under
+// limit, holding one of the host's concurrency slots if limit has any. It
+// returns ctx's error if ctx is done first and the store's if the limit
+// cannot be checked.
+func waitForHost(ctx context.Context, hosts storage.Hosts, host, id string, limit cache.HostLimit) error {
+	if limit.Unlimited() {
+		return nil
+	}
+	hold := defaultHostHold
+	if deadline, ok := ctx.Deadline(); ok {
+		hold = time.Until(deadline)
+	}
+
+	for {
+		wait, err := hosts.AcquireHost(ctx, host, id, limit, hold)
+		if errors.Is(err, cache.ErrHostBusy) {
+			wait, err = hostBusyDelay, nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if wait <= 0 {
+			return nil
+		}
+		if !sleep(ctx, wait) {
+			return ctx.Err()
+		}
+	}
+}
+
+// deferJob puts a job whose host is throttled back on the delay queue so the
+// worker can move on to other hosts. The tracker itself is left unchanged.
+func deferJob(ctx context.Context, store storage.Store, tracker *cache.URLTracker, wait time.Duration, logger *log.Logger) {
+	if err := store.ScheduleURL(ctx, tracker.ID, tracker.Priority, time.Now().Add(wait)); err != nil {
+		// Leave the job un-acked so its lease expires and it is retried.
+		logger.Println("defer throttled job:", err)
+		return
+	}
+	if err := store.AckURL(ctx, tracker.ID); err != nil {
+		logger.Println("ack:", err)
+	}
+	logger.Println("deferred", tracker.ID, "for", wait.Round(time.Millisecond), "host", hostOf(tracker.URL), "is throttled")
+}
+
+// requeue puts a job whose crawl was interrupted back on its lane as pending
+// and reports whether it did. The interrupted attempt does not count. If the
+// job cannot be requeued it stays leased until the lease expires.
+func requeue(ctx context.Context, store storage.Store, tracker *cache.URLTracker, logger *log.Logger) bool {
+	tracker, err := updateTracker(ctx, store, tracker, internal.StatusProcessing, func(t *cache.URLTracker) {
+		t.Status = internal.StatusPending
+		if t.Attempts > 0 {
+			t.Attempts--
+		}
+	})
+	if errors.Is(err, errSuperseded) {
+		logger.Println("not requeuing", tracker.ID, "now", tracker.Status)
+		if err := store.AckURL(ctx, tracker.ID); err != nil {
+			logger.Println("ack:", err)
+		}
+		return false
+	}
+	if err != nil {
+		logger.Println("requeue:", err)
+		return false
+	}
+
+	if err := store.EnqueueURL(ctx, tracker.ID, tracker.Priority); err != nil {
+		logger.Println("requeue:", err)
+		return false
+	}
+	if err := store.AckURL(ctx, tracker.ID); err != nil {
+		logger.Println("ack:", err)
+	}
+	return true
+}
+
+// maxConflictRetries bounds how often updateTracker re-applies a change after
+// losing a race with another writer.
+const maxConflictRetries = 3
+
+// errSuperseded is returned by updateTracker when another writer moved the
+// tracker out of the status the worker expected.
+var errSuperseded = errors.New("tracker changed by another writer")
+
+// updateTracker applies change to t and writes it. If another writer updated
+// the tracker in the meantime it reloads it and, as long as the tracker is
+// still in status from, applies change again on top of the fresh copy.
+// Otherwise it returns the fresh tracker and errSuperseded.
+func updateTracker(ctx context.Context, store storage.Store, t *cache.URLTracker, from string, change func(*cache.URLTracker)) (*cache.URLTracker, error) {
+	change(t)
+	for attempt := 0; ; attempt++ {
+		err := store.UpdateURL(ctx, t)
+		if !errors.Is(err, cache.ErrConflict) || attempt == maxConflictRetries {
+			return t, err
+		}
+
+		fresh, gErr := store.GetURL(ctx, t.ID)
+		if gErr != nil {
+			return t, gErr
+		}
+		if fresh.Status != from {
+			return fresh, errSuperseded
+		}
+		change(fresh)
+		t = fresh
+	}
 }
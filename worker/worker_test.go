@@ -5,16 +5,31 @@ import (
 	"errors"
 	"io"
 	"log"
+	"sync/atomic"
 	"testing"
+	"time"
 	"urltracker/internal"
 	"urltracker/internal/cache"
+	"urltracker/internal/storage"
 )
 
 type mockStore struct {
+	storage.Store
 	dequeue    []*cache.URLTracker
 	dequeueErr error
 	updateErr  error
+	conflicts  int
+	fresh      *cache.URLTracker
 	updates    []*cache.URLTracker
+	acked      []string
+	runs       []*cache.AnalysisRun
+	dead       []*cache.DeadLetter
+	scheduled  map[string]time.Time
+	hostWait   time.Duration
+	hostErr    error
+	acquired   []string
+	released   []string
+	extended   atomic.Int32
 }
 
 func (m *mockStore) DequeueURL(ctx context.Context) (*cache.URLTracker, error) {
@@ -30,11 +45,80 @@ func (m *mockStore) DequeueURL(ctx context.Context) (*cache.URLTracker, error) {
 }
 
 func (m *mockStore) UpdateURL(ctx context.Context, t *cache.URLTracker) error {
+	if m.conflicts > 0 {
+		m.conflicts--
+		return cache.ErrConflict
+	}
 	copy := *t
 	m.updates = append(m.updates, &copy)
 	return m.updateErr
 }
 
+func (m *mockStore) GetURL(ctx context.Context, id string) (*cache.URLTracker, error) {
+	copy := *m.fresh
+	return &copy, nil
+}
+
+func (m *mockStore) AddRun(ctx context.Context, id string, run *cache.AnalysisRun) error {
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *mockStore) AddDeadLetter(ctx context.Context, d *cache.DeadLetter) error {
+	m.dead = append(m.dead, d)
+	return nil
+}
+
+func (m *mockStore) ScheduleURL(ctx context.Context, id, priority string, at time.Time) error {
+	if m.scheduled == nil {
+		m.scheduled = make(map[string]time.Time)
+	}
+	m.scheduled[id] = at
+	return nil
+}
+
+func (m *mockStore) AcquireHost(ctx context.Context, host, id string, limit cache.HostLimit, hold time.Duration) (time.Duration, error) {
+	if m.hostWait == 0 && m.hostErr == nil {
+		m.acquired = append(m.acquired, host)
+	}
+	return m.hostWait, m.hostErr
+}
+
+func (m *mockStore) ReleaseHost(ctx context.Context, host, id string) error {
+	m.released = append(m.released, host)
+	return nil
+}
+
+func (m *mockStore) AckURL(ctx context.Context, id string) error {
+	m.acked = append(m.acked, id)
+	return nil
+}
+
+func (m *mockStore) ExtendLease(ctx context.Context, id string) error {
+	m.extended.Add(1)
+	return nil
+}
+
+func TestProcessJobExtendsLease(t *testing.T) {
+	store := &mockStore{}
+	logger := log.New(io.Discard, "", 0)
+
+	tracker := &cache.URLTracker{ID: "1", URL: "https://example.com"}
+	processJob(context.Background(), store, tracker, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		time.Sleep(50 * time.Millisecond)
+		return "ok", nil
+	}, jobOptions{heartbeat: 10 * time.Millisecond}, logger)
+
+	n := store.extended.Load()
+	if n < 2 {
+		t.Errorf("ExtendLease calls = %d, want the lease renewed during the crawl", n)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if store.extended.Load() != n {
+		t.Error("lease still extended after the job finished")
+	}
+}
+
 func TestProcessNextSuccess(t *testing.T) {
 	store := &mockStore{
 		dequeue: []*cache.URLTracker{
@@ -43,9 +127,9 @@ func TestProcessNextSuccess(t *testing.T) {
 	}
 	logger := log.New(io.Discard, "", 0)
 
-	processed, err := processNext(context.Background(), store, func(t *cache.URLTracker) (string, error) {
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
 		return "ok", nil
-	}, logger)
+	}, jobOptions{}, logger)
 	if err != nil {
 		t.Fatalf("processNext() error = %v", err)
 	}
@@ -67,6 +151,15 @@ func TestProcessNextSuccess(t *testing.T) {
 	if store.updates[1].Result != "ok" {
 		t.Errorf("second update result = %q, want %q", store.updates[1].Result, "ok")
 	}
+	if len(store.acked) != 1 || store.acked[0] != "1" {
+		t.Errorf("acked = %v, want [1]", store.acked)
+	}
+	if len(store.dead) != 0 {
+		t.Errorf("dead letters = %+v, want none", store.dead)
+	}
+	if len(store.runs) != 1 || store.runs[0].Status != internal.StatusCompleted || store.runs[0].Result != "ok" {
+		t.Errorf("runs = %+v, want one completed run with result", store.runs)
+	}
 }
 
 func TestProcessNextCrawlError(t *testing.T) {
@@ -77,9 +170,9 @@ func TestProcessNextCrawlError(t *testing.T) {
 	}
 	logger := log.New(io.Discard, "", 0)
 
-	processed, err := processNext(context.Background(), store, func(t *cache.URLTracker) (string, error) {
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
 		return "", errors.New("fail to crawl")
-	}, logger)
+	}, jobOptions{}, logger)
 	if err != nil {
 		t.Fatalf("processNext() error = %v", err)
 	}
@@ -97,15 +190,21 @@ func TestProcessNextCrawlError(t *testing.T) {
 	if store.updates[1].Error != "fail to crawl" {
 		t.Errorf("second update error = %q, want %q", store.updates[1].Error, "fail to crawl")
 	}
+	if len(store.runs) != 1 || store.runs[0].Error != "fail to crawl" {
+		t.Errorf("runs = %+v, want one failed run", store.runs)
+	}
+	if len(store.dead) != 1 || store.dead[0].Reason != cache.ReasonCrawlFailed || store.dead[0].Attempts != 1 || store.dead[0].LastError != "fail to crawl" {
+		t.Errorf("dead letters = %+v, want one crawl failure after 1 attempt", store.dead)
+	}
 }
 
 func TestProcessNextEmptyQueue(t *testing.T) {
 	store := &mockStore{}
 	logger := log.New(io.Discard, "", 0)
 
-	processed, err := processNext(context.Background(), store, func(t *cache.URLTracker) (string, error) {
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
 		return "", nil
-	}, logger)
+	}, jobOptions{}, logger)
 	if err != nil {
 		t.Fatalf("processNext() error = %v", err)
 	}
@@ -116,3 +215,246 @@ func TestProcessNextEmptyQueue(t *testing.T) {
 		t.Fatalf("UpdateURL calls = %d, want 0", len(store.updates))
 	}
 }
+
+func TestProcessNextFinalUpdateErrorSkipsAck(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "3", URL: "https://example.com"},
+		},
+		updateErr: errors.New("redis down"),
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return "ok", nil
+	}, jobOptions{}, logger)
+	if err != nil {
+		t.Fatalf("processNext() error = %v", err)
+	}
+	if !processed {
+		t.Fatal("processNext() expected processed=true")
+	}
+	if len(store.acked) != 0 {
+		t.Errorf("acked = %v, want none", store.acked)
+	}
+}
+
+func TestProcessNextReconcilesConflict(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "4", URL: "https://example.com", Status: internal.StatusPending, Revision: 1},
+		},
+		conflicts: 1,
+		// another writer bumped the priority while the job was queued
+		fresh: &cache.URLTracker{ID: "4", URL: "https://example.com", Status: internal.StatusPending, Priority: internal.PriorityInteractive, Revision: 2},
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return "ok", nil
+	}, jobOptions{}, logger)
+	if err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v, want true, nil", processed, err)
+	}
+
+	if len(store.updates) != 2 {
+		t.Fatalf("UpdateURL calls = %d, want 2", len(store.updates))
+	}
+	if u := store.updates[0]; u.Status != internal.StatusProcessing || u.Revision != 2 || u.Priority != internal.PriorityInteractive {
+		t.Errorf("first update = %+v, want processing on top of revision 2", u)
+	}
+	if u := store.updates[1]; u.Status != internal.StatusCompleted || u.Result != "ok" {
+		t.Errorf("second update = %+v, want completed", u)
+	}
+	if len(store.acked) != 1 {
+		t.Errorf("acked = %v, want [4]", store.acked)
+	}
+}
+
+func TestProcessNextSupersededSkipsCrawl(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "5", URL: "https://example.com", Status: internal.StatusPending, Revision: 1},
+		},
+		conflicts: 1,
+		fresh:     &cache.URLTracker{ID: "5", URL: "https://example.com", Status: internal.StatusCompleted, Revision: 2},
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	crawled := false
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		crawled = true
+		return "ok", nil
+	}, jobOptions{}, logger)
+	if err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v, want true, nil", processed, err)
+	}
+
+	if crawled {
+		t.Error("processNext() crawled a tracker another writer already finished")
+	}
+	if len(store.updates) != 0 {
+		t.Errorf("UpdateURL calls = %d, want 0", len(store.updates))
+	}
+	if len(store.acked) != 1 || store.acked[0] != "5" {
+		t.Errorf("acked = %v, want [5]", store.acked)
+	}
+}
+
+func TestProcessNextRetriesTransientErrors(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "6", URL: "https://example.com", Status: internal.StatusPending, Priority: internal.PriorityBulk},
+		},
+	}
+	logger := log.New(io.Discard, "", 0)
+	retry := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour}
+
+	before := time.Now()
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return "", &crawlError{StatusCode: 503, Err: errors.New("Service Unavailable")}
+	}, jobOptions{retry: retry}, logger)
+	if err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v, want true, nil", processed, err)
+	}
+
+	final := store.updates[len(store.updates)-1]
+	if final.Status != internal.StatusRetrying || final.Attempts != 1 {
+		t.Errorf("final update = %+v, want retrying after attempt 1", final)
+	}
+	at, ok := store.scheduled["6"]
+	if !ok {
+		t.Fatal("retry was not scheduled")
+	}
+	if at.Before(before.Add(30*time.Second)) || at.After(time.Now().Add(time.Minute)) {
+		t.Errorf("retry at %v, want within one base delay", at)
+	}
+	if !final.NotBefore.Equal(at) {
+		t.Errorf("NotBefore = %v, want %v", final.NotBefore, at)
+	}
+	if len(store.runs) != 1 || store.runs[0].Status != internal.StatusRetrying || store.runs[0].Attempt != 1 || !store.runs[0].RetryAt.Equal(at) {
+		t.Errorf("runs = %+v, want one retrying run for attempt 1", store.runs)
+	}
+	if len(store.dead) != 0 {
+		t.Errorf("dead letters = %d, want 0", len(store.dead))
+	}
+	if len(store.acked) != 1 {
+		t.Errorf("acked = %v, want [6]", store.acked)
+	}
+}
+
+func TestProcessNextGivesUpAfterMaxAttempts(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "7", URL: "https://example.com", Status: internal.StatusRetrying, Attempts: 2},
+		},
+	}
+	logger := log.New(io.Discard, "", 0)
+	retry := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour}
+
+	processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return "", &crawlError{StatusCode: 502, Err: errors.New("Bad Gateway")}
+	}, jobOptions{retry: retry}, logger)
+	if err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v, want true, nil", processed, err)
+	}
+
+	if final := store.updates[len(store.updates)-1]; final.Status != internal.StatusFailed || final.Attempts != 3 {
+		t.Errorf("final update = %+v, want failed after attempt 3", final)
+	}
+	if len(store.scheduled) != 0 {
+		t.Errorf("scheduled = %v, want none", store.scheduled)
+	}
+	if len(store.dead) != 1 || store.dead[0].Attempts != 3 {
+		t.Errorf("dead letters = %+v, want one after 3 attempts", store.dead)
+	}
+}
+
+func TestProcessNextDoesNotRetryPermanentErrors(t *testing.T) {
+	store := &mockStore{
+		dequeue: []*cache.URLTracker{
+			{ID: "8", URL: "https://example.com", Status: internal.StatusPending},
+		},
+	}
+	logger := log.New(io.Discard, "", 0)
+	retry := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour}
+
+	_, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+		return "", &crawlError{StatusCode: 404, Err: errors.New("Not Found")}
+	}, jobOptions{retry: retry}, logger)
+	if err != nil {
+		t.Fatalf("processNext() error = %v", err)
+	}
+
+	if final := store.updates[len(store.updates)-1]; final.Status != internal.StatusFailed {
+		t.Errorf("final status = %q, want %q", final.Status, internal.StatusFailed)
+	}
+	if len(store.scheduled) != 0 || len(store.dead) != 1 {
+		t.Errorf("scheduled = %v, dead = %d, want no retry and one dead letter", store.scheduled, len(store.dead))
+	}
+}
+
+func TestProcessNextHostLimits(t *testing.T) {
+	limits := cache.HostLimits{Default: cache.HostLimit{Rate: 1, Burst: 1, Concurrency: 1}}
+
+	tests := []struct {
+		name      string
+		wait      time.Duration
+		err       error
+		wantDelay time.Duration
+	}{
+		{"allowed", 0, nil, 0},
+		{"rate limited", 3 * time.Second, nil, 3 * time.Second},
+		{"too many in flight", 0, cache.ErrHostBusy, hostBusyDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &mockStore{
+				dequeue: []*cache.URLTracker{
+					{ID: "9", URL: "https://Example.com:8443/page", Status: internal.StatusPending},
+				},
+				hostWait: tt.wait,
+				hostErr:  tt.err,
+			}
+			logger := log.New(io.Discard, "", 0)
+
+			crawled := false
+			before := time.Now()
+			processed, err := processNext(context.Background(), context.Background(), store, func(ctx context.Context, t *cache.URLTracker) (string, error) {
+				crawled = true
+				return "ok", nil
+			}, jobOptions{limits: limits}, logger)
+			if err != nil || !processed {
+				t.Fatalf("processNext() = %v, %v, want true, nil", processed, err)
+			}
+			if len(store.acked) != 1 {
+				t.Errorf("acked = %v, want [9]", store.acked)
+			}
+
+			if tt.wantDelay == 0 {
+				if !crawled {
+					t.Error("processNext() did not crawl an allowed job")
+				}
+				if len(store.acquired) != 1 || store.acquired[0] != "example.com" || len(store.released) != 1 {
+					t.Errorf("acquired = %v, released = %v, want example.com once each", store.acquired, store.released)
+				}
+				return
+			}
+
+			if crawled {
+				t.Error("processNext() crawled a throttled job")
+			}
+			if len(store.updates) != 0 {
+				t.Errorf("UpdateURL calls = %d, want the tracker left alone", len(store.updates))
+			}
+			at, ok := store.scheduled["9"]
+			if !ok || at.Before(before.Add(tt.wantDelay)) || at.After(time.Now().Add(tt.wantDelay)) {
+				t.Errorf("scheduled = %v, want deferred by %v", store.scheduled, tt.wantDelay)
+			}
+			if len(store.released) != 0 {
+				t.Errorf("released = %v, want none", store.released)
+			}
+		})
+	}
+}